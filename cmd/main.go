@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/marlinprotocol/p2psim/core"
@@ -21,16 +22,11 @@ See https://github.com/libp2p/specs/blob/master/pubsub/gossipsub/gossipsub-v1.0.
 
 TODO:
 
-- make topology configurable
-- make latency configurable
 - log events
   - separates stat computation logic from simulation
   - allowing us to compute better statistics such as the 90th percentile delay and so on
 - support multiple simulations in a single run
-- make random seed configurable
 - make logger configurable
-- add support for topics
-- support for fanout topics in gossip
 
 */
 
@@ -147,6 +143,23 @@ func loadConfig(ctx *cli.Context) (*sim.Config, error) {
 func printStats(stats *core.Stats) {
 	log.Println("Mean packet count:", stats.PacketCountPerMsg)
 	log.Println("Mean traffic:", stats.TrafficPerMsg)
+	log.Println("Mean duplicate traffic:", stats.DuplicateTrafficPerMsg)
 	log.Println("Mean delay:", time.Duration(stats.DelayMsPerMsg.Value)*time.Millisecond)
 	log.Println("Delivered Percent:", stats.DeliveredPart)
+	if stats.ChunkCountPerMsg.Value > 0 {
+		log.Println("Mean chunk count:", stats.ChunkCountPerMsg)
+		log.Println("Mean chunk delay:", time.Duration(stats.ChunkDelayMsPerMsg.Value)*time.Millisecond)
+	}
+
+	// topics are printed in a fixed order
+	topics := []string{}
+	for topic := range stats.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		topicStats := stats.Topics[topic]
+		log.Printf("Topic %v:\n", topic)
+		printStats(&topicStats)
+	}
 }
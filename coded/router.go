@@ -0,0 +1,254 @@
+package coded
+
+import (
+	"errors"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"go.uber.org/zap"
+	exprand "golang.org/x/exp/rand"
+)
+
+// Erasure coded broadcast, as the block propagation of Marlin and Solana's Turbine
+//
+// The publisher codes every message into data_chunks data and parity_chunks parity chunks
+//   and scatters the chunks over its peers subscribed to the topic, i.e., distinct chunks go to different peers
+// A node relays every chunk it receives for the first time to fanout random peers subscribed to the topic
+//   that are not known to have the chunk, i.e., the peers that sent it
+// A node decodes the message once any data_chunks distinct chunks arrive, as with Reed-Solomon codes
+//   the decoding is not simulated and takes no time
+
+var (
+	InvHistErr   = errors.New("History length must be positive!")
+	InvDataErr   = errors.New("Number of data chunks must be positive!")
+	InvParityErr = errors.New("Number of parity chunks cannot be negative!")
+	InvFanoutErr = errors.New("Fanout must be positive!")
+)
+
+var (
+	// default config params
+	HeartbeatInterval = 1 * time.Second
+	HeartbeatPriority = int(core.DefaultPriority)
+	HistoryLength     = 5
+	DataChunks        = 16
+	ParityChunks      = 8
+	Fanout            = 6
+)
+
+type Router struct {
+	// coded config params
+	cfg *Config
+
+	// source of randomness picking the peers the chunks are sent to
+	rng exprand.Source
+
+	// initialized while initializing the pubsub node
+	node *pubsub.Node
+
+	// MsgID -> chunks of the message received
+	// forgotten after history_length heartbeats
+	msgs map[pubsub.MsgID]*codedMsg
+
+	// triggers the heartbeats, stopped on leaving the network
+	ticker *core.Ticker
+}
+
+type codedMsg struct {
+	// chunk index -> set of peers known to have the chunk
+	// underlying type => int64 (peer ID)
+	// only the chunks received are present
+	holders map[int64]*core.Set
+	// time the first chunk arrived
+	time time.Time
+}
+
+type Config struct {
+	// Interval between consecutive heartbeats
+	// The chunks of old messages are forgotten on every heartbeat
+	HeartbeatInterval *time.Duration `toml:"heartbeat_interval,omitempty"`
+
+	// Negative values forget the old chunks before the chunks arriving at the same instant and positive values after
+	HeartbeatPriority *int `toml:"heartbeat_priority,omitempty"`
+
+	// Number of heartbeat events for which the chunks of a message are remembered
+	HistoryLength *int `toml:"history_length,omitempty"`
+
+	// Number of chunks needed to decode a message
+	// Every chunk carries 1/data_chunks of the message
+	DataChunks *int `toml:"data_chunks,omitempty"`
+
+	// Number of chunks coded in addition to the data chunks
+	// The traffic grows by a factor of (data_chunks + parity_chunks) / data_chunks in exchange for tolerating lost chunks
+	ParityChunks *int `toml:"parity_chunks,omitempty"`
+
+	// Number of peers every chunk is relayed to
+	// The default relays as many chunks as gossipsub sends copies of a message to its mesh peers
+	Fanout *int `toml:"fanout,omitempty"`
+}
+
+func GetDefaultConfig() *Config {
+	return &Config{
+		HeartbeatInterval: &HeartbeatInterval,
+		HeartbeatPriority: &HeartbeatPriority,
+		HistoryLength:     &HistoryLength,
+		DataChunks:        &DataChunks,
+		ParityChunks:      &ParityChunks,
+		Fanout:            &Fanout,
+	}
+}
+
+func NewRouter(cfg *Config, rng exprand.Source) *Router {
+	return &Router{
+		cfg:  cfg,
+		rng:  rng,
+		node: nil,
+		msgs: map[pubsub.MsgID]*codedMsg{},
+	}
+}
+
+func (router *Router) Start(node *pubsub.Node, logger *zap.Logger) error {
+	var err error
+
+	if *router.cfg.HistoryLength <= 0 {
+		return InvHistErr
+	}
+	if *router.cfg.DataChunks <= 0 {
+		return InvDataErr
+	}
+	if *router.cfg.ParityChunks < 0 {
+		return InvParityErr
+	}
+	if *router.cfg.Fanout <= 0 {
+		return InvFanoutErr
+	}
+
+	router.node = node
+
+	// Start timer for heartbeats
+	router.ticker, err = core.StartTicker(
+		node.Sched,
+		*router.cfg.HeartbeatInterval,
+		core.Priority(*router.cfg.HeartbeatPriority),
+		router,
+		logger,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Scatters the chunks of the message over the peers subscribed to the topic in random order
+// Only the messages published locally are complete here, the others arrive as chunks
+func (router *Router) PublishMsg(srcID int64, msg pubsub.Message) {
+	targetIDs := router.getRandomNeighbors(func(neighborID int64) bool {
+		return router.node.PeerSubscribed(neighborID, msg.Topic()) && neighborID != srcID && neighborID != msg.From()
+	})
+	if len(targetIDs) == 0 {
+		return
+	}
+	numChunks := *router.cfg.DataChunks + *router.cfg.ParityChunks
+	for index := 0; index < numChunks; index++ {
+		chunk := NewChunkMsg(msg, int64(index), int64(*router.cfg.DataChunks))
+		router.node.SendRPC(targetIDs[index%len(targetIDs)], chunk)
+	}
+}
+
+// Relays the chunks received for the first time and decodes the message once enough chunks arrive
+func (router *Router) HandleRPC(srcID int64, rpcMsg pubsub.RPC) {
+	chunk, ok := rpcMsg.(*ChunkMsg)
+	if !ok {
+		return
+	}
+	msg := chunk.msg
+	// not interested in the messages of topics that are not subscribed to
+	if !router.node.Topics.Exists(msg.Topic()) {
+		return
+	}
+
+	msgID := pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}
+	coded, exists := router.msgs[msgID]
+	if !exists {
+		// published locally or decoded and forgotten already
+		if router.node.SeenMsgs.SeenMsg(msgID) {
+			return
+		}
+		coded = &codedMsg{
+			holders: map[int64]*core.Set{},
+			time:    router.node.Sched.CurTime,
+		}
+		router.msgs[msgID] = coded
+	}
+	if holders, received := coded.holders[chunk.index]; received {
+		holders.Add(srcID)
+		return
+	}
+	coded.holders[chunk.index] = core.NewSet(srcID)
+
+	targetIDs := router.getRandomNeighbors(func(neighborID int64) bool {
+		if !router.node.PeerSubscribed(neighborID, msg.Topic()) || neighborID == msg.From() {
+			return false
+		}
+		return !coded.holders[chunk.index].Exists(neighborID)
+	})
+	for i := 0; i < len(targetIDs) && i < *router.cfg.Fanout; i++ {
+		router.node.SendRPC(targetIDs[i], chunk)
+		coded.holders[chunk.index].Add(targetIDs[i])
+	}
+
+	if len(coded.holders) == *router.cfg.DataChunks {
+		// decoded, the later chunks are still relayed
+		router.node.SeenMsgs.MarkSeen(msgID, router.node.Sched.CurTime)
+	}
+}
+
+// Returns the neighbors passing the filter in random order
+func (router *Router) getRandomNeighbors(filter func(int64) bool) []int64 {
+	neighborIDs := []int64{}
+	router.node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		if filter(neighborID) {
+			neighborIDs = append(neighborIDs, neighborID)
+		}
+	})
+
+	exprand.New(router.rng).Shuffle(len(neighborIDs), func(i, j int) {
+		neighborIDs[i], neighborIDs[j] = neighborIDs[j], neighborIDs[i]
+	})
+	return neighborIDs
+}
+
+// Chunks are relayed to the new peer from the next chunk on
+func (router *Router) AddPeer(remoteID int64) {}
+
+func (router *Router) RemovePeer(remoteID int64) {}
+
+func (router *Router) Stop() {
+	router.ticker.Stop()
+}
+
+func (router *Router) Join(topic string) {}
+
+func (router *Router) Leave(topic string) {}
+
+func (router *Router) HandleSubscription(remoteID int64, topic string, subscribe bool) {}
+
+func (router *Router) AcceptFrom(srcID int64) bool {
+	return true
+}
+
+// Forgets the chunks of the messages first received history_length heartbeats ago
+func (router *Router) HandleTick() {
+	ttl := time.Duration(*router.cfg.HistoryLength) * *router.cfg.HeartbeatInterval
+	for msgID, coded := range router.msgs {
+		if !coded.time.Add(ttl).After(router.node.Sched.CurTime) {
+			delete(router.msgs, msgID)
+		}
+	}
+}
+
+func (router *Router) ID() int64 {
+	return router.node.ID()
+}
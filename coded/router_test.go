@@ -0,0 +1,61 @@
+package coded
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"github.com/marlinprotocol/p2psim/pubsub/pubsubtest"
+	exprand "golang.org/x/exp/rand"
+)
+
+func TestInvConfig(t *testing.T) {
+	zero := 0
+	negative := -1
+	newRouter := func(update func(*Config)) pubsub.Router {
+		cfg := GetDefaultConfig()
+		update(cfg)
+		return NewRouter(cfg, exprand.NewSource(55))
+	}
+	pubsubtest.CheckInvRouters(t, []pubsubtest.InvRouter{
+		{Router: newRouter(func(cfg *Config) { cfg.HistoryLength = &zero }), Err: InvHistErr},
+		{Router: newRouter(func(cfg *Config) { cfg.DataChunks = &zero }), Err: InvDataErr},
+		{Router: newRouter(func(cfg *Config) { cfg.ParityChunks = &negative }), Err: InvParityErr},
+		{Router: newRouter(func(cfg *Config) { cfg.Fanout = &zero }), Err: InvFanoutErr},
+	})
+}
+
+// 0 scatters the 3 chunks of a message over 1, 2 and 3, all connected to 4
+// every node needs any 2 of the chunks
+func TestScatterDecode(t *testing.T) {
+	cfg := GetDefaultConfig()
+	dataChunks, parityChunks := 2, 1
+	cfg.DataChunks, cfg.ParityChunks = &dataChunks, &parityChunks
+	edges := [][2]int64{{0, 1}, {0, 2}, {0, 3}, {1, 4}, {2, 4}, {3, 4}}
+	sched, _, nodes, _ := pubsubtest.SpawnNodes(t, 5, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, edges, true)
+
+	msgID := pubsub.MsgID{From: 0, Seqno: 1}
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+
+	// a single chunk is not enough
+	sched.RunFor(15 * time.Millisecond)
+	for _, node := range nodes[1:] {
+		if node.SeenMsgs.SeenMsg(msgID) {
+			t.Fatalf("Node %v decoded the message from a single chunk", node.ID())
+		}
+	}
+
+	// 4 decodes the message from the chunks relayed by 1, 2 and 3 and relays the chunks back
+	sched.RunFor(10 * time.Millisecond)
+	if !nodes[4].SeenMsgs.SeenMsg(msgID) {
+		t.Fatalf("Node 4 did not decode the message")
+	}
+	sched.RunFor(10 * time.Millisecond)
+	for _, node := range nodes[1:4] {
+		if !node.SeenMsgs.SeenMsg(msgID) {
+			t.Errorf("Node %v did not decode the message", node.ID())
+		}
+	}
+}
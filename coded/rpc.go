@@ -0,0 +1,45 @@
+package coded
+
+import (
+	"github.com/marlinprotocol/p2psim/pubsub"
+)
+
+// Carries a single chunk of an erasure coded message
+// Any data_chunks distinct chunks of the message are enough to decode it
+type ChunkMsg struct {
+	msg        pubsub.Message
+	index      int64
+	dataChunks int64
+	size       int64
+}
+
+// Every chunk carries an equal share of the message
+func NewChunkMsg(msg pubsub.Message, index int64, dataChunks int64) *ChunkMsg {
+	return &ChunkMsg{
+		msg:        msg,
+		index:      index,
+		dataChunks: dataChunks,
+		size:       (msg.GetSize() + dataChunks - 1) / dataChunks,
+	}
+}
+
+func (chunk *ChunkMsg) GetSize() int64 {
+	return chunk.size
+}
+
+// The message is complete only once enough chunks arrive (see pubsub.Chunk)
+func (chunk *ChunkMsg) GetMessages() []pubsub.Message {
+	return []pubsub.Message{}
+}
+
+func (chunk *ChunkMsg) Msg() pubsub.Message {
+	return chunk.msg
+}
+
+func (chunk *ChunkMsg) Index() int64 {
+	return chunk.index
+}
+
+func (chunk *ChunkMsg) ChunksNeeded() int64 {
+	return chunk.dataChunks
+}
@@ -68,6 +68,26 @@ func NewBlockGenerator(
 
 func (oracle *OracleBlockGenerator) AddPublisher(publisher BlockPublisher) {
 	oracle.publishers = append(oracle.publishers, publisher)
+	oracle.updateSelector()
+}
+
+// Called when the publisher leaves the network
+// The remaining publishers retain their order
+func (oracle *OracleBlockGenerator) RemovePublisher(publisher BlockPublisher) {
+	for i, pub := range oracle.publishers {
+		if pub.ID() == publisher.ID() {
+			oracle.publishers = append(oracle.publishers[:i], oracle.publishers[i+1:]...)
+			break
+		}
+	}
+	oracle.updateSelector()
+}
+
+func (oracle *OracleBlockGenerator) updateSelector() {
+	if len(oracle.publishers) == 0 {
+		oracle.pubSelector = nil
+		return
+	}
 	oracle.pubSelector = &distuv.Uniform{
 		Min: 0.0,
 		Max: float64(len(oracle.publishers)),
@@ -83,6 +103,12 @@ func (oracle *OracleBlockGenerator) oracleNewBlock() {
 }
 
 func (oracle *OracleBlockGenerator) PublishNewBlock() {
+	// no block is published while every publisher is offline
+	if len(oracle.publishers) == 0 {
+		oracle.oracleNewBlock()
+		return
+	}
+
 	selectedID := int(math.Round(oracle.pubSelector.Rand()))
 	if selectedID < 0 {
 		selectedID = 0
@@ -105,3 +105,34 @@ func TestSelector(t *testing.T) {
 		}
 	}
 }
+
+func TestRemovePublisher(t *testing.T) {
+	nullLogger := zap.L()
+
+	sched, _ := NewScheduler(time.Hour)
+	oracle, _ := NewBlockGenerator(sched, time.Second, exprand.NewSource(84), nullLogger)
+
+	nodes := []*BlockPubNode{}
+	for i := 0; i < 3; i++ {
+		node := &BlockPubNode{
+			counter: 0,
+			id:      i,
+		}
+		nodes = append(nodes, node)
+		oracle.AddPublisher(node)
+	}
+	oracle.RemovePublisher(nodes[1])
+	sched.RunFor(30 * time.Minute)
+	if nodes[1].counter != 0 {
+		t.Errorf("Removed publisher published %v blocks", nodes[1].counter)
+	}
+
+	// blocks are not published without publishers
+	oracle.RemovePublisher(nodes[0])
+	oracle.RemovePublisher(nodes[2])
+	counters := nodes[0].counter + nodes[2].counter
+	sched.Run()
+	if nodes[0].counter+nodes[2].counter != counters {
+		t.Errorf("Published blocks without publishers")
+	}
+}
@@ -8,6 +8,8 @@ import (
 
 // The current simulation application is implemented as a single-threaded dispatch of events
 // The scheduler schedules these events by running for a total of the specified duration
+// The simulation can also be advanced incrementally (event by event, up to a time or while a condition holds)
+//   allowing tests to inspect the state of the nodes in between
 // Currently the scheduler only supports scheduling events after a specific time duration which is sufficent for
 //   our current purposes
 // The events are triggerred in the chronological order assuming that the events are being scheduled into the future
@@ -16,25 +18,50 @@ import (
 // - simulate network latency by scheduling a message receive after the latency duration has expired
 // - simulate heartbeats by firing a beat event after the heartbeat interval
 // - simulate block generation by scheduling generation events
+// - simulate timeouts by cancelling or rescheduling a pending event through the task returned on scheduling
 //
-// TODO: Support cancelling schedules
+// Events triggered at the same instant are ordered by their priority class and then in the order they were scheduled
+//   latencies are rounded to milliseconds and such ties are common
+//   lower priority values are triggered first, ex: heartbeats with a negative priority run before message deliveries
+//
+// The task queue is an indexed heap, i.e, every task knows its position in the heap
+//   cancelling and rescheduling are hence logarithmic in the number of pending tasks
 
 var (
 	NegSimDurErr = errors.New("Simulation duration cannot be negative!")
 )
 
+const (
+	notPending = -1
+)
+
+// Priority class of an event among events triggered at the same instant
+type Priority int
+
+const (
+	DefaultPriority Priority = 0
+)
+
 type Scheduler struct {
 	taskQ        TaskQueue
 	endTime      time.Time
 	CurTime      time.Time // useful for interval calculations (do not use for absolute time)
 	NumTriggered int64     // doesn't include incomplete events
+	nextSeqno    uint64    // breaks ties between events of the same priority at the same instant
+	paused       bool      // set by events to return from the ongoing run
 }
 
 type TaskQueue []*Task
 
+// Handle to a scheduled event
 type Task struct {
 	triggerTime time.Time
+	priority    Priority
+	seqno       uint64
 	event       Event
+	sched       *Scheduler
+	// position in the task queue, notPending once the task is triggered or cancelled
+	index int
 }
 
 type Event interface {
@@ -53,38 +80,125 @@ func NewScheduler(dur time.Duration) (*Scheduler, error) {
 		CurTime:      epoch,
 		endTime:      endTime,
 		NumTriggered: 0,
+		nextSeqno:    0,
+		paused:       false,
 	}
 	return sched, nil
 }
 
+// Runs the simulation to completion
 func (sched *Scheduler) Run() {
-	// loop until no more tasks remain
+	sched.RunUntil(sched.endTime)
+}
+
+// Triggers the next event
+// Returns false if no event remains before the end time
+func (sched *Scheduler) Step() bool {
+	return sched.stepBefore(sched.endTime)
+}
+
+// Triggers all the events before `until` (not inclusive) and advances the current time to `until`
+// The simulation does not run past its end time
+// Returns early if paused by one of the triggered events
+func (sched *Scheduler) RunUntil(until time.Time) {
+	if sched.endTime.Before(until) {
+		until = sched.endTime
+	}
+	sched.paused = false
+	for !sched.paused && sched.stepBefore(until) {
+	}
+	if !sched.paused && sched.CurTime.Before(until) {
+		sched.CurTime = until
+	}
+}
+
+// Runs the simulation for the given duration from the current time
+func (sched *Scheduler) RunFor(dur time.Duration) {
+	sched.RunUntil(sched.CurTime.Add(dur))
+}
+
+// Triggers events one at a time as long as the condition holds
+//   ex: stop once all the nodes have received a particular message
+// The condition is checked before triggering every event
+// Returns early if paused by one of the triggered events
+func (sched *Scheduler) RunWhile(cond func() bool) {
+	sched.paused = false
+	for !sched.paused && cond() && sched.Step() {
+	}
+}
+
+// Called from within an event to return from the ongoing run after the event is triggered
+// The simulation resumes on the next run
+func (sched *Scheduler) Pause() {
+	sched.paused = true
+}
+
+func (sched *Scheduler) stepBefore(until time.Time) bool {
 	// new tasks are added via the schedule function
-	for len(sched.taskQ) > 0 {
-		task := heap.Pop(&sched.taskQ).(*Task)
-		sched.CurTime = task.triggerTime
-		if !sched.CurTime.Before(sched.endTime) {
-			// end simulation because we are past our time
-			// endTime is not inclusive
-			break
-		}
-		task.event.Trigger()
-		sched.NumTriggered++
+	if len(sched.taskQ) == 0 || !sched.taskQ[0].triggerTime.Before(until) {
+		return false
 	}
+	task := heap.Pop(&sched.taskQ).(*Task)
+	sched.CurTime = task.triggerTime
+	task.event.Trigger()
+	sched.NumTriggered++
+	return true
 }
 
-func (sched *Scheduler) Schedule(after time.Duration, event Event) {
+// The returned task can be used to cancel or reschedule the event as long as it is pending
+func (sched *Scheduler) Schedule(after time.Duration, event Event) *Task {
+	return sched.ScheduleWithPriority(after, DefaultPriority, event)
+}
+
+func (sched *Scheduler) ScheduleWithPriority(after time.Duration, priority Priority, event Event) *Task {
 	// schedule for later execution
-	heap.Push(&sched.taskQ, &Task{
+	task := &Task{
 		triggerTime: sched.CurTime.Add(after),
+		priority:    priority,
 		event:       event,
-	})
+		sched:       sched,
+		index:       notPending,
+	}
+	sched.push(task)
+	return task
+}
+
+func (sched *Scheduler) push(task *Task) {
+	task.seqno = sched.nextSeqno
+	sched.nextSeqno++
+	heap.Push(&sched.taskQ, task)
 }
 
 func (sched *Scheduler) IsStopped() bool {
 	return len(sched.taskQ) == 0 || !sched.CurTime.Before(sched.endTime)
 }
 
+// Returns true if the event was pending and is now cancelled
+func (task *Task) Cancel() bool {
+	if !task.IsPending() {
+		return false
+	}
+	heap.Remove(&task.sched.taskQ, task.index)
+	return true
+}
+
+// Triggers the event after the duration from the current time instead of the previously scheduled time
+// Tasks that were already triggered or cancelled are scheduled again
+// The task is ordered as if it was freshly scheduled among the events triggered at the same instant
+func (task *Task) Reschedule(after time.Duration) {
+	task.Cancel()
+	task.triggerTime = task.sched.CurTime.Add(after)
+	task.sched.push(task)
+}
+
+func (task *Task) IsPending() bool {
+	return task.index != notPending
+}
+
+func (task *Task) TriggerTime() time.Time {
+	return task.triggerTime
+}
+
 /////////////
 // Implement heap.Interface methods
 
@@ -94,21 +208,34 @@ func (taskQ TaskQueue) Len() int {
 
 func (taskQ TaskQueue) Less(i, j int) bool {
 	// task with lower time is popped first
-	return taskQ[i].triggerTime.Before(taskQ[j].triggerTime)
+	if !taskQ[i].triggerTime.Equal(taskQ[j].triggerTime) {
+		return taskQ[i].triggerTime.Before(taskQ[j].triggerTime)
+	}
+	if taskQ[i].priority != taskQ[j].priority {
+		return taskQ[i].priority < taskQ[j].priority
+	}
+	// first in, first out
+	return taskQ[i].seqno < taskQ[j].seqno
 }
 
 func (taskQ TaskQueue) Swap(i, j int) {
 	taskQ[i], taskQ[j] = taskQ[j], taskQ[i]
+	taskQ[i].index = i
+	taskQ[j].index = j
 }
 
 func (taskQP *TaskQueue) Push(x interface{}) {
-	(*taskQP) = append((*taskQP), x.(*Task))
+	task := x.(*Task)
+	task.index = len(*taskQP)
+	(*taskQP) = append((*taskQP), task)
 }
 
 func (taskQP *TaskQueue) Pop() interface{} {
 	taskQ := *taskQP
 	numTasks := len(taskQ)
 	task := taskQ[numTasks-1]    // take last element before popping
+	taskQ[numTasks-1] = nil      // allow the task to be garbage collected
 	*taskQP = taskQ[:numTasks-1] // pop the last element
+	task.index = notPending
 	return task
 }
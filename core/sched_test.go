@@ -87,3 +87,198 @@ func TestGenerativeEvents(t *testing.T) {
 		t.Error("Generated the event an incorrect number of times!")
 	}
 }
+
+func TestCancel(t *testing.T) {
+	sched, _ := NewScheduler(time.Minute)
+	cancelled := &SetEvent{
+		flag: false,
+	}
+	kept := &SetEvent{
+		flag: false,
+	}
+	task := sched.Schedule(2*time.Second, cancelled)
+	sched.Schedule(time.Second, kept)
+	if !task.IsPending() {
+		t.Error("Scheduled task must be pending")
+	}
+	if !task.Cancel() {
+		t.Error("Could not cancel a pending task")
+	}
+	if task.Cancel() {
+		t.Error("Cancelled an already cancelled task")
+	}
+	sched.Run()
+	if cancelled.flag {
+		t.Error("Triggered a cancelled event")
+	}
+	if !kept.flag {
+		t.Error("Cancelling a task affected another task")
+	}
+	if sched.NumTriggered != 1 {
+		t.Errorf("Triggered %v events, expected 1", sched.NumTriggered)
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	order := []int{}
+	sched, _ := NewScheduler(time.Minute)
+	tasks := []*Task{}
+	for _, seqno := range []int{1, 2, 3} {
+		tasks = append(tasks, sched.Schedule(time.Duration(seqno)*time.Second, &ChronoEvent{
+			order: &order,
+			seqno: seqno,
+		}))
+	}
+	// postpone the first event beyond the others
+	tasks[0].Reschedule(5 * time.Second)
+	if !tasks[0].TriggerTime().Equal(time.Time{}.Add(5 * time.Second)) {
+		t.Errorf("Rescheduled to an incorrect time: %v", tasks[0].TriggerTime())
+	}
+	sched.Run()
+	expected := []int{2, 3, 1}
+	if len(order) != len(expected) {
+		t.Fatalf("Got %v, expected %v", order, expected)
+	}
+	for i := range order {
+		if order[i] != expected[i] {
+			t.Fatalf("Got %v, expected %v", order, expected)
+		}
+	}
+	if tasks[0].IsPending() {
+		t.Error("Triggered task must not be pending")
+	}
+}
+
+func TestSimultaneousFIFO(t *testing.T) {
+	order := []int{}
+	sched, _ := NewScheduler(time.Minute)
+	numEvents := 64
+	for seqno := 0; seqno < numEvents; seqno++ {
+		sched.Schedule(time.Second, &ChronoEvent{
+			order: &order,
+			seqno: seqno,
+		})
+	}
+	sched.Run()
+	if len(order) != numEvents || !sort.IntsAreSorted(order) {
+		t.Errorf("Simultaneous events were not triggered in FIFO order: %v", order)
+	}
+}
+
+func TestSimultaneousPriority(t *testing.T) {
+	order := []int{}
+	sched, _ := NewScheduler(time.Minute)
+	// seqno doubles as the expected position
+	sched.ScheduleWithPriority(time.Second, 1, &ChronoEvent{order: &order, seqno: 3})
+	sched.Schedule(time.Second, &ChronoEvent{order: &order, seqno: 1})
+	sched.ScheduleWithPriority(time.Second, -1, &ChronoEvent{order: &order, seqno: 0})
+	sched.Schedule(time.Second, &ChronoEvent{order: &order, seqno: 2})
+	// earlier events are triggered first irrespective of their priority
+	sched.ScheduleWithPriority(2*time.Second, -1, &ChronoEvent{order: &order, seqno: 4})
+	sched.Run()
+	if len(order) != 5 || !sort.IntsAreSorted(order) {
+		t.Errorf("Simultaneous events were not triggered in the order of priority: %v", order)
+	}
+}
+
+func TestStep(t *testing.T) {
+	order := []int{}
+	sched, _ := NewScheduler(time.Minute)
+	for _, seqno := range []int{1, 2} {
+		sched.Schedule(time.Duration(seqno)*time.Second, &ChronoEvent{
+			order: &order,
+			seqno: seqno,
+		})
+	}
+	for i := 1; i <= 2; i++ {
+		if !sched.Step() {
+			t.Fatal("Could not step through a pending event")
+		}
+		if len(order) != i || !sched.CurTime.Equal(time.Time{}.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("Incorrect state after step %v: %v at %v", i, order, sched.CurTime)
+		}
+	}
+	if sched.Step() {
+		t.Error("Stepped without any pending events")
+	}
+}
+
+func TestRunUntil(t *testing.T) {
+	order := []int{}
+	sched, _ := NewScheduler(time.Minute)
+	for _, seqno := range []int{1, 2, 3, 70} {
+		sched.Schedule(time.Duration(seqno)*time.Second, &ChronoEvent{
+			order: &order,
+			seqno: seqno,
+		})
+	}
+	epoch := time.Time{}
+
+	// until is not inclusive
+	sched.RunUntil(epoch.Add(2 * time.Second))
+	if len(order) != 1 || !sched.CurTime.Equal(epoch.Add(2*time.Second)) {
+		t.Errorf("Incorrect state after running until 2s: %v at %v", order, sched.CurTime)
+	}
+
+	sched.RunFor(10 * time.Second)
+	if len(order) != 3 || !sched.CurTime.Equal(epoch.Add(12*time.Second)) {
+		t.Errorf("Incorrect state after running for 10s: %v at %v", order, sched.CurTime)
+	}
+	if sched.IsStopped() {
+		t.Error("Scheduler incorrectly reported as stopped")
+	}
+
+	// cannot run past the end time
+	sched.RunFor(time.Hour)
+	if len(order) != 3 || !sched.CurTime.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("Incorrect state after running past the end: %v at %v", order, sched.CurTime)
+	}
+	if !sched.IsStopped() {
+		t.Error("Scheduler incorrectly reported as running")
+	}
+}
+
+func TestRunWhile(t *testing.T) {
+	sched, _ := NewScheduler(time.Minute)
+	sched.Schedule(time.Duration(0), &Generator{
+		sched: sched,
+	})
+	sched.RunWhile(func() bool {
+		return sched.NumTriggered < 5
+	})
+	if sched.NumTriggered != 5 {
+		t.Errorf("Triggered %v events, expected 5", sched.NumTriggered)
+	}
+
+	// resume the simulation
+	sched.Run()
+	if sched.NumTriggered != 60 {
+		t.Errorf("Triggered %v events, expected 60", sched.NumTriggered)
+	}
+}
+
+type PauseEvent struct {
+	sched *Scheduler
+}
+
+func (event *PauseEvent) Trigger() {
+	event.sched.Pause()
+}
+
+func TestPause(t *testing.T) {
+	order := []int{}
+	sched, _ := NewScheduler(time.Minute)
+	sched.Schedule(time.Second, &ChronoEvent{order: &order, seqno: 1})
+	sched.Schedule(2*time.Second, &PauseEvent{sched: sched})
+	sched.Schedule(3*time.Second, &ChronoEvent{order: &order, seqno: 3})
+
+	sched.Run()
+	if len(order) != 1 || !sched.CurTime.Equal(time.Time{}.Add(2*time.Second)) {
+		t.Errorf("Incorrect state after pausing: %v at %v", order, sched.CurTime)
+	}
+
+	sched.Run()
+	if len(order) != 2 || !sched.IsStopped() {
+		t.Errorf("Incorrect state after resuming: %v at %v", order, sched.CurTime)
+	}
+}
@@ -1,29 +1,50 @@
 package core
 
 // Generic set data structure with a simple and intuitive interface
+// Elements are traversed in a deterministic order so that simulations using the same seed are reproducible
+//   iterating over a map directly would visit the elements in a different order on every run
+// The order is the insertion order until an element is removed
+//   the last element then takes the place of the removed element
 
 type Set struct {
-	elems map[interface{}]struct{}
+	// element -> position in elemList
+	elems map[interface{}]int
+	// elements in traversal order
+	elemList []interface{}
 }
 
 func NewSet(elems ...interface{}) *Set {
-	set := map[interface{}]struct{}{}
-	for _, elem := range elems {
-		set[elem] = struct{}{}
-	}
-	return &Set{
-		elems: set,
+	set := &Set{
+		elems:    map[interface{}]int{},
+		elemList: []interface{}{},
 	}
+	set.Add(elems...)
+	return set
 }
 
 func (set *Set) Add(elems ...interface{}) {
 	for _, elem := range elems {
-		set.elems[elem] = struct{}{}
+		if _, exists := set.elems[elem]; exists {
+			continue
+		}
+		set.elems[elem] = len(set.elemList)
+		set.elemList = append(set.elemList, elem)
 	}
 }
 
 func (set *Set) Remove(elems ...interface{}) {
 	for _, elem := range elems {
+		index, exists := set.elems[elem]
+		if !exists {
+			continue
+		}
+		// move the last element into the vacated position
+		lastIndex := len(set.elemList) - 1
+		lastElem := set.elemList[lastIndex]
+		set.elemList[index] = lastElem
+		set.elems[lastElem] = index
+		set.elemList[lastIndex] = nil // allow the removed element to be garbage collected
+		set.elemList = set.elemList[:lastIndex]
 		delete(set.elems, elem)
 	}
 }
@@ -34,23 +55,23 @@ func (set *Set) Exists(elem interface{}) bool {
 }
 
 func (set *Set) Len() int {
-	return len(set.elems)
+	return len(set.elemList)
 }
 
 func (set *Set) Clear() {
-	set.elems = map[interface{}]struct{}{}
+	set.elems = map[interface{}]int{}
+	set.elemList = []interface{}{}
 }
 
 func (set *Set) Flatten() []interface{} {
-	elems := []interface{}{}
-	for elem := range set.elems {
-		elems = append(elems, elem)
-	}
+	elems := make([]interface{}, len(set.elemList))
+	copy(elems, set.elemList)
 	return elems
 }
 
+// The set must not be modified by the visitor
 func (set *Set) Traverse(visitor func(elem interface{})) {
-	for elem := range set.elems {
+	for _, elem := range set.elemList {
 		visitor(elem)
 	}
 }
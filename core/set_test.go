@@ -62,3 +62,26 @@ func TestTraverse(t *testing.T) {
 		t.Errorf("Incorrect count: count2=%v, count4=%v, count6=%v, counto=%v", count2, count4, count6, counto)
 	}
 }
+
+func TestDeterministicOrder(t *testing.T) {
+	set := NewSet(5, 3, 9, 1)
+	set.Remove(3)
+	set.Add(7)
+
+	// 1 takes the place of the removed element
+	expected := []interface{}{5, 1, 9, 7}
+	for run := 0; run < 10; run++ {
+		elems := []interface{}{}
+		set.Traverse(func(elem interface{}) {
+			elems = append(elems, elem)
+		})
+		if len(elems) != len(expected) {
+			t.Fatalf("Got %v, expected %v", elems, expected)
+		}
+		for i := range elems {
+			if elems[i] != expected[i] {
+				t.Fatalf("Got %v, expected %v", elems, expected)
+			}
+		}
+	}
+}
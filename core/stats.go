@@ -34,11 +34,27 @@ type Stats struct {
 	// Mean number of bytes transferred per message
 	TrafficPerMsg MeanStat
 
+	// Mean number of bytes of the copies of a message received by nodes that already received the message
+	// Part of the traffic wasted on redundant deliveries
+	DuplicateTrafficPerMsg MeanStat
+
 	// Mean delay per message
 	DelayMsPerMsg MeanStat
 
 	// Mean percentage of nodes that received the message
 	DeliveredPart MeanStat
+
+	// Mean number of chunks transferred per message with cut-through forwarding or coding
+	ChunkCountPerMsg MeanStat
+
+	// Mean delay per chunk of a message received with cut-through forwarding or coding
+	// Messages are delivered once enough of their chunks arrive
+	ChunkDelayMsPerMsg MeanStat
+
+	// topic -> stats of the messages published on the topic
+	// Control messages count only towards the overall stats
+	// nil in the stats of a topic
+	Topics map[string]Stats
 }
 
 // mean of nth value is (sum of n-1 nums + nth num) / n
@@ -50,6 +66,10 @@ func (stat *MeanStat) AddValue(value float64) {
 }
 
 func (stat *MeanStat) AddMeanStat(other *MeanStat) {
+	// avoids dividing by zero when both are empty
+	if other.Count == 0 {
+		return
+	}
 	stat.Count += other.Count
 	stat.Value += (other.Value - stat.Value) * float64(other.Count) / float64(stat.Count)
 }
@@ -22,8 +22,12 @@ type TickEvent struct {
 type Ticker struct {
 	sched    *Scheduler
 	interval time.Duration
+	priority Priority
 	node     TickHandler
 	logger   *zap.Logger
+	// the pending tick
+	task    *Task
+	stopped bool
 }
 
 type TickHandler interface {
@@ -31,18 +35,36 @@ type TickHandler interface {
 	ID() int64
 }
 
-func StartTicker(sched *Scheduler, interval time.Duration, node TickHandler, logger *zap.Logger) error {
+// The ticker keeps firing until it is stopped
+// priority orders the ticks among other events triggered at the same instant
+func StartTicker(
+	sched *Scheduler,
+	interval time.Duration,
+	priority Priority,
+	node TickHandler,
+	logger *zap.Logger,
+) (*Ticker, error) {
 	if interval <= 0 {
-		return NegTickErr
+		return nil, NegTickErr
 	}
 	ticker := &Ticker{
 		sched:    sched,
 		interval: interval,
+		priority: priority,
 		node:     node,
 		logger:   logger,
+		task:     nil,
+		stopped:  false,
 	}
 	ticker.scheduleTick()
-	return nil
+	return ticker, nil
+}
+
+// Cancels the pending tick, for instance when the node leaves the network
+// Stopping from within the tick handler prevents the next tick from being scheduled
+func (ticker *Ticker) Stop() {
+	ticker.stopped = true
+	ticker.task.Cancel()
 }
 
 // helper for the trigger event
@@ -57,7 +79,10 @@ func (ticker *Ticker) Tick() {
 }
 
 func (ticker *Ticker) scheduleTick() {
-	ticker.sched.Schedule(ticker.interval, &TickEvent{
+	if ticker.stopped {
+		return
+	}
+	ticker.task = ticker.sched.ScheduleWithPriority(ticker.interval, ticker.priority, &TickEvent{
 		ticker: ticker,
 	})
 }
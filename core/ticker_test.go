@@ -22,13 +22,13 @@ func TestNegTick(t *testing.T) {
 	sched, _ := NewScheduler(5 * time.Second)
 
 	// negative tick interval
-	err = StartTicker(sched, -1*time.Second, &TickerNode{}, nullLogger)
+	_, err = StartTicker(sched, -1*time.Second, DefaultPriority, &TickerNode{}, nullLogger)
 	if !errors.Is(err, NegTickErr) {
 		t.Error("Cannot support negative interval ticks!")
 	}
 
 	// zero tick interval
-	err = StartTicker(sched, 0, &TickerNode{}, nullLogger)
+	_, err = StartTicker(sched, 0, DefaultPriority, &TickerNode{}, nullLogger)
 	if !errors.Is(err, NegTickErr) {
 		t.Error("Cannot support zero interval ticks!")
 	}
@@ -43,7 +43,7 @@ func TestTicks(t *testing.T) {
 
 	// start ticking at 1 second intervals
 	// first tick occurs after the first second
-	err := StartTicker(sched, time.Second, &TickerNode{}, nullLogger)
+	_, err := StartTicker(sched, time.Second, DefaultPriority, &TickerNode{}, nullLogger)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -53,3 +53,34 @@ func TestTicks(t *testing.T) {
 		t.Error("Heartbeat triggered an incorrect number of times!")
 	}
 }
+
+type StoppingNode struct {
+	ticker *Ticker
+	ticks  int
+}
+
+func (node *StoppingNode) HandleTick() {
+	node.ticks++
+	if node.ticks == 2 {
+		node.ticker.Stop()
+	}
+}
+
+func (node *StoppingNode) ID() int64 { return 0 }
+
+func TestStopTicker(t *testing.T) {
+	nullLogger := zap.L()
+
+	sched, _ := NewScheduler(time.Minute)
+	node := &StoppingNode{}
+	ticker, err := StartTicker(sched, time.Second, DefaultPriority, node, nullLogger)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	node.ticker = ticker
+
+	sched.Run()
+	if node.ticks != 2 {
+		t.Errorf("Ticked %v times after stopping, expected 2", node.ticks)
+	}
+}
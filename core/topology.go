@@ -3,19 +3,51 @@ package core
 import (
 	"errors"
 	"math"
+	"sort"
 
 	exprand "golang.org/x/exp/rand"
 	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/graphs/gen"
 	"gonum.org/v1/gonum/graph/simple"
 	"gonum.org/v1/gonum/stat/distuv"
 )
 
 const (
+	// topology kinds
+	ChungLu        = "chung_lu"
+	ErdosRenyi     = "erdos_renyi"
+	RandomRegular  = "random_regular"
+	BarabasiAlbert = "barabasi_albert"
+	WattsStrogatz  = "watts_strogatz"
+)
+
+const (
+	// NOTE: The average degree is chosen arbitrarily as 16
 	AvgDeg = 16
+
+	// Random regular graphs are generated by pairing node stubs at random
+	//   the pairing is restarted if it gets stuck (which is rare)
+	maxRegularAttempts = 100
 )
 
 var (
-	TooFewNodesErr = errors.New("Must create atleast two nodes to create a network topology!")
+	// Default config params
+	TopologyKind = ChungLu
+	AvgDegree    = AvgDeg
+	RewireProb   = 0.1
+)
+
+var (
+	TooFewNodesErr      = errors.New("Must create atleast two nodes to create a network topology!")
+	UnknownTopologyErr  = errors.New("Could not recognize the requested topology kind!")
+	UnspecTopologyErr   = errors.New("Did not configure the topology kind!")
+	UnspecAvgDegreeErr  = errors.New("Did not configure the average degree of the topology!")
+	UnspecRewireProbErr = errors.New("Did not configure the rewiring probability of the small world topology!")
+	InvAvgDegreeErr     = errors.New("Average degree must be positive and less than the number of nodes!")
+	OddDegreeErr        = errors.New("Average degree must be even for the requested topology!")
+	OddStubsErr         = errors.New("Product of the number of nodes and the degree must be even for a regular topology!")
+	InvRewireProbErr    = errors.New("Rewiring probability must lie between 0 and 1!")
+	RegularGenErr       = errors.New("Could not generate a random regular topology!")
 )
 
 // In a real-life network,
@@ -23,15 +55,59 @@ var (
 // - peer nodes use various discovery mechanisms such as multicast-DNS, distributed hash tables and so .. on
 //     to discover other peers
 // To simplify our simulation, we
-// - assume that the graph is static thorughout the simulation
-// - peers are randomly connected and are connected to a specified number of peers on average
+// - assume that the graph is static thorughout the simulation unless churn is configured (see sim/churn.go)
+// - peers are randomly connected by one of the random graph models below
+//
+// Supported models
+// - chung_lu: every node has the same expected degree
+// - erdos_renyi: every pair of nodes is connected with the same probability (G(n, p))
+// - random_regular: every node has exactly the same degree
+// - barabasi_albert: scale free graph built by preferential attachment where every new node brings avg_degree/2 edges
+// - watts_strogatz: small world graph built by rewiring the edges of a ring lattice
+// - file: graph loaded from a file (see topology_file.go)
+
+type TopologyConfig struct {
+	// Random graph model used to connect the peers
+	Kind *string `toml:"kind,omitempty"`
+
+	// Expected degree of a node
+	// Exact degree for the regular topology and the lattice degree for the small world topology
+	AvgDegree *int `toml:"avg_degree,omitempty"`
+
+	// Probability of rewiring an edge of the lattice in the small world topology
+	RewireProb *float64 `toml:"rewire_prob,omitempty"`
+
+	// Path to the topology file loaded by the file topology
+	File *string `toml:"file,omitempty"`
+
+	// Format of the topology file, inferred from the file extension if not configured
+	Format *string `toml:"format,omitempty"`
+}
+
+func GetDefaultTopologyConfig() *TopologyConfig {
+	return &TopologyConfig{
+		Kind:       &TopologyKind,
+		AvgDegree:  &AvgDegree,
+		RewireProb: &RewireProb,
+	}
+}
 
 // numNodes is the order of the graph/number of vertices
-// generates an undirected graph with 10 peers each
-func NewGraph(numNodes int, rng exprand.Source) (graph.Undirected, error) {
+// generates an undirected graph using the configured random graph model
+func NewGraph(numNodes int, cfg *TopologyConfig, rng exprand.Source) (graph.Undirected, error) {
 	if numNodes < 2 {
 		return nil, TooFewNodesErr
 	}
+	if cfg.Kind == nil {
+		return nil, UnspecTopologyErr
+	}
+	if cfg.AvgDegree == nil {
+		return nil, UnspecAvgDegreeErr
+	}
+	deg := *cfg.AvgDegree
+	if deg <= 0 {
+		return nil, InvAvgDegreeErr
+	}
 
 	// create an undirected graph with `order` nodes
 	grph := simple.NewUndirectedGraph()
@@ -40,17 +116,34 @@ func NewGraph(numNodes int, rng exprand.Source) (graph.Undirected, error) {
 		grph.AddNode(node)
 	}
 
-	// TODO: graph generation algorithm based on the configuration
-	// NOTE: The average degree is chosen arbitrarily as 16
-	// NOTE: Although graphs with order atmost 16 cannot have nodes of degree 16, the algorithm already handles this
-	addEdges(grph, AvgDeg, rng)
+	var err error
+	switch *cfg.Kind {
+	case ChungLu:
+		// NOTE: Although graphs with order atmost deg cannot have nodes of degree deg, the algorithm already handles this
+		addEdges(grph, deg, rng)
+	case ErdosRenyi:
+		err = addRandomEdges(grph, deg, rng)
+	case RandomRegular:
+		err = addRegularEdges(grph, deg, rng)
+	case BarabasiAlbert:
+		err = addPreferentialEdges(grph, deg, rng)
+	case WattsStrogatz:
+		if cfg.RewireProb == nil {
+			return nil, UnspecRewireProbErr
+		}
+		err = addSmallWorldEdges(grph, deg, *cfg.RewireProb, rng)
+	default:
+		return nil, UnknownTopologyErr
+	}
+	if err != nil {
+		return nil, err
+	}
 	return grph, nil
 }
 
 // Algorithm described in the paper "Efficient Generation of Networks with Given Expected Degrees"
 //   accessible at http://aric.hagberg.org/papers/miller-2011-efficient.pdf
 // Assume that every node has an expected degree deg
-// TODO: See if there are alternatives that mimic the actual network topologies better
 func addEdges(grph *simple.UndirectedGraph, deg int, rng exprand.Source) {
 	// uniform distribution
 	// tolerance is chosen arbitrarily so that log of that number if not too high in absolute value
@@ -90,10 +183,209 @@ func addEdges(grph *simple.UndirectedGraph, deg int, rng exprand.Source) {
 	}
 }
 
+// Every edge exists independently with probability p such that the expected degree is deg
+// Algorithm skips over the absent edges as described in "Efficient generation of large random networks"
+//   by Batagelj and Brandes (same as gonum's gen.Gnp which however creates the nodes by itself)
+func addRandomEdges(grph *simple.UndirectedGraph, deg int, rng exprand.Source) error {
+	nodes := GetNodeSlice(grph.Nodes())
+	numNodes := len(nodes)
+	if deg >= numNodes {
+		return InvAvgDegreeErr
+	}
+
+	dist := &distuv.Uniform{
+		Min: 0.0,
+		Max: 1.0,
+		Src: rng,
+	}
+	p := float64(deg) / float64(numNodes-1)
+	if p >= 1.0 {
+		// complete graph
+		for u := 0; u < numNodes; u++ {
+			for v := u + 1; v < numNodes; v++ {
+				grph.SetEdge(grph.NewEdge(nodes[u], nodes[v]))
+			}
+		}
+		return nil
+	}
+
+	// walk over the pairs (w, v) with w < v in lexicographic order of (v, w)
+	logQ := math.Log(1.0 - p)
+	for v, w := 1, -1; v < numNodes; {
+		w += 1 + int(math.Log(1.0-dist.Rand())/logQ)
+		for w >= v && v < numNodes {
+			w -= v
+			v++
+		}
+		if v < numNodes {
+			grph.SetEdge(grph.NewEdge(nodes[w], nodes[v]))
+		}
+	}
+	return nil
+}
+
+// Every node has exactly deg neighbors
+// Stubs (deg per node) are paired at random and the pairs forming self loops or parallel edges are paired again
+//   as described in "Generating random regular graphs quickly" by Steger and Wormald
+func addRegularEdges(grph *simple.UndirectedGraph, deg int, rng exprand.Source) error {
+	nodes := GetNodeSlice(grph.Nodes())
+	numNodes := len(nodes)
+	if deg >= numNodes {
+		return InvAvgDegreeErr
+	}
+	if numNodes*deg%2 != 0 {
+		return OddStubsErr
+	}
+
+	shuffler := exprand.New(rng)
+	for attempt := 0; attempt < maxRegularAttempts; attempt++ {
+		if edges, ok := pairStubs(numNodes, deg, shuffler); ok {
+			for _, edge := range edges {
+				grph.SetEdge(grph.NewEdge(nodes[edge[0]], nodes[edge[1]]))
+			}
+			return nil
+		}
+	}
+	return RegularGenErr
+}
+
+// Returns the edges as pairs of node indices
+// Fails if the remaining stubs can no longer be paired without self loops or parallel edges
+func pairStubs(numNodes int, deg int, shuffler *exprand.Rand) ([][2]int, bool) {
+	edges := [][2]int{}
+	exists := map[[2]int]bool{}
+	stubs := make([]int, 0, numNodes*deg)
+	for u := 0; u < numNodes; u++ {
+		for i := 0; i < deg; i++ {
+			stubs = append(stubs, u)
+		}
+	}
+
+	for len(stubs) > 0 {
+		shuffler.Shuffle(len(stubs), func(i, j int) {
+			stubs[i], stubs[j] = stubs[j], stubs[i]
+		})
+		// unpaired stubs of every node
+		// indexed by node to keep the order of the remaining stubs deterministic
+		remaining := make([]int, numNodes)
+		for i := 0; i+1 < len(stubs); i += 2 {
+			u, v := stubs[i], stubs[i+1]
+			if u > v {
+				u, v = v, u
+			}
+			if u != v && !exists[[2]int{u, v}] {
+				exists[[2]int{u, v}] = true
+				edges = append(edges, [2]int{u, v})
+			} else {
+				remaining[u]++
+				remaining[v]++
+			}
+		}
+
+		stubs = stubs[:0]
+		candidates := []int{}
+		for u, count := range remaining {
+			if count > 0 {
+				candidates = append(candidates, u)
+			}
+			for i := 0; i < count; i++ {
+				stubs = append(stubs, u)
+			}
+		}
+
+		// check that atleast one valid pair remains among the unpaired stubs
+		if len(stubs) > 0 && !hasValidPair(candidates, exists) {
+			return nil, false
+		}
+	}
+	return edges, true
+}
+
+func hasValidPair(candidates []int, exists map[[2]int]bool) bool {
+	for i, u := range candidates {
+		for _, v := range candidates[i+1:] {
+			if !exists[[2]int{u, v}] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Scale free graph with every new node attaching to deg/2 existing nodes with a probability proportional to their degrees
+// Uses gonum's implementation of the Barabási–Albert model
+func addPreferentialEdges(grph *simple.UndirectedGraph, deg int, rng exprand.Source) error {
+	numNodes := grph.Nodes().Len()
+	newEdgesPerNode := deg / 2
+	if newEdgesPerNode == 0 {
+		// atleast one edge is needed to connect the new node
+		newEdgesPerNode = 1
+	}
+	if newEdgesPerNode >= numNodes {
+		return InvAvgDegreeErr
+	}
+	return gen.PreferentialAttachment(grph, numNodes, newEdgesPerNode, rng)
+}
+
+// Small world graph as described in "Collective dynamics of 'small-world' networks" by Watts and Strogatz
+// Every node is connected to deg/2 nodes on either side in a ring lattice
+//   and every lattice edge is then rewired to a random node with probability rewireProb
+func addSmallWorldEdges(grph *simple.UndirectedGraph, deg int, rewireProb float64, rng exprand.Source) error {
+	nodes := GetNodeSlice(grph.Nodes())
+	numNodes := len(nodes)
+	if deg >= numNodes {
+		return InvAvgDegreeErr
+	}
+	if deg%2 != 0 {
+		return OddDegreeErr
+	}
+	if rewireProb < 0 || rewireProb > 1 {
+		return InvRewireProbErr
+	}
+
+	// ring lattice
+	for u := 0; u < numNodes; u++ {
+		for offset := 1; offset <= deg/2; offset++ {
+			grph.SetEdge(grph.NewEdge(nodes[u], nodes[(u+offset)%numNodes]))
+		}
+	}
+
+	dist := &distuv.Uniform{
+		Min: 0.0,
+		Max: 1.0,
+		Src: rng,
+	}
+	picker := exprand.New(rng)
+	// rewire the edges to the nearest neighbors first and then the farther ones
+	for offset := 1; offset <= deg/2; offset++ {
+		for u := 0; u < numNodes; u++ {
+			if dist.Rand() >= rewireProb {
+				continue
+			}
+			if grph.From(nodes[u].ID()).Len() >= numNodes-1 {
+				// already connected to every other node
+				continue
+			}
+			w := picker.Intn(numNodes)
+			for w == u || grph.HasEdgeBetween(nodes[u].ID(), nodes[w].ID()) {
+				w = picker.Intn(numNodes)
+			}
+			grph.RemoveEdge(nodes[u].ID(), nodes[(u+offset)%numNodes].ID())
+			grph.SetEdge(grph.NewEdge(nodes[u], nodes[w]))
+		}
+	}
+	return nil
+}
+
+// Nodes are sorted by their IDs since gonum iterates over the nodes in a random order
+//   which would otherwise make the simulation irreproducible
 func GetNodeSlice(nodeIt graph.Nodes) []graph.Node {
 	nodes := []graph.Node{}
 	for nodeIt.Next() {
 		nodes = append(nodes, nodeIt.Node())
 	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID() < nodes[j].ID()
+	})
 	return nodes
 }
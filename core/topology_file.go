@@ -0,0 +1,358 @@
+package core
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/encoding"
+	"gonum.org/v1/gonum/graph/encoding/dot"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+// Peer graphs crawled from real networks can be loaded from a file instead of generating a random graph
+//
+// Supported formats
+// - edgelist: one edge per line as `u v [key=value ...]`
+//     a line with a single node declares an isolated node and lines starting with # are comments
+// - graphml: edge attributes are declared using <key> elements
+// - dot: Graphviz DOT where edge attributes are specified as usual, ex: `u -- v [latency=20]`
+// The format is inferred from the file extension unless configured
+//
+// Nodes are identified by arbitrary strings in the file and are numbered in the order of their first appearance
+// Optional edge attributes (other attributes are ignored)
+// - latency: one way propagation delay of the link in milliseconds
+// - bandwidth: capacity of the link in Mbit/s
+// - loss: probability of losing a message sent over the link
+// Self loops and duplicate edges are rejected since the simulated topology is a simple graph
+
+const (
+	// topology kind
+	FromFile = "file"
+
+	// topology file formats
+	EdgeListFormat = "edgelist"
+	GraphMLFormat  = "graphml"
+	DOTFormat      = "dot"
+
+	// edge attributes
+	latencyAttr   = "latency"
+	bandwidthAttr = "bandwidth"
+	lossAttr      = "loss"
+)
+
+var (
+	UnspecTopologyFileErr = errors.New("Did not configure the topology file!")
+	UnknownFormatErr      = errors.New("Could not recognize the format of the topology file!")
+	MalformedTopologyErr  = errors.New("Topology file is malformed!")
+	SelfLoopErr           = errors.New("Topology file contains a self loop!")
+	DuplicateEdgeErr      = errors.New("Topology file contains a duplicate edge!")
+	InvEdgeAttrErr        = errors.New("Topology file contains an invalid edge attribute!")
+)
+
+// Edge carrying the optional attributes of the link
+type LinkEdge struct {
+	F, T graph.Node
+
+	// One way propagation delay in milliseconds, zero if unspecified
+	Latency float64
+
+	// Capacity in Mbit/s, zero if unspecified
+	Bandwidth float64
+
+	// Loss probability, negative if unspecified
+	Loss float64
+}
+
+// Maps node names in the file to the nodes of the graph and validates the edges
+type topologyBuilder struct {
+	grph    *simple.UndirectedGraph
+	nodeIDs map[string]graph.Node
+}
+
+// Subset of the GraphML schema describing the nodes, edges and edge attributes
+type graphML struct {
+	Keys   []graphMLKey   `xml:"key"`
+	Graphs []graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+}
+
+type graphMLGraph struct {
+	Nodes []graphMLNode `xml:"node"`
+	Edges []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID string `xml:"id,attr"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Collects the nodes and edges decoded by gonum's DOT decoder
+type dotBuilder struct {
+	*simple.UndirectedGraph
+}
+
+func LoadGraph(cfg *TopologyConfig) (graph.Undirected, error) {
+	if cfg.File == nil {
+		return nil, UnspecTopologyFileErr
+	}
+	format, err := getFormat(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(*cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var grph *simple.UndirectedGraph
+	switch format {
+	case EdgeListFormat:
+		grph, err = readEdgeList(file)
+	case GraphMLFormat:
+		grph, err = readGraphML(file)
+	case DOTFormat:
+		grph, err = readDOT(file)
+	default:
+		return nil, UnknownFormatErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if grph.Nodes().Len() < 2 {
+		return nil, TooFewNodesErr
+	}
+	return grph, nil
+}
+
+func getFormat(cfg *TopologyConfig) (string, error) {
+	if cfg.Format != nil {
+		return *cfg.Format, nil
+	}
+	switch strings.ToLower(filepath.Ext(*cfg.File)) {
+	case ".txt", ".edges", ".edgelist":
+		return EdgeListFormat, nil
+	case ".graphml", ".xml":
+		return GraphMLFormat, nil
+	case ".dot", ".gv":
+		return DOTFormat, nil
+	default:
+		return "", UnknownFormatErr
+	}
+}
+
+func readEdgeList(reader io.Reader) (*simple.UndirectedGraph, error) {
+	builder := newTopologyBuilder()
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 1 {
+			builder.addNode(fields[0])
+			continue
+		}
+
+		attrs := []encoding.Attribute{}
+		for _, field := range fields[2:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("%w: line %v: expected key=value, got %v", MalformedTopologyErr, lineNum, field)
+			}
+			attrs = append(attrs, encoding.Attribute{
+				Key:   kv[0],
+				Value: kv[1],
+			})
+		}
+		if err := builder.addEdge(fields[0], fields[1], attrs); err != nil {
+			return nil, fmt.Errorf("line %v: %w", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return builder.grph, nil
+}
+
+func readGraphML(reader io.Reader) (*simple.UndirectedGraph, error) {
+	var doc graphML
+	if err := xml.NewDecoder(reader).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", MalformedTopologyErr, err)
+	}
+	if len(doc.Graphs) != 1 {
+		return nil, fmt.Errorf("%w: expected a single graph, got %v", MalformedTopologyErr, len(doc.Graphs))
+	}
+
+	// key ID -> attribute name
+	attrNames := map[string]string{}
+	for _, key := range doc.Keys {
+		if key.For == "edge" || key.For == "all" {
+			attrNames[key.ID] = key.Name
+		}
+	}
+
+	builder := newTopologyBuilder()
+	for _, node := range doc.Graphs[0].Nodes {
+		builder.addNode(node.ID)
+	}
+	for _, edge := range doc.Graphs[0].Edges {
+		attrs := []encoding.Attribute{}
+		for _, data := range edge.Data {
+			attrs = append(attrs, encoding.Attribute{
+				Key:   attrNames[data.Key],
+				Value: strings.TrimSpace(data.Value),
+			})
+		}
+		if err := builder.addEdge(edge.Source, edge.Target, attrs); err != nil {
+			return nil, err
+		}
+	}
+	return builder.grph, nil
+}
+
+func readDOT(reader io.Reader) (*simple.UndirectedGraph, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	builder := &dotBuilder{
+		UndirectedGraph: simple.NewUndirectedGraph(),
+	}
+	// nodes are numbered in the order of their first appearance by the decoder
+	if err := dot.Unmarshal(data, builder); err != nil {
+		return nil, err
+	}
+	return builder.UndirectedGraph, nil
+}
+
+func newTopologyBuilder() *topologyBuilder {
+	return &topologyBuilder{
+		grph:    simple.NewUndirectedGraph(),
+		nodeIDs: map[string]graph.Node{},
+	}
+}
+
+func (builder *topologyBuilder) addNode(name string) graph.Node {
+	if node, exists := builder.nodeIDs[name]; exists {
+		return node
+	}
+	node := builder.grph.NewNode()
+	builder.grph.AddNode(node)
+	builder.nodeIDs[name] = node
+	return node
+}
+
+func (builder *topologyBuilder) addEdge(from string, to string, attrs []encoding.Attribute) error {
+	if from == to {
+		return fmt.Errorf("%w: %v", SelfLoopErr, from)
+	}
+	fromNode := builder.addNode(from)
+	toNode := builder.addNode(to)
+	if builder.grph.HasEdgeBetween(fromNode.ID(), toNode.ID()) {
+		return fmt.Errorf("%w: %v -- %v", DuplicateEdgeErr, from, to)
+	}
+
+	edge := &LinkEdge{
+		F:    fromNode,
+		T:    toNode,
+		Loss: -1,
+	}
+	for _, attr := range attrs {
+		if err := edge.SetAttribute(attr); err != nil {
+			return fmt.Errorf("%v -- %v: %w", from, to, err)
+		}
+	}
+	builder.grph.SetEdge(edge)
+	return nil
+}
+
+// Overrides the edges created by the embedded graph to collect the edge attributes
+func (builder *dotBuilder) NewEdge(from, to graph.Node) graph.Edge {
+	return &LinkEdge{
+		F:    from,
+		T:    to,
+		Loss: -1,
+	}
+}
+
+// Errors are reported by panicking, which the decoder recovers from
+func (builder *dotBuilder) SetEdge(edge graph.Edge) {
+	fromID, toID := edge.From().ID(), edge.To().ID()
+	if fromID == toID {
+		panic(fmt.Errorf("%w: %v", SelfLoopErr, fromID))
+	}
+	if builder.HasEdgeBetween(fromID, toID) {
+		panic(fmt.Errorf("%w: %v -- %v", DuplicateEdgeErr, fromID, toID))
+	}
+	builder.UndirectedGraph.SetEdge(edge)
+}
+
+func (edge *LinkEdge) From() graph.Node {
+	return edge.F
+}
+
+func (edge *LinkEdge) To() graph.Node {
+	return edge.T
+}
+
+func (edge *LinkEdge) ReversedEdge() graph.Edge {
+	return &LinkEdge{
+		F:         edge.T,
+		T:         edge.F,
+		Latency:   edge.Latency,
+		Bandwidth: edge.Bandwidth,
+		Loss:      edge.Loss,
+	}
+}
+
+// Implements encoding.AttributeSetter
+func (edge *LinkEdge) SetAttribute(attr encoding.Attribute) error {
+	var field *float64
+	switch attr.Key {
+	case latencyAttr:
+		field = &edge.Latency
+	case bandwidthAttr:
+		field = &edge.Bandwidth
+	case lossAttr:
+		field = &edge.Loss
+	default:
+		return nil
+	}
+
+	value, err := strconv.ParseFloat(attr.Value, 64)
+	if err != nil || value < 0 || (attr.Key == lossAttr && value > 1) {
+		return fmt.Errorf("%w: %v=%v", InvEdgeAttrErr, attr.Key, attr.Value)
+	}
+	*field = value
+	return nil
+}
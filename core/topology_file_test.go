@@ -0,0 +1,101 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTopologyFile(t *testing.T, name string, contents string) *TopologyConfig {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	kind := FromFile
+	return &TopologyConfig{
+		Kind: &kind,
+		File: &path,
+	}
+}
+
+// checks the path a -- b -- c with the attributes on the first link
+func checkPath(t *testing.T, cfg *TopologyConfig) {
+	grph, err := LoadGraph(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if grph.Nodes().Len() != 3 {
+		t.Errorf("Loaded %v nodes, expected 3", grph.Nodes().Len())
+	}
+	// nodes are numbered in the order of their appearance
+	if !grph.HasEdgeBetween(0, 1) || !grph.HasEdgeBetween(1, 2) || grph.HasEdgeBetween(0, 2) {
+		t.Error("Loaded incorrect edges")
+	}
+	edge := grph.EdgeBetween(1, 0).(*LinkEdge)
+	if edge.Latency != 25 || edge.Bandwidth != 100 {
+		t.Errorf("Loaded incorrect attributes: latency=%v, bandwidth=%v", edge.Latency, edge.Bandwidth)
+	}
+	edge = grph.EdgeBetween(1, 2).(*LinkEdge)
+	if edge.Latency != 0 || edge.Bandwidth != 0 {
+		t.Errorf("Unspecified attributes must be zero: latency=%v, bandwidth=%v", edge.Latency, edge.Bandwidth)
+	}
+}
+
+func TestEdgeList(t *testing.T) {
+	checkPath(t, writeTopologyFile(t, "path.txt", `
+# crawled peers
+enode-a enode-b latency=25 bandwidth=100 weight=3
+enode-b enode-c
+`))
+}
+
+func TestGraphML(t *testing.T) {
+	checkPath(t, writeTopologyFile(t, "path.graphml", `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="d0" for="edge" attr.name="latency" attr.type="double"/>
+  <key id="d1" for="edge" attr.name="bandwidth" attr.type="double"/>
+  <graph edgedefault="undirected">
+    <node id="a"/>
+    <node id="b"/>
+    <node id="c"/>
+    <edge source="a" target="b">
+      <data key="d0">25</data>
+      <data key="d1">100</data>
+    </edge>
+    <edge source="b" target="c"/>
+  </graph>
+</graphml>
+`))
+}
+
+func TestDOT(t *testing.T) {
+	checkPath(t, writeTopologyFile(t, "path.gv", `graph peers {
+	a -- b [latency=25, bandwidth=100];
+	b -- c;
+}
+`))
+}
+
+func TestInvTopologyFile(t *testing.T) {
+	for _, testCase := range []struct {
+		name     string
+		contents string
+		err      error
+	}{
+		{"loop.txt", "a b\nb b\n", SelfLoopErr},
+		{"dup.txt", "a b\nb a\n", DuplicateEdgeErr},
+		{"attr.txt", "a b latency=fast\n", InvEdgeAttrErr},
+		{"malformed.txt", "a b latency\n", MalformedTopologyErr},
+		{"single.txt", "a\n", TooFewNodesErr},
+		{"loop.dot", "graph { a -- b; b -- b }", SelfLoopErr},
+		{"dup.dot", "graph { a -- b; b -- a }", DuplicateEdgeErr},
+		{"loop.graphml", `<graphml><graph><edge source="a" target="a"/></graph></graphml>`, SelfLoopErr},
+		{"peers.json", "{}", UnknownFormatErr},
+	} {
+		_, err := LoadGraph(writeTopologyFile(t, testCase.name, testCase.contents))
+		if !errors.Is(err, testCase.err) {
+			t.Errorf("%v: got %v, expected %v", testCase.name, err, testCase.err)
+		}
+	}
+}
@@ -6,22 +6,24 @@ import (
 	"testing"
 
 	exprand "golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/simple"
 )
 
 func TestTooFewNodes(t *testing.T) {
 	var err error
 
-	_, err = NewGraph(-1, exprand.NewSource(2))
+	_, err = NewGraph(-1, GetDefaultTopologyConfig(), exprand.NewSource(2))
 	if !errors.Is(err, TooFewNodesErr) {
 		t.Error("Cannot create a graph with negative nodes!")
 	}
 
-	_, err = NewGraph(0, exprand.NewSource(4))
+	_, err = NewGraph(0, GetDefaultTopologyConfig(), exprand.NewSource(4))
 	if !errors.Is(err, TooFewNodesErr) {
 		t.Error("Cannot create a graph with zero nodes!")
 	}
 
-	_, err = NewGraph(1, exprand.NewSource(1))
+	_, err = NewGraph(1, GetDefaultTopologyConfig(), exprand.NewSource(1))
 	if !errors.Is(err, TooFewNodesErr) {
 		t.Error("Need atleast two nodes to create a graph network")
 	}
@@ -30,7 +32,7 @@ func TestTooFewNodes(t *testing.T) {
 func TestGraphGen(t *testing.T) {
 	for _, numNodes := range []int{10, 100, 1_000} {
 		rng := exprand.NewSource(314)
-		grph, err := NewGraph(numNodes, rng)
+		grph, err := NewGraph(numNodes, GetDefaultTopologyConfig(), rng)
 		if err != nil {
 			t.Error("Unexpected error!")
 		}
@@ -50,3 +52,128 @@ func TestGraphGen(t *testing.T) {
 		}
 	}
 }
+
+func newTopologyConfig(kind string, deg int) *TopologyConfig {
+	cfg := GetDefaultTopologyConfig()
+	cfg.Kind = &kind
+	cfg.AvgDegree = &deg
+	return cfg
+}
+
+func getDegrees(grph graph.Undirected) []int {
+	degrees := []int{}
+	for _, node := range GetNodeSlice(grph.Nodes()) {
+		degrees = append(degrees, grph.From(node.ID()).Len())
+	}
+	return degrees
+}
+
+func TestUnknownTopology(t *testing.T) {
+	_, err := NewGraph(100, newTopologyConfig("hypercube", 8), exprand.NewSource(3))
+	if !errors.Is(err, UnknownTopologyErr) {
+		t.Error("Cannot generate an unknown topology!")
+	}
+}
+
+func TestInvDegree(t *testing.T) {
+	for _, kind := range []string{ChungLu, ErdosRenyi, RandomRegular, BarabasiAlbert, WattsStrogatz} {
+		_, err := NewGraph(100, newTopologyConfig(kind, 0), exprand.NewSource(5))
+		if !errors.Is(err, InvAvgDegreeErr) {
+			t.Errorf("Cannot generate a %v topology with zero degree!", kind)
+		}
+	}
+
+	_, err := NewGraph(101, newTopologyConfig(RandomRegular, 7), exprand.NewSource(5))
+	if !errors.Is(err, OddStubsErr) {
+		t.Error("Cannot generate an odd regular topology with an odd number of nodes!")
+	}
+
+	_, err = NewGraph(100, newTopologyConfig(WattsStrogatz, 7), exprand.NewSource(5))
+	if !errors.Is(err, OddDegreeErr) {
+		t.Error("Cannot generate a ring lattice with an odd degree!")
+	}
+}
+
+func TestMeanDegree(t *testing.T) {
+	numNodes := 1_000
+	deg := 8
+	for _, kind := range []string{ChungLu, ErdosRenyi, RandomRegular, BarabasiAlbert, WattsStrogatz} {
+		grph, err := NewGraph(numNodes, newTopologyConfig(kind, deg), exprand.NewSource(271))
+		if err != nil {
+			t.Fatalf("Unexpected error generating a %v topology: %v", kind, err)
+		}
+		degrees := getDegrees(grph)
+		totalDegree := 0
+		for _, degree := range degrees {
+			totalDegree += degree
+		}
+		meanDegree := float64(totalDegree) / float64(numNodes)
+		// 5% tolerance
+		if math.Abs(meanDegree-float64(deg)) > 0.05*float64(deg) {
+			t.Errorf("Mean degree of the %v topology: %v", kind, meanDegree)
+		}
+	}
+}
+
+func TestRegular(t *testing.T) {
+	deg := 16
+	grph, err := NewGraph(1_024, newTopologyConfig(RandomRegular, deg), exprand.NewSource(6))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for nodeID, degree := range getDegrees(grph) {
+		if degree != deg {
+			t.Fatalf("Node %v has degree %v, expected %v", nodeID, degree, deg)
+		}
+	}
+}
+
+func TestScaleFree(t *testing.T) {
+	deg := 8
+	grph, err := NewGraph(1_000, newTopologyConfig(BarabasiAlbert, deg), exprand.NewSource(7))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// hubs are expected in a scale free graph unlike the graphs with a homogeneous degree
+	maxDegree := 0
+	for _, degree := range getDegrees(grph) {
+		if degree > maxDegree {
+			maxDegree = degree
+		}
+	}
+	if maxDegree < 5*deg {
+		t.Errorf("Max degree of the scale free topology: %v", maxDegree)
+	}
+}
+
+func TestSmallWorld(t *testing.T) {
+	numNodes := 100
+	deg := 4
+	cfg := newTopologyConfig(WattsStrogatz, deg)
+
+	// without rewiring, the graph is a ring lattice
+	noRewire := 0.0
+	cfg.RewireProb = &noRewire
+	grph, err := NewGraph(numNodes, cfg, exprand.NewSource(8))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for u := 0; u < numNodes; u++ {
+		for offset := 1; offset <= deg/2; offset++ {
+			if !grph.HasEdgeBetween(int64(u), int64((u+offset)%numNodes)) {
+				t.Fatalf("Missing lattice edge between %v and %v", u, (u+offset)%numNodes)
+			}
+		}
+	}
+
+	// rewiring preserves the number of edges
+	fullRewire := 1.0
+	cfg.RewireProb = &fullRewire
+	grph, err = NewGraph(numNodes, cfg, exprand.NewSource(8))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if grph.(*simple.UndirectedGraph).Edges().Len() != numNodes*deg/2 {
+		t.Errorf("Rewiring changed the number of edges: %v", grph.(*simple.UndirectedGraph).Edges().Len())
+	}
+}
@@ -0,0 +1,241 @@
+package devp2p
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/invsub"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"go.uber.org/zap"
+	exprand "golang.org/x/exp/rand"
+)
+
+// Block propagation of the ethereum wire protocol (eth/66), see https://github.com/ethereum/devp2p/blob/master/caps/eth.md#block-propagation
+//
+// A node pushes every new message to the square root of its peers subscribed to the topic
+//   that are not known to have the message, i.e., the peers that sent or announced it
+//   and announces the message to the other peers
+// An announced message is requested from the first announcer unless pushed within arrive_timeout
+//   and from the next announcer every fetch_timeout while it does not arrive, as the block fetcher of geth
+//   (see invsub.Fetcher)
+
+var (
+	InvHistErr     = errors.New("History length must be positive!")
+	InvExponentErr = errors.New("Push exponent must be between 0 and 1!")
+	InvArriveErr   = errors.New("Arrival timeout cannot be negative!")
+	InvFetchErr    = errors.New("Fetch timeout must be positive!")
+)
+
+var (
+	// default config params
+	HeartbeatInterval = 1 * time.Second
+	HeartbeatPriority = int(core.DefaultPriority)
+	HistoryLength     = 5
+	PushExponent      = 0.5
+	ArriveTimeout     = 500 * time.Millisecond
+	FetchTimeout      = 5 * time.Second
+)
+
+type Router struct {
+	// devp2p config params
+	cfg *Config
+
+	// source of randomness picking the peers the messages are pushed to
+	rng exprand.Source
+
+	// initialized while initializing the pubsub node
+	node *pubsub.Node
+
+	// tracks the peers known to have the messages, fetches the announced messages
+	//   and responds to GetBlocks messages
+	fetcher *invsub.Fetcher
+
+	// triggers the heartbeats, stopped on leaving the network
+	ticker *core.Ticker
+}
+
+type Config struct {
+	// Interval between consecutive heartbeats
+	// The message cache is shifted on every heartbeat
+	HeartbeatInterval *time.Duration `toml:"heartbeat_interval,omitempty"`
+
+	// Negative values forget the old announcements before the deliveries at the same instant and positive values after
+	HeartbeatPriority *int `toml:"heartbeat_priority,omitempty"`
+
+	// Number of heartbeat events for which the message cache remembers seen messages
+	HistoryLength *int `toml:"history_length,omitempty"`
+
+	// Messages are pushed to n^push_exponent of the n peers without the message
+	// 0.5 pushes to the square root of the peers, 1 to all of them as floodsub and 0 to a single peer
+	PushExponent *float64 `toml:"push_exponent,omitempty"`
+
+	// Duration after the first announcement before requesting the message, in case it is pushed meanwhile
+	ArriveTimeout *time.Duration `toml:"arrive_timeout,omitempty"`
+
+	// Duration after a request before the message is requested from the next announcer
+	FetchTimeout *time.Duration `toml:"fetch_timeout,omitempty"`
+}
+
+func GetDefaultConfig() *Config {
+	return &Config{
+		HeartbeatInterval: &HeartbeatInterval,
+		HeartbeatPriority: &HeartbeatPriority,
+		HistoryLength:     &HistoryLength,
+		PushExponent:      &PushExponent,
+		ArriveTimeout:     &ArriveTimeout,
+		FetchTimeout:      &FetchTimeout,
+	}
+}
+
+func NewRouter(cfg *Config, rng exprand.Source) *Router {
+	return &Router{
+		cfg:  cfg,
+		rng:  rng,
+		node: nil,
+	}
+}
+
+func (router *Router) Start(node *pubsub.Node, logger *zap.Logger) error {
+	var err error
+
+	if *router.cfg.HistoryLength <= 0 {
+		return InvHistErr
+	}
+	if *router.cfg.PushExponent < 0 || *router.cfg.PushExponent > 1 {
+		return InvExponentErr
+	}
+	if *router.cfg.ArriveTimeout < 0 {
+		return InvArriveErr
+	}
+	if *router.cfg.FetchTimeout <= 0 {
+		return InvFetchErr
+	}
+
+	router.node = node
+	router.fetcher = invsub.NewFetcher(
+		node,
+		*router.cfg.HistoryLength,
+		*router.cfg.HeartbeatInterval,
+		*router.cfg.ArriveTimeout,
+		*router.cfg.FetchTimeout,
+		func(peerID int64, msgID pubsub.MsgID) {
+			getBlocks := &GetBlocks{msgIDs: core.NewSet(msgID)}
+			router.node.SendRPC(peerID, NewControlMsg([]pubsub.Message{}, nil, getBlocks))
+		},
+	)
+
+	// Start timer for heartbeats
+	router.ticker, err = core.StartTicker(
+		node.Sched,
+		*router.cfg.HeartbeatInterval,
+		core.Priority(*router.cfg.HeartbeatPriority),
+		router,
+		logger,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Messages are pushed to n^push_exponent random peers subscribed to the topic and announced to the others
+func (router *Router) PublishMsg(srcID int64, msg pubsub.Message) {
+	router.fetcher.AddMsg(srcID, msg)
+
+	msgID := pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}
+	targetIDs := router.getRandomNeighbors(func(neighborID int64) bool {
+		if !router.node.PeerSubscribed(neighborID, msg.Topic()) || neighborID == msg.From() {
+			return false
+		}
+		return !router.fetcher.IsKnown(msgID, neighborID)
+	})
+	numPush := int(math.Pow(float64(len(targetIDs)), *router.cfg.PushExponent))
+	for i, neighborID := range targetIDs {
+		if i < numPush {
+			router.node.SendRPC(neighborID, NewDataMsg([]pubsub.Message{msg}))
+			continue
+		}
+		newHashes := []*NewHashes{{topic: msg.Topic(), msgIDs: core.NewSet(msgID)}}
+		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, newHashes, nil))
+	}
+}
+
+func (router *Router) HandleRPC(srcID int64, rpcMsg pubsub.RPC) {
+	for _, msg := range rpcMsg.GetMessages() {
+		router.fetcher.AddKnown(pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}, srcID)
+	}
+
+	control := rpcMsg.(*RPCMsg).control
+	if control == nil {
+		return
+	}
+
+	router.handleNewHashes(srcID, control.newHashes)
+
+	if control.getBlocks == nil {
+		return
+	}
+	if msgs := router.fetcher.GetMessages(control.getBlocks.msgIDs); len(msgs) > 0 {
+		router.node.SendRPC(srcID, NewDataMsg(msgs))
+	}
+}
+
+func (router *Router) handleNewHashes(remoteID int64, newHashes []*NewHashes) {
+	for _, topicHashes := range newHashes {
+		// not interested in the messages of topics that are not subscribed to
+		if !router.node.Topics.Exists(topicHashes.topic) {
+			continue
+		}
+		topicHashes.msgIDs.Traverse(func(iMsgID interface{}) {
+			router.fetcher.HandleAnnounce(remoteID, iMsgID.(pubsub.MsgID))
+		})
+	}
+}
+
+// Returns the neighbors passing the filter in random order
+func (router *Router) getRandomNeighbors(filter func(int64) bool) []int64 {
+	neighborIDs := []int64{}
+	router.node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		if filter(neighborID) {
+			neighborIDs = append(neighborIDs, neighborID)
+		}
+	})
+
+	exprand.New(router.rng).Shuffle(len(neighborIDs), func(i, j int) {
+		neighborIDs[i], neighborIDs[j] = neighborIDs[j], neighborIDs[i]
+	})
+	return neighborIDs
+}
+
+// Messages are announced to the new peer from the next message on
+func (router *Router) AddPeer(remoteID int64) {}
+
+// Announcements of the removed peer are skipped while fetching
+func (router *Router) RemovePeer(remoteID int64) {}
+
+func (router *Router) Stop() {
+	router.ticker.Stop()
+	router.fetcher.Stop()
+}
+
+func (router *Router) Join(topic string) {}
+
+func (router *Router) Leave(topic string) {}
+
+func (router *Router) HandleSubscription(remoteID int64, topic string, subscribe bool) {}
+
+func (router *Router) AcceptFrom(srcID int64) bool {
+	return true
+}
+
+func (router *Router) HandleTick() {
+	router.fetcher.Shift()
+}
+
+func (router *Router) ID() int64 {
+	return router.node.ID()
+}
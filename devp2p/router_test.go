@@ -0,0 +1,67 @@
+package devp2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"github.com/marlinprotocol/p2psim/pubsub/pubsubtest"
+	exprand "golang.org/x/exp/rand"
+)
+
+func TestInvConfig(t *testing.T) {
+	zero := 0
+	negative := -1 * time.Millisecond
+	zeroDur := time.Duration(0)
+	large := 1.5
+	newRouter := func(update func(*Config)) pubsub.Router {
+		cfg := GetDefaultConfig()
+		update(cfg)
+		return NewRouter(cfg, exprand.NewSource(55))
+	}
+	pubsubtest.CheckInvRouters(t, []pubsubtest.InvRouter{
+		{Router: newRouter(func(cfg *Config) { cfg.HistoryLength = &zero }), Err: InvHistErr},
+		{Router: newRouter(func(cfg *Config) { cfg.PushExponent = &large }), Err: InvExponentErr},
+		{Router: newRouter(func(cfg *Config) { cfg.ArriveTimeout = &negative }), Err: InvArriveErr},
+		{Router: newRouter(func(cfg *Config) { cfg.FetchTimeout = &zeroDur }), Err: InvFetchErr},
+	})
+}
+
+// star of nodes where the center publishes to its 9 peers
+func TestPushAnnounce(t *testing.T) {
+	cfg := GetDefaultConfig()
+	edges := [][2]int64{}
+	for nodeID := int64(1); nodeID < 10; nodeID++ {
+		edges = append(edges, [2]int64{0, nodeID})
+	}
+	sched, _, nodes, _ := pubsubtest.SpawnNodes(t, 10, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, edges, true)
+
+	msgID := pubsub.MsgID{From: 0, Seqno: 1}
+	countSeen := func() int {
+		count := 0
+		for _, node := range nodes[1:] {
+			if node.SeenMsgs.SeenMsg(msgID) {
+				count++
+			}
+		}
+		return count
+	}
+
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	sched.RunFor(15 * time.Millisecond)
+	if count := countSeen(); count != 3 {
+		t.Fatalf("Pushed the message to %v peers, expected 3", count)
+	}
+
+	// the other peers request the message once the arrival timeout expires
+	sched.RunFor(*cfg.ArriveTimeout)
+	if count := countSeen(); count != 3 {
+		t.Fatalf("Requested the message before the arrival timeout")
+	}
+	sched.RunFor(2 * 10 * time.Millisecond)
+	if count := countSeen(); count != 9 {
+		t.Errorf("Delivered the message to %v peers, expected 9", count)
+	}
+}
@@ -0,0 +1,67 @@
+package devp2p
+
+import (
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+)
+
+// Messages are pushed as NewBlock, i.e., as RPCs carrying only the messages
+type RPCMsg struct {
+	size    int64
+	msgs    []pubsub.Message
+	control *ControlMessage
+}
+
+type ControlMessage struct {
+	newHashes []*NewHashes
+	getBlocks *GetBlocks
+}
+
+// Announces the messages of a topic as NewBlockHashes
+type NewHashes struct {
+	topic string
+	// Set of MsgID
+	msgIDs *core.Set
+}
+
+// Requests the announced messages, as GetBlockHeaders and GetBlockBodies in a single round trip
+type GetBlocks struct {
+	// Set of MsgID
+	msgIDs *core.Set
+}
+
+func NewDataMsg(msgs []pubsub.Message) *RPCMsg {
+	return NewControlMsg(msgs, nil, nil)
+}
+
+func NewControlMsg(msgs []pubsub.Message, newHashes []*NewHashes, getBlocks *GetBlocks) *RPCMsg {
+	// compute size
+	size := int64(0)
+	for _, msg := range msgs {
+		size += msg.GetSize()
+	}
+	for _, topicHashes := range newHashes {
+		size += int64(len(topicHashes.topic)) + int64(topicHashes.msgIDs.Len())*8
+	}
+	if getBlocks != nil {
+		size += int64(getBlocks.msgIDs.Len()) * 8
+	}
+
+	control := &ControlMessage{
+		newHashes: newHashes,
+		getBlocks: getBlocks,
+	}
+	return &RPCMsg{
+		size:    size,
+		msgs:    msgs,
+		control: control,
+	}
+}
+
+func (rpcMsg *RPCMsg) GetSize() int64 {
+	return rpcMsg.size
+}
+
+func (rpcMsg *RPCMsg) GetMessages() []pubsub.Message {
+	return rpcMsg.msgs
+}
@@ -0,0 +1,726 @@
+package episub
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/gossipsub"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"go.uber.org/zap"
+	exprand "golang.org/x/exp/rand"
+)
+
+// Proximity aware epidemic broadcast, see https://github.com/libp2p/specs/blob/master/pubsub/gossipsub/episub.md
+//
+// Every topic has an active and a passive view
+// - the active view is a symmetric overlay of peers subscribed to the topic, maintained with JOIN and LEAVE
+//   messages are pushed along the active view
+// - the passive view holds other peers subscribed to the topic, replacing the active peers that leave
+// The round trip times to the peers in the views are measured with PING and PONG every ping_ticks heartbeats
+//   the active view prefers close peers and swaps its farthest peer for a much closer passive peer (view optimisation)
+//
+// A node chokes the active peers whose copies of the messages arrive late (CHOKE)
+//   a choked peer announces the messages with IHAVE right away instead of sending them (lazy push)
+//   and is unchoked (UNCHOKE) once its announcements arrive before the messages from the other peers
+// Messages announced but not received within iwant_timeout are requested with IWANT
+
+var (
+	InvViewErr     = errors.New("Configured view sizes do not follow the required constraints!")
+	InvHistErr     = errors.New("History length must be positive!")
+	InvTimeoutErr  = errors.New("IWANT timeout must be positive!")
+	InvPingErr     = errors.New("Ping ticks must be positive!")
+	InvOptimiseErr = errors.New("Optimisation threshold cannot be negative!")
+)
+
+var (
+	// default config params
+	HeartbeatInterval = 1 * time.Second
+	HeartbeatPriority = int(core.DefaultPriority)
+	ActiveView        = 5
+	ActiveViewHigh    = 10
+	PassiveView       = 20
+	MinUnchoked       = 2
+	HistoryLength     = 5
+	IWantTimeout      = 100 * time.Millisecond
+	ChokeThreshold    = 50 * time.Millisecond
+	UnchokeThreshold  = time.Duration(0)
+	PingTicks         = 10
+	OptimiseThreshold = 20 * time.Millisecond
+)
+
+type Router struct {
+	// episub config params
+	cfg *Config
+
+	rng exprand.Source
+
+	// initialized while initializing the pubsub node
+	node *pubsub.Node
+
+	// topics joined by the local node
+	// underlying type => string
+	topics *core.Set
+
+	// topic -> set of peers in the active view of the topic
+	// underlying type => int64 (peer ID)
+	active map[string]*core.Set
+
+	// topic -> set of peers in the passive view of the topic
+	// underlying type => int64 (peer ID)
+	passive map[string]*core.Set
+
+	// topic -> set of active peers choked by the local node
+	// underlying type => int64 (peer ID)
+	choked map[string]*core.Set
+
+	// topic -> set of active peers that choked the local node
+	// underlying type => int64 (peer ID)
+	chokedBy map[string]*core.Set
+
+	// peer ID -> last measured round trip time
+	rtt map[int64]time.Duration
+
+	// topic -> peer ID -> mean lateness in ms of the copies (or announcements) of the messages from the active peer
+	//   relative to the first copy received
+	// reset on every heartbeat
+	lateness map[string]map[int64]*core.MeanStat
+
+	// MsgID -> time the first copy of the message was received
+	// forgotten after history_length heartbeats
+	firstSeen map[pubsub.MsgID]time.Time
+
+	// MsgID -> first announcement of a message not received yet
+	// forgotten after history_length heartbeats
+	announced map[pubsub.MsgID]*announcement
+
+	// To respond to IWant messages
+	mcache *gossipsub.MessageCache
+
+	// triggers the heartbeats, stopped on leaving the network
+	ticker *core.Ticker
+
+	// number of heartbeats so far
+	heartbeats int
+}
+
+type announcement struct {
+	peerID int64
+	topic  string
+	time   time.Time
+	// requests the message with IWANT once the timeout expires
+	task *core.Task
+}
+
+type Config struct {
+	// Interval between consecutive heartbeats
+	// The views are maintained and the peers (un)choked on the heartbeats
+	HeartbeatInterval *time.Duration `toml:"heartbeat_interval,omitempty"`
+
+	// Negative values (un)choke the peers before the deliveries at the same instant and positive values after
+	HeartbeatPriority *int `toml:"heartbeat_priority,omitempty"`
+
+	// Desired size of the active view of every topic
+	ActiveView *int `toml:"active_view,omitempty"`
+
+	// Peers joining an active view of this size are refused
+	ActiveViewHigh *int `toml:"active_view_high,omitempty"`
+
+	// Maximum size of the passive view of every topic
+	PassiveView *int `toml:"passive_view,omitempty"`
+
+	// Number of active peers never choked
+	MinUnchoked *int `toml:"min_unchoked,omitempty"`
+
+	// Number of heartbeat events for which the message cache remembers seen messages
+	HistoryLength *int `toml:"history_length,omitempty"`
+
+	// Duration after an announcement before the missing message is requested
+	IWantTimeout *time.Duration `toml:"iwant_timeout,omitempty"`
+
+	// Active peers whose copies arrive this late on average are choked
+	ChokeThreshold *time.Duration `toml:"choke_threshold,omitempty"`
+
+	// Choked peers whose announcements arrive this late on average are unchoked (negative if early)
+	UnchokeThreshold *time.Duration `toml:"unchoke_threshold,omitempty"`
+
+	// Number of heartbeats between the round trip time measurements
+	PingTicks *int `toml:"ping_ticks,omitempty"`
+
+	// Improvement in the round trip time for which the farthest active peer is swapped for the closest passive peer
+	OptimiseThreshold *time.Duration `toml:"optimise_threshold,omitempty"`
+}
+
+func GetDefaultConfig() *Config {
+	return &Config{
+		HeartbeatInterval: &HeartbeatInterval,
+		HeartbeatPriority: &HeartbeatPriority,
+		ActiveView:        &ActiveView,
+		ActiveViewHigh:    &ActiveViewHigh,
+		PassiveView:       &PassiveView,
+		MinUnchoked:       &MinUnchoked,
+		HistoryLength:     &HistoryLength,
+		IWantTimeout:      &IWantTimeout,
+		ChokeThreshold:    &ChokeThreshold,
+		UnchokeThreshold:  &UnchokeThreshold,
+		PingTicks:         &PingTicks,
+		OptimiseThreshold: &OptimiseThreshold,
+	}
+}
+
+func NewRouter(cfg *Config, rng exprand.Source) *Router {
+	return &Router{
+		cfg:        cfg,
+		rng:        rng,
+		node:       nil,
+		topics:     core.NewSet(),
+		active:     map[string]*core.Set{},
+		passive:    map[string]*core.Set{},
+		choked:     map[string]*core.Set{},
+		chokedBy:   map[string]*core.Set{},
+		rtt:        map[int64]time.Duration{},
+		lateness:   map[string]map[int64]*core.MeanStat{},
+		firstSeen:  map[pubsub.MsgID]time.Time{},
+		announced:  map[pubsub.MsgID]*announcement{},
+		mcache:     gossipsub.NewMessageCache(*cfg.HistoryLength),
+		heartbeats: 0,
+	}
+}
+
+func (router *Router) Start(node *pubsub.Node, logger *zap.Logger) error {
+	var err error
+
+	if !(0 <= *router.cfg.MinUnchoked &&
+		*router.cfg.MinUnchoked <= *router.cfg.ActiveView &&
+		0 < *router.cfg.ActiveView &&
+		*router.cfg.ActiveView <= *router.cfg.ActiveViewHigh &&
+		0 <= *router.cfg.PassiveView) {
+		return InvViewErr
+	}
+	if *router.cfg.HistoryLength <= 0 {
+		return InvHistErr
+	}
+	if *router.cfg.IWantTimeout <= 0 {
+		return InvTimeoutErr
+	}
+	if *router.cfg.PingTicks <= 0 {
+		return InvPingErr
+	}
+	if *router.cfg.OptimiseThreshold < 0 {
+		return InvOptimiseErr
+	}
+
+	router.node = node
+
+	// Start timer for heartbeats
+	router.ticker, err = core.StartTicker(
+		node.Sched,
+		*router.cfg.HeartbeatInterval,
+		core.Priority(*router.cfg.HeartbeatPriority),
+		router,
+		logger,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Peers whose subscriptions are not yet known are added to the views as their announcements arrive
+func (router *Router) Join(topic string) {
+	if router.topics.Exists(topic) {
+		return
+	}
+	router.topics.Add(topic)
+	router.active[topic] = core.NewSet()
+	router.passive[topic] = core.NewSet()
+	router.choked[topic] = core.NewSet()
+	router.chokedBy[topic] = core.NewSet()
+	router.lateness[topic] = map[int64]*core.MeanStat{}
+
+	router.fixPassive(topic)
+	router.fixActive(topic)
+}
+
+func (router *Router) Leave(topic string) {
+	if !router.topics.Exists(topic) {
+		return
+	}
+	router.active[topic].Traverse(func(iNeighborID interface{}) {
+		router.node.SendRPC(iNeighborID.(int64), NewControlMsg([]pubsub.Message{}, &ControlMessage{leave: []string{topic}}))
+	})
+	delete(router.active, topic)
+	delete(router.passive, topic)
+	delete(router.choked, topic)
+	delete(router.chokedBy, topic)
+	delete(router.lateness, topic)
+	// the messages announced on the topic are no longer requested
+	for msgID, ann := range router.announced {
+		if ann.topic == topic {
+			ann.task.Cancel()
+			delete(router.announced, msgID)
+		}
+	}
+	router.topics.Remove(topic)
+}
+
+// Messages are pushed to the active peers except the peers that choked the local node, which are sent IHAVE
+// Messages published on a topic that is not joined are sent to active_view random peers subscribed to the topic
+func (router *Router) PublishMsg(srcID int64, msg pubsub.Message) {
+	// add message to cache
+	router.mcache.Add(msg)
+
+	msgID := pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}
+	router.firstSeen[msgID] = router.node.Sched.CurTime
+	// the announcer of the message was earlier than the first copy
+	if ann, exists := router.announced[msgID]; exists {
+		router.addLateness(ann.topic, ann.peerID, ann.time.Sub(router.node.Sched.CurTime))
+		ann.task.Cancel()
+		delete(router.announced, msgID)
+	}
+
+	active, joined := router.active[msg.Topic()]
+	if !joined {
+		filter := func(neighborID int64) bool {
+			return router.node.PeerSubscribed(neighborID, msg.Topic())
+		}
+		for _, neighborID := range router.getRandomNeighbors(*router.cfg.ActiveView, filter) {
+			router.node.SendRPC(neighborID, NewDataMsg(msg))
+		}
+		return
+	}
+
+	chokedBy := router.chokedBy[msg.Topic()]
+	active.Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		if neighborID == srcID || neighborID == msg.From() {
+			return
+		}
+		if chokedBy.Exists(neighborID) {
+			ihave := []*IHave{{topic: msg.Topic(), msgIDs: core.NewSet(msgID)}}
+			router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, &ControlMessage{ihave: ihave}))
+			return
+		}
+		router.node.SendRPC(neighborID, NewDataMsg(msg))
+	})
+}
+
+func (router *Router) HandleRPC(srcID int64, rpcMsg pubsub.RPC) {
+	// the first copies were already handled while publishing
+	for _, msg := range rpcMsg.GetMessages() {
+		msgID := pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}
+		if firstSeen, exists := router.firstSeen[msgID]; exists {
+			router.addLateness(msg.Topic(), srcID, router.node.Sched.CurTime.Sub(firstSeen))
+		}
+	}
+
+	control := rpcMsg.(*RPCMsg).control
+	if control == nil {
+		return
+	}
+
+	router.handleIHave(srcID, control.ihave)
+	msgs := router.handleIWant(control.iwant)
+	leave := router.handleJoin(srcID, control.join)
+	router.handleLeave(srcID, control.leave)
+	for _, topic := range control.choke {
+		if active, joined := router.active[topic]; joined && active.Exists(srcID) {
+			router.chokedBy[topic].Add(srcID)
+		}
+	}
+	for _, topic := range control.unchoke {
+		if chokedBy, joined := router.chokedBy[topic]; joined {
+			chokedBy.Remove(srcID)
+		}
+	}
+	if control.pong != nil {
+		router.rtt[srcID] = router.node.Sched.CurTime.Sub(control.pong.sentAt)
+	}
+
+	pong := (*Pong)(nil)
+	if control.ping != nil {
+		pong = &Pong{sentAt: control.ping.sentAt}
+	}
+	if len(msgs) == 0 && len(leave) == 0 && pong == nil {
+		return
+	}
+	router.node.SendRPC(srcID, NewControlMsg(msgs, &ControlMessage{leave: leave, pong: pong}))
+}
+
+// Announcements of the seen messages count towards the lateness of the announcer
+// The missing messages are requested from the first announcer after the timeout
+func (router *Router) handleIHave(remoteID int64, ihave []*IHave) {
+	for _, topicIHave := range ihave {
+		// not interested in the messages of topics that are not joined
+		if !router.topics.Exists(topicIHave.topic) {
+			continue
+		}
+		topicIHave.msgIDs.Traverse(func(iMsgID interface{}) {
+			msgID := iMsgID.(pubsub.MsgID)
+			if firstSeen, exists := router.firstSeen[msgID]; exists {
+				router.addLateness(topicIHave.topic, remoteID, router.node.Sched.CurTime.Sub(firstSeen))
+				return
+			}
+			if _, exists := router.announced[msgID]; exists || router.node.SeenMsgs.SeenMsg(msgID) {
+				return
+			}
+			router.announced[msgID] = &announcement{
+				peerID: remoteID,
+				topic:  topicIHave.topic,
+				time:   router.node.Sched.CurTime,
+				task:   router.node.Sched.Schedule(*router.cfg.IWantTimeout, &iwantEvent{router: router, msgID: msgID}),
+			}
+		})
+	}
+}
+
+func (router *Router) handleIWant(iwant *IWant) []pubsub.Message {
+	msgs := []pubsub.Message{}
+	if iwant == nil {
+		return msgs
+	}
+	iwant.msgIDs.Traverse(func(iMsgID interface{}) {
+		if msg, exists := router.mcache.GetMessage(iMsgID.(pubsub.MsgID)); exists {
+			msgs = append(msgs, msg)
+		}
+	})
+	return msgs
+}
+
+// Returns the topics the peer could not join
+func (router *Router) handleJoin(remoteID int64, join []string) []string {
+	leave := []string{}
+	for _, topic := range join {
+		active, joined := router.active[topic]
+		if joined && active.Exists(remoteID) {
+			continue
+		}
+		if !joined || active.Len() >= *router.cfg.ActiveViewHigh {
+			leave = append(leave, topic)
+			continue
+		}
+		router.addToActive(remoteID, topic)
+	}
+	return leave
+}
+
+// The peer leaving the active view stays in the passive view
+func (router *Router) handleLeave(remoteID int64, leave []string) {
+	for _, topic := range leave {
+		if !router.topics.Exists(topic) || !router.active[topic].Exists(remoteID) {
+			continue
+		}
+		router.removeFromActive(remoteID, topic)
+		if router.passive[topic].Len() < *router.cfg.PassiveView {
+			router.passive[topic].Add(remoteID)
+		}
+	}
+	// NOTE: the active view may fall below active_view
+	//   this is adjusted for periodically during the view maintenance in heartbeat
+}
+
+func (router *Router) addToActive(neighborID int64, topic string) {
+	router.active[topic].Add(neighborID)
+	router.passive[topic].Remove(neighborID)
+}
+
+func (router *Router) removeFromActive(neighborID int64, topic string) {
+	router.active[topic].Remove(neighborID)
+	router.choked[topic].Remove(neighborID)
+	router.chokedBy[topic].Remove(neighborID)
+	delete(router.lateness[topic], neighborID)
+}
+
+// Only the lateness of the active peers is tracked
+func (router *Router) addLateness(topic string, neighborID int64, lateness time.Duration) {
+	active, joined := router.active[topic]
+	if !joined || !active.Exists(neighborID) {
+		return
+	}
+	if _, exists := router.lateness[topic][neighborID]; !exists {
+		router.lateness[topic][neighborID] = &core.MeanStat{}
+	}
+	router.lateness[topic][neighborID].AddValue(float64(lateness) / float64(time.Millisecond))
+}
+
+// The views are refilled on the next heartbeat
+func (router *Router) AddPeer(remoteID int64) {}
+
+func (router *Router) RemovePeer(remoteID int64) {
+	router.topics.Traverse(func(iTopic interface{}) {
+		topic := iTopic.(string)
+		router.removeFromActive(remoteID, topic)
+		router.passive[topic].Remove(remoteID)
+	})
+	delete(router.rtt, remoteID)
+}
+
+// The new subscriber is joined right away if the active view is below active_view
+//   otherwise it is added to the passive view if there is room
+func (router *Router) HandleSubscription(remoteID int64, topic string, subscribe bool) {
+	active, joined := router.active[topic]
+	if !joined {
+		return
+	}
+	if !subscribe {
+		router.removeFromActive(remoteID, topic)
+		router.passive[topic].Remove(remoteID)
+		return
+	}
+	if active.Exists(remoteID) {
+		return
+	}
+	if active.Len() < *router.cfg.ActiveView {
+		router.join(remoteID, topic)
+		return
+	}
+	if router.passive[topic].Len() < *router.cfg.PassiveView {
+		router.passive[topic].Add(remoteID)
+	}
+}
+
+func (router *Router) join(neighborID int64, topic string) {
+	router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, &ControlMessage{join: []string{topic}}))
+	router.addToActive(neighborID, topic)
+}
+
+func (router *Router) Stop() {
+	router.ticker.Stop()
+	for _, ann := range router.announced {
+		ann.task.Cancel()
+	}
+}
+
+func (router *Router) AcceptFrom(srcID int64) bool {
+	return true
+}
+
+// Round trip time to the peer, zero if not measured yet
+func (router *Router) RTT(peerID int64) time.Duration {
+	return router.rtt[peerID]
+}
+
+// The views of the topics are maintained independently in the order of joining
+func (router *Router) HandleTick() {
+	router.heartbeats++
+	router.clearHistory()
+
+	// measure the round trip times to the peers in the views
+	if (router.heartbeats-1)%*router.cfg.PingTicks == 0 {
+		router.ping()
+	}
+
+	router.topics.Traverse(func(iTopic interface{}) {
+		topic := iTopic.(string)
+		router.fixPassive(topic)
+		router.updateChokes(topic)
+		router.optimise(topic)
+		router.fixActive(topic)
+	})
+
+	// shift the cache
+	router.mcache.Shift()
+}
+
+func (router *Router) ping() {
+	peerIDs := core.NewSet()
+	router.topics.Traverse(func(iTopic interface{}) {
+		topic := iTopic.(string)
+		peerIDs.Add(router.active[topic].Flatten()...)
+		peerIDs.Add(router.passive[topic].Flatten()...)
+	})
+	peerIDs.Traverse(func(iNeighborID interface{}) {
+		ping := &Ping{sentAt: router.node.Sched.CurTime}
+		router.node.SendRPC(iNeighborID.(int64), NewControlMsg([]pubsub.Message{}, &ControlMessage{ping: ping}))
+	})
+}
+
+// Forgets the peers no longer subscribed to the topic and tops up the passive view with random subscribed peers
+func (router *Router) fixPassive(topic string) {
+	passive := router.passive[topic]
+	for _, iNeighborID := range passive.Flatten() {
+		neighborID := iNeighborID.(int64)
+		if !router.node.PeerSubscribed(neighborID, topic) {
+			passive.Remove(neighborID)
+		}
+	}
+	deficit := *router.cfg.PassiveView - passive.Len()
+	for _, neighborID := range router.getRandomNeighbors(deficit, router.filterOut(topic)) {
+		passive.Add(neighborID)
+	}
+}
+
+// Chokes the active peers delivering late on average, the latest first, while more than min_unchoked are unchoked
+// Unchokes the choked peers announcing early enough
+func (router *Router) updateChokes(topic string) {
+	active := router.active[topic]
+	choked := router.choked[topic]
+	lateness := router.lateness[topic]
+	router.lateness[topic] = map[int64]*core.MeanStat{}
+
+	for _, iNeighborID := range choked.Flatten() {
+		neighborID := iNeighborID.(int64)
+		stat, exists := lateness[neighborID]
+		if exists && stat.Value < float64(*router.cfg.UnchokeThreshold)/float64(time.Millisecond) {
+			choked.Remove(neighborID)
+			router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, &ControlMessage{unchoke: []string{topic}}))
+		}
+	}
+
+	late := []int64{}
+	active.Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		stat, exists := lateness[neighborID]
+		if !choked.Exists(neighborID) && exists && stat.Value > float64(*router.cfg.ChokeThreshold)/float64(time.Millisecond) {
+			late = append(late, neighborID)
+		}
+	})
+	sort.SliceStable(late, func(i, j int) bool {
+		return lateness[late[i]].Value > lateness[late[j]].Value
+	})
+	for _, neighborID := range late {
+		if active.Len()-choked.Len() <= *router.cfg.MinUnchoked {
+			break
+		}
+		choked.Add(neighborID)
+		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, &ControlMessage{choke: []string{topic}}))
+	}
+}
+
+// Swaps the farthest active peer for the closest passive peer if the round trip time improves enough
+func (router *Router) optimise(topic string) {
+	active := router.active[topic]
+	if active.Len() < *router.cfg.ActiveView {
+		return
+	}
+	farthest := router.sortByRTT(active)
+	closest := router.sortByRTT(router.passive[topic])
+	if len(farthest) == 0 || len(closest) == 0 {
+		return
+	}
+	worstID := farthest[len(farthest)-1]
+	bestID := closest[0]
+	worst, worstKnown := router.rtt[worstID]
+	best, bestKnown := router.rtt[bestID]
+	if !worstKnown || !bestKnown || best+*router.cfg.OptimiseThreshold >= worst {
+		return
+	}
+
+	router.node.SendRPC(worstID, NewControlMsg([]pubsub.Message{}, &ControlMessage{leave: []string{topic}}))
+	router.removeFromActive(worstID, topic)
+	router.passive[topic].Add(worstID)
+	router.join(bestID, topic)
+}
+
+// Tops up the active view with the closest passive peers
+func (router *Router) fixActive(topic string) {
+	active := router.active[topic]
+	if active.Len() >= *router.cfg.ActiveView {
+		return
+	}
+	deficit := *router.cfg.ActiveView - active.Len()
+	for _, neighborID := range router.sortByRTT(router.passive[topic]) {
+		if deficit == 0 {
+			break
+		}
+		router.join(neighborID, topic)
+		deficit--
+	}
+}
+
+// Peers sorted by the round trip time, random among the equal ones and the unmeasured ones last
+func (router *Router) sortByRTT(peerIDs *core.Set) []int64 {
+	sorted := []int64{}
+	peerIDs.Traverse(func(iNeighborID interface{}) {
+		sorted = append(sorted, iNeighborID.(int64))
+	})
+	exprand.New(router.rng).Shuffle(len(sorted), func(i, j int) {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	})
+	sort.SliceStable(sorted, func(i, j int) bool {
+		rttI, knownI := router.rtt[sorted[i]]
+		rttJ, knownJ := router.rtt[sorted[j]]
+		if knownI != knownJ {
+			return knownI
+		}
+		return rttI < rttJ
+	})
+	return sorted
+}
+
+// Forgets the arrival times and the announcements older than the message cache
+func (router *Router) clearHistory() {
+	ttl := time.Duration(*router.cfg.HistoryLength) * *router.cfg.HeartbeatInterval
+	for msgID, firstSeen := range router.firstSeen {
+		if !firstSeen.Add(ttl).After(router.node.Sched.CurTime) {
+			delete(router.firstSeen, msgID)
+		}
+	}
+	for msgID, ann := range router.announced {
+		if !ann.time.Add(ttl).After(router.node.Sched.CurTime) {
+			ann.task.Cancel()
+			delete(router.announced, msgID)
+		}
+	}
+}
+
+// Requests the announced message if it is still missing
+// The announcement is retained to compare its time with the arrival of the message
+func (router *Router) requestMissing(msgID pubsub.MsgID) {
+	ann, exists := router.announced[msgID]
+	// the announcer may have disconnected in the meantime
+	if !exists || router.node.SeenMsgs.SeenMsg(msgID) || !router.node.NeighborIDs.Exists(ann.peerID) {
+		return
+	}
+	iwant := &IWant{msgIDs: core.NewSet(msgID)}
+	router.node.SendRPC(ann.peerID, NewControlMsg([]pubsub.Message{}, &ControlMessage{iwant: iwant}))
+}
+
+func (router *Router) getRandomNeighbors(count int, filter func(int64) bool) []int64 {
+	neighborIDs := []int64{}
+	router.node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		if filter(neighborID) {
+			neighborIDs = append(neighborIDs, neighborID)
+		}
+	})
+
+	// shuffle our neighbors (pick random count elements from the slice)
+	exprand.New(router.rng).Shuffle(len(neighborIDs), func(i, j int) {
+		neighborIDs[i], neighborIDs[j] = neighborIDs[j], neighborIDs[i]
+	})
+
+	// cannot pick more than the elements already present
+	if count < 0 {
+		count = 0
+	}
+	if count > len(neighborIDs) {
+		count = len(neighborIDs)
+	}
+	return neighborIDs[:count]
+}
+
+// Peers subscribed to the topic and in neither view
+func (router *Router) filterOut(topic string) func(int64) bool {
+	return func(neighborID int64) bool {
+		return router.node.PeerSubscribed(neighborID, topic) &&
+			!router.active[topic].Exists(neighborID) &&
+			!router.passive[topic].Exists(neighborID)
+	}
+}
+
+func (router *Router) ID() int64 {
+	return router.node.ID()
+}
+
+// Fires once the IWANT timeout of an announcement expires
+type iwantEvent struct {
+	router *Router
+	msgID  pubsub.MsgID
+}
+
+func (event *iwantEvent) Trigger() {
+	event.router.requestMissing(event.msgID)
+}
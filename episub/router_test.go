@@ -0,0 +1,114 @@
+package episub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"github.com/marlinprotocol/p2psim/pubsub/pubsubtest"
+	exprand "golang.org/x/exp/rand"
+)
+
+func TestInvConfig(t *testing.T) {
+	zero := 0
+	negative := -1
+	four := 4
+	newRouter := func(update func(*Config)) pubsub.Router {
+		cfg := GetDefaultConfig()
+		update(cfg)
+		return NewRouter(cfg, exprand.NewSource(55))
+	}
+	pubsubtest.CheckInvRouters(t, []pubsubtest.InvRouter{
+		{Router: newRouter(func(cfg *Config) { cfg.ActiveView = &zero }), Err: InvViewErr},
+		{Router: newRouter(func(cfg *Config) { cfg.ActiveViewHigh = &four }), Err: InvViewErr},
+		{Router: newRouter(func(cfg *Config) { cfg.MinUnchoked = &negative }), Err: InvViewErr},
+		{Router: newRouter(func(cfg *Config) { cfg.PassiveView = &negative }), Err: InvViewErr},
+		{Router: newRouter(func(cfg *Config) { cfg.PingTicks = &zero }), Err: InvPingErr},
+	})
+}
+
+// star around node 0 where peer i is 10*i ms away
+func TestViewOptimisation(t *testing.T) {
+	activeView := 3
+	cfg := GetDefaultConfig()
+	cfg.ActiveView, cfg.ActiveViewHigh = &activeView, &activeView
+	edges := [][3]int64{}
+	for peerID := int64(1); peerID <= 8; peerID++ {
+		edges = append(edges, [3]int64{0, peerID, 10 * peerID})
+	}
+	sched, _, _, routers := pubsubtest.SpawnNodesWithLatencies(t, 9, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, edges, true)
+
+	active := routers[0].(*Router).active[pubsub.DefaultTopic]
+	passive := routers[0].(*Router).passive[pubsub.DefaultTopic]
+	if active.Len() != activeView || passive.Len() != 8-activeView {
+		t.Fatalf("Unexpected views %v and %v", active.Flatten(), passive.Flatten())
+	}
+
+	// the farthest active peer is swapped for the closest passive peer on every heartbeat
+	sched.RunFor(10 * *cfg.HeartbeatInterval)
+	for peerID := int64(1); peerID <= 8; peerID++ {
+		if routers[0].(*Router).RTT(peerID) != time.Duration(20*peerID)*time.Millisecond {
+			t.Errorf("Round trip time %v to peer %v", routers[0].(*Router).RTT(peerID), peerID)
+		}
+		if active.Exists(peerID) != (peerID <= int64(activeView)) {
+			t.Errorf("Active view %v does not contain the closest peers", active.Flatten())
+		}
+	}
+}
+
+// node 2 receives the messages of node 0 directly and through node 1 over a slow link
+func TestChoke(t *testing.T) {
+	minUnchoked := 1
+	cfg := GetDefaultConfig()
+	cfg.MinUnchoked = &minUnchoked
+	sched, net, nodes, routers := pubsubtest.SpawnNodesWithLatencies(t, 3, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, [][3]int64{{0, 1, 10}, {0, 2, 10}, {1, 2, 100}}, true)
+	publish := func(count int) {
+		for i := 0; i < count; i++ {
+			nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+			sched.RunFor(100 * time.Millisecond)
+		}
+	}
+
+	// node 1 delivers late and is choked
+	publish(20)
+	if !routers[2].(*Router).choked[pubsub.DefaultTopic].Exists(int64(1)) || !routers[1].(*Router).chokedBy[pubsub.DefaultTopic].Exists(int64(2)) {
+		t.Fatalf("Did not choke the late peer")
+	}
+
+	// node 1 announces the messages before they arrive from node 0 once the direct link slows down
+	//   node 2 requests the messages from node 1 and unchokes it
+	net.SetLinkLatency(0, 2, 500)
+	publish(20)
+	sched.RunFor(time.Second)
+	if routers[2].(*Router).choked[pubsub.DefaultTopic].Exists(int64(1)) || !routers[2].(*Router).choked[pubsub.DefaultTopic].Exists(int64(0)) {
+		t.Errorf("Peers %v choked, expected only node 0", routers[2].(*Router).choked[pubsub.DefaultTopic].Flatten())
+	}
+	for seqno := int64(1); seqno <= 40; seqno++ {
+		if !nodes[2].SeenMsgs.SeenMsg(pubsub.MsgID{From: 0, Seqno: seqno}) {
+			t.Errorf("Did not receive message %v", seqno)
+		}
+	}
+}
+
+// node 1 leaves the topic while the message announced by node 0 is missing
+func TestLeave(t *testing.T) {
+	sched, _, nodes, routers := pubsubtest.SpawnNodesWithLatencies(t, 2, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(GetDefaultConfig(), rng)
+	}, [][3]int64{{0, 1, 10}}, true)
+	routers[0].(*Router).chokedBy[pubsub.DefaultTopic].Add(int64(1))
+
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	sched.RunFor(20 * time.Millisecond)
+	if len(routers[1].(*Router).announced) != 1 {
+		t.Fatalf("%v messages announced, expected 1", len(routers[1].(*Router).announced))
+	}
+	ann := routers[1].(*Router).announced[pubsub.MsgID{From: 0, Seqno: 1}]
+	nodes[1].Unsubscribe(pubsub.DefaultTopic)
+	if len(routers[1].(*Router).announced) != 0 || ann.task.IsPending() {
+		t.Errorf("Still requests the message announced on the topic left")
+	}
+}
@@ -0,0 +1,96 @@
+package episub
+
+import (
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+)
+
+type RPCMsg struct {
+	size    int64
+	msgs    []pubsub.Message
+	control *ControlMessage
+}
+
+// JOIN, LEAVE, CHOKE and UNCHOKE messages carry the topics they refer to
+type ControlMessage struct {
+	ihave   []*IHave
+	iwant   *IWant
+	join    []string
+	leave   []string
+	choke   []string
+	unchoke []string
+	ping    *Ping
+	pong    *Pong
+}
+
+// Sent right away in place of the message to the peers that choked the local node (lazy push)
+type IHave struct {
+	topic string
+	// Set of MsgID
+	msgIDs *core.Set
+}
+
+type IWant struct {
+	// Set of MsgID
+	msgIDs *core.Set
+}
+
+// Measures the round trip time to the peer
+type Ping struct {
+	sentAt time.Time
+}
+
+// Echoes the send time of the ping
+type Pong struct {
+	sentAt time.Time
+}
+
+func NewDataMsg(msg pubsub.Message) *RPCMsg {
+	return &RPCMsg{
+		size:    msg.GetSize(),
+		msgs:    []pubsub.Message{msg},
+		control: nil,
+	}
+}
+
+func NewControlMsg(msgs []pubsub.Message, control *ControlMessage) *RPCMsg {
+	// compute size
+	size := int64(0)
+	for _, msg := range msgs {
+		size += msg.GetSize()
+	}
+	for _, topicIHave := range control.ihave {
+		size += int64(len(topicIHave.topic)) + int64(topicIHave.msgIDs.Len())*8
+	}
+	if control.iwant != nil {
+		size += int64(control.iwant.msgIDs.Len()) * 8
+	}
+	for _, topics := range [][]string{control.join, control.leave, control.choke, control.unchoke} {
+		for _, topic := range topics {
+			size += int64(len(topic)) + 1
+		}
+	}
+	// timestamps take 8 bytes
+	if control.ping != nil {
+		size += 8
+	}
+	if control.pong != nil {
+		size += 8
+	}
+
+	return &RPCMsg{
+		size:    size,
+		msgs:    msgs,
+		control: control,
+	}
+}
+
+func (rpcMsg *RPCMsg) GetSize() int64 {
+	return rpcMsg.size
+}
+
+func (rpcMsg *RPCMsg) GetMessages() []pubsub.Message {
+	return rpcMsg.msgs
+}
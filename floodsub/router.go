@@ -23,9 +23,13 @@ func (router *Router) Start(node *pubsub.Node, logger *zap.Logger) error {
 	return nil
 }
 
+// Messages are flooded to the neighbors subscribed to the topic
 func (router *Router) PublishMsg(srcID int64, msg pubsub.Message) {
 	router.node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
 		neighborID := iNeighborID.(int64)
+		if !router.node.PeerSubscribed(neighborID, msg.Topic()) {
+			return
+		}
 		if msg.From() != neighborID && srcID != neighborID {
 			// do not resend the message back or to the originator of the message
 			router.node.SendRPC(neighborID, NewDataMsg(msg))
@@ -34,3 +38,20 @@ func (router *Router) PublishMsg(srcID int64, msg pubsub.Message) {
 }
 
 func (router *Router) HandleRPC(srcID int64, rpcMsg pubsub.RPC) {}
+
+// Messages are flooded to the current neighbors and hence no state is kept per peer
+func (router *Router) AddPeer(remoteID int64) {}
+
+func (router *Router) RemovePeer(remoteID int64) {}
+
+func (router *Router) Stop() {}
+
+func (router *Router) Join(topic string) {}
+
+func (router *Router) Leave(topic string) {}
+
+func (router *Router) HandleSubscription(remoteID int64, topic string, subscribe bool) {}
+
+func (router *Router) AcceptFrom(srcID int64) bool {
+	return true
+}
@@ -34,12 +34,16 @@ func (mcache *MessageCache) GetMessage(msgID pubsub.MsgID) (pubsub.Message, bool
 	return msg, ok
 }
 
-func (mcache *MessageCache) GetGossipIDs(historyGossip int) *core.Set {
+// IDs of the recent messages published on the topic
+func (mcache *MessageCache) GetGossipIDs(topic string, historyGossip int) *core.Set {
 	gossipIDs := core.NewSet()
 	// only pick historyGossip (< historyLength) items since some windows were shifted after publsihing ihaves
 	for _, window := range mcache.history[:historyGossip] {
 		for _, msgID := range window {
-			gossipIDs.Add(msgID)
+			// messages seen again after expiring from the seen cache were evicted with their older entries
+			if msg, exists := mcache.msgs[msgID]; exists && msg.Topic() == topic {
+				gossipIDs.Add(msgID)
+			}
 		}
 	}
 	return gossipIDs
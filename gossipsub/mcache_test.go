@@ -9,6 +9,7 @@ import (
 type MCacheMsg struct {
 	from  int64
 	seqno int64
+	topic string
 }
 
 func (msg *MCacheMsg) GetSize() int64 {
@@ -23,6 +24,10 @@ func (msg *MCacheMsg) Seqno() int64 {
 	return msg.seqno
 }
 
+func (msg *MCacheMsg) Topic() string {
+	return msg.topic
+}
+
 func TestShift(t *testing.T) {
 	mcache := NewMessageCache(2)
 	mcache.Add(&MCacheMsg{
@@ -63,7 +68,7 @@ func TestShift(t *testing.T) {
 	}
 
 	// Check gossip IDs in both the windows
-	bothWindows := mcache.GetGossipIDs(2)
+	bothWindows := mcache.GetGossipIDs("", 2)
 	if bothWindows.Len() != 2 ||
 		!bothWindows.Exists(pubsub.MsgID{
 			From:  0,
@@ -76,7 +81,7 @@ func TestShift(t *testing.T) {
 		t.Error("Incorrect gossipIDs repr!")
 	}
 
-	firstWindow := mcache.GetGossipIDs(1)
+	firstWindow := mcache.GetGossipIDs("", 1)
 	if firstWindow.Len() != 1 ||
 		!firstWindow.Exists(pubsub.MsgID{
 			From:  0,
@@ -85,8 +90,31 @@ func TestShift(t *testing.T) {
 		t.Error("Incorrect gossipIDs repr!")
 	}
 
-	noWindows := mcache.GetGossipIDs(0)
+	noWindows := mcache.GetGossipIDs("", 0)
 	if noWindows.Len() != 0 {
 		t.Error("Incorrect gossipIDs repr!")
 	}
 }
+
+func TestGossipTopic(t *testing.T) {
+	mcache := NewMessageCache(2)
+	mcache.Add(&MCacheMsg{
+		from:  0,
+		seqno: 0,
+		topic: "blocks",
+	})
+	mcache.Add(&MCacheMsg{
+		from:  0,
+		seqno: 1,
+		topic: "txs",
+	})
+
+	gossipIDs := mcache.GetGossipIDs("txs", 2)
+	if gossipIDs.Len() != 1 ||
+		!gossipIDs.Exists(pubsub.MsgID{
+			From:  0,
+			Seqno: 1,
+		}) {
+		t.Error("Gossiped about the messages of other topics!")
+	}
+}
@@ -2,6 +2,7 @@ package gossipsub
 
 import (
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/marlinprotocol/p2psim/core"
@@ -11,19 +12,37 @@ import (
 )
 
 var (
-	InvDegErr  = errors.New("Configured degrees do not follow the required constraints!")
-	InvHistErr = errors.New("Configured the message cache incorrectly!")
+	InvDegErr     = errors.New("Configured degrees do not follow the required constraints!")
+	InvHistErr    = errors.New("Configured the message cache incorrectly!")
+	InvTTLErr     = errors.New("Fanout TTL must be positive!")
+	InvBackoffErr = errors.New("Backoff must be positive!")
+	InvGraftErr   = errors.New("Configured opportunistic grafting incorrectly!")
+	InvSizeErr    = errors.New("IDONTWANT threshold cannot be negative!")
 )
 
 var (
 	// default config params
-	HeartbeatInterval = 1 * time.Second
-	D                 = 6
-	Dlow              = 4
-	Dhigh             = 12
-	HistoryLength     = 5
-	HistoryGossip     = 3
-	Dlazy             = 6
+	HeartbeatInterval       = 1 * time.Second
+	HeartbeatPriority       = int(core.DefaultPriority)
+	D                       = 6
+	Dlow                    = 4
+	Dhigh                   = 12
+	HistoryLength           = 5
+	HistoryGossip           = 3
+	Dlazy                   = 6
+	Dout                    = 2
+	Dscore                  = 4
+	FanoutTTL               = 60 * time.Second
+	PruneBackoff            = 60 * time.Second
+	UnsubscribeBackoff      = 10 * time.Second
+	DoPX                    = false
+	PrunePeers              = 16
+	FloodPublish            = false
+	OpportunisticGraft      = false
+	OpportunisticGraftTicks = 60
+	OpportunisticGraftPeers = 2
+	DoIDontWant             = false
+	IDontWantThreshold      = int64(1024)
 )
 
 type Router struct {
@@ -35,13 +54,45 @@ type Router struct {
 	// initialized while initializing the pubsub node
 	node *pubsub.Node
 
-	// set of peers in the the mesh
+	// topics joined by the local node
+	// underlying type => string
+	topics *core.Set
+
+	// topic -> set of peers in the the mesh of the topic
+	// underlying type => int64 (peer ID)
+	mesh map[string]*core.Set
+
+	// topic -> set of peers the messages published on the topic are sent to when the topic is not joined
+	// underlying type => int64 (peer ID)
+	fanout map[string]*core.Set
+
+	// topic -> time of the last message published on the fanout topic
+	// the fanout peers are forgotten fanout_ttl after the last message
+	lastPub map[string]time.Time
+
+	// topic -> set of peers in the mesh of the topic grafted by the local node (outbound)
 	// underlying type => int64 (peer ID)
-	mesh *core.Set
+	outbound map[string]*core.Set
+
+	// topic -> peer ID -> time until which the peer is not grafted (or accepted as a graft) after a prune
+	backoff map[string]map[int64]time.Time
 
 	// For gossipping IHave messages
 	// To respond to IWant messages in reply
 	mcache *MessageCache
+
+	// triggers the heartbeats, stopped on leaving the network
+	ticker *core.Ticker
+
+	// nil unless peer scoring is configured
+	score *peerScore
+
+	// number of heartbeats so far
+	heartbeats int
+
+	// peer ID -> MsgID -> time the peer declared it does not want the message forwarded
+	// forgotten after history_length heartbeats
+	dontWant map[int64]map[pubsub.MsgID]time.Time
 }
 
 type Config struct {
@@ -49,16 +100,28 @@ type Config struct {
 	// Heartbeats are triggers for periodic gossip
 	HeartbeatInterval *time.Duration `toml:"heartbeat_interval,omitempty"`
 
-	// Desired degree for the mesh.
-	// Currently, the network is static and hence the mesh as well (not using peer scoring from v1.1)
+	// Orders heartbeats among message deliveries triggered at the same instant
+	// Negative values run heartbeats before the deliveries and positive values after the deliveries
+	HeartbeatPriority *int `toml:"heartbeat_priority,omitempty"`
+
+	// Desired degree for the mesh of every topic
+	// The mesh changes as peers connect, disconnect, (un)subscribe and turn negative with peer scoring
 	D *int `toml:"D,omitempty"`
 
 	// Ideal lower bound on the degree of the mesh
-	Dlow *int `toml:Dlow,omitempty`
+	Dlow *int `toml:"Dlow,omitempty"`
 
 	// Upper bound on the degree of the mesh
+	// Oversubscribed meshes are pruned to D on the heartbeat
 	Dhigh *int `toml:"Dhigh,omitempty"`
 
+	// Number of peers grafted by the local node (outbound) retained while pruning an oversubscribed mesh
+	// Outbound peers are grafted on the heartbeat if the mesh has fewer of them
+	Dout *int `toml:"Dout,omitempty"`
+
+	// Number of peers with the highest scores retained while pruning an oversubscribed mesh
+	Dscore *int `toml:"Dscore,omitempty"`
+
 	// Number of heartbeat events for which the message cache remembers seen messages
 	HistoryLength *int `toml:"history_length,omitempty"`
 
@@ -67,28 +130,94 @@ type Config struct {
 
 	// Number of peers the application gossips to
 	Dlazy *int `toml:"Dlazy,omitempty"`
+
+	// Duration for which the fanout peers of a topic are retained after publishing on the topic
+	FanoutTTL *time.Duration `toml:"fanout_ttl,omitempty"`
+
+	// Duration for which a pruned peer is not grafted again
+	// Peers grafting within the backoff are pruned again and penalized with peer scoring
+	PruneBackoff *time.Duration `toml:"prune_backoff,omitempty"`
+
+	// Backoff of the mesh peers on leaving a topic
+	UnsubscribeBackoff *time.Duration `toml:"unsubscribe_backoff,omitempty"`
+
+	// Peer exchange: peers pruned from a full or an oversubscribed mesh are sent other peers of the topic to connect to
+	DoPX *bool `toml:"do_px,omitempty"`
+
+	// Number of peers exchanged on pruning
+	PrunePeers *int `toml:"prune_peers,omitempty"`
+
+	// Messages published by the local node are sent to all the peers subscribed to the topic
+	//   above the publish threshold instead of only the mesh (or fanout) peers
+	FloodPublish *bool `toml:"flood_publish,omitempty"`
+
+	// Peers scoring above the median of the mesh are grafted every opportunistic_graft_ticks heartbeats
+	//   if the median score is below the opportunistic graft threshold (requires peer scoring)
+	OpportunisticGraft *bool `toml:"opportunistic_graft,omitempty"`
+
+	// Number of heartbeats between the opportunistic grafts
+	OpportunisticGraftTicks *int `toml:"opportunistic_graft_ticks,omitempty"`
+
+	// Number of peers grafted opportunistically at a time
+	OpportunisticGraftPeers *int `toml:"opportunistic_graft_peers,omitempty"`
+
+	// Mesh peers are sent IDONTWANT on receiving a message larger than idontwant_threshold bytes (v1.2)
+	// Messages are not forwarded to the peers that declared IDONTWANT for them
+	DoIDontWant *bool `toml:"do_idontwant,omitempty"`
+
+	// Size in bytes above which the messages received are announced with IDONTWANT
+	IDontWantThreshold *int64 `toml:"idontwant_threshold,omitempty"`
+
+	// Peer scoring from v1.1 (see score.go), disabled if unspecified
+	Score *ScoreConfig `toml:"score,omitempty"`
 }
 
 func GetDefaultConfig() *Config {
 	return &Config{
-		HeartbeatInterval: &HeartbeatInterval,
-		D:                 &D,
-		Dlow:              &Dlow,
-		Dhigh:             &Dhigh,
-		HistoryLength:     &HistoryLength,
-		HistoryGossip:     &HistoryGossip,
-		Dlazy:             &Dlazy,
+		HeartbeatInterval:       &HeartbeatInterval,
+		HeartbeatPriority:       &HeartbeatPriority,
+		D:                       &D,
+		Dlow:                    &Dlow,
+		Dhigh:                   &Dhigh,
+		HistoryLength:           &HistoryLength,
+		HistoryGossip:           &HistoryGossip,
+		Dlazy:                   &Dlazy,
+		Dout:                    &Dout,
+		Dscore:                  &Dscore,
+		FanoutTTL:               &FanoutTTL,
+		PruneBackoff:            &PruneBackoff,
+		UnsubscribeBackoff:      &UnsubscribeBackoff,
+		DoPX:                    &DoPX,
+		PrunePeers:              &PrunePeers,
+		FloodPublish:            &FloodPublish,
+		OpportunisticGraft:      &OpportunisticGraft,
+		OpportunisticGraftTicks: &OpportunisticGraftTicks,
+		OpportunisticGraftPeers: &OpportunisticGraftPeers,
+		DoIDontWant:             &DoIDontWant,
+		IDontWantThreshold:      &IDontWantThreshold,
 	}
 }
 
 func NewRouter(cfg *Config, rng exprand.Source) *Router {
-	return &Router{
-		cfg:    cfg,
-		rng:    rng,
-		node:   nil,
-		mesh:   core.NewSet(),
-		mcache: NewMessageCache(*cfg.HistoryLength),
+	router := &Router{
+		cfg:        cfg,
+		rng:        rng,
+		node:       nil,
+		topics:     core.NewSet(),
+		mesh:       map[string]*core.Set{},
+		fanout:     map[string]*core.Set{},
+		lastPub:    map[string]time.Time{},
+		outbound:   map[string]*core.Set{},
+		backoff:    map[string]map[int64]time.Time{},
+		mcache:     NewMessageCache(*cfg.HistoryLength),
+		score:      nil,
+		heartbeats: 0,
+		dontWant:   map[int64]map[pubsub.MsgID]time.Time{},
+	}
+	if cfg.Score != nil {
+		router.score = newPeerScore(cfg.Score)
 	}
+	return router
 }
 
 func (router *Router) Start(node *pubsub.Node, logger *zap.Logger) error {
@@ -97,18 +226,47 @@ func (router *Router) Start(node *pubsub.Node, logger *zap.Logger) error {
 	if !(*router.cfg.HistoryGossip <= *router.cfg.HistoryLength) {
 		return InvHistErr
 	}
+	if !(0 <= *router.cfg.Dlow &&
+		*router.cfg.Dlow <= *router.cfg.D &&
+		*router.cfg.D <= *router.cfg.Dhigh &&
+		0 <= *router.cfg.Dlazy &&
+		0 <= *router.cfg.Dout &&
+		(*router.cfg.Dout < *router.cfg.Dlow || *router.cfg.Dout == 0) &&
+		*router.cfg.Dout <= *router.cfg.D/2 &&
+		0 <= *router.cfg.Dscore &&
+		*router.cfg.Dscore <= *router.cfg.D &&
+		0 <= *router.cfg.PrunePeers) {
+		return InvDegErr
+	}
+	if *router.cfg.FanoutTTL <= 0 {
+		return InvTTLErr
+	}
+	if *router.cfg.PruneBackoff <= 0 || *router.cfg.UnsubscribeBackoff <= 0 {
+		return InvBackoffErr
+	}
+	if *router.cfg.OpportunisticGraftTicks <= 0 || *router.cfg.OpportunisticGraftPeers < 0 {
+		return InvGraftErr
+	}
+	if *router.cfg.IDontWantThreshold < 0 {
+		return InvSizeErr
+	}
 
 	router.node = node
 
-	// Add neighbors to mesh
-	// NOTE: Joining here since the network is static
-	err = router.join()
-	if err != nil {
-		return err
+	if router.score != nil {
+		if err := router.score.Start(node, logger); err != nil {
+			return err
+		}
 	}
 
 	// Start timer for heartbeats
-	err = core.StartTicker(node.Sched, *router.cfg.HeartbeatInterval, router, logger)
+	router.ticker, err = core.StartTicker(
+		node.Sched,
+		*router.cfg.HeartbeatInterval,
+		core.Priority(*router.cfg.HeartbeatPriority),
+		router,
+		logger,
+	)
 	if err != nil {
 		return err
 	}
@@ -116,51 +274,232 @@ func (router *Router) Start(node *pubsub.Node, logger *zap.Logger) error {
 	return nil
 }
 
-func (router *Router) join() error {
-	if !(0 <= *router.cfg.Dlow &&
-		*router.cfg.Dlow <= *router.cfg.D &&
-		*router.cfg.D <= *router.cfg.Dhigh &&
-		0 <= *router.cfg.Dlazy) {
-		return InvDegErr
+// Peers whose subscriptions are not yet known are grafted as their announcements arrive
+func (router *Router) Join(topic string) {
+	if router.topics.Exists(topic) {
+		return
+	}
+	router.topics.Add(topic)
+	router.mesh[topic] = core.NewSet()
+	router.outbound[topic] = core.NewSet()
+
+	// the fanout peers of the topic are grafted first
+	if fanout, exists := router.fanout[topic]; exists {
+		fanout.Traverse(func(iNeighborID interface{}) {
+			neighborID := iNeighborID.(int64)
+			if router.mesh[topic].Len() < *router.cfg.D && router.acceptGraft(topic)(neighborID) {
+				router.graft(neighborID, topic)
+			}
+		})
+		delete(router.fanout, topic)
+		delete(router.lastPub, topic)
 	}
 
-	// Add upto D neighbors to the mesh
-	for _, neighborID := range router.getRandomNeighbors(*router.cfg.D, filterAll()) {
-		// send graft
-		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, nil, nil, &Graft{}, nil))
+	// Add upto D peers subscribed to the topic to the mesh
+	deficit := *router.cfg.D - router.mesh[topic].Len()
+	filter := router.filterOut(topic, router.mesh[topic], router.acceptGraft(topic))
+	for _, neighborID := range router.getRandomNeighbors(deficit, filter) {
+		router.graft(neighborID, topic)
+	}
+}
 
-		// add locally
-		router.mesh.Add(neighborID)
+func (router *Router) Leave(topic string) {
+	if !router.topics.Exists(topic) {
+		return
 	}
+	for _, iNeighborID := range router.mesh[topic].Flatten() {
+		neighborID := iNeighborID.(int64)
+		prune := router.makePrune(neighborID, topic, true, *router.cfg.UnsubscribeBackoff)
+		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, nil, nil, nil, []*Prune{prune}))
+		router.removeFromMesh(neighborID, topic)
+	}
+	delete(router.mesh, topic)
+	delete(router.outbound, topic)
+	router.topics.Remove(topic)
+}
 
-	return nil
+// Picks D random peers subscribed to the topic unless the fanout peers are already known
+func (router *Router) getFanout(topic string) *core.Set {
+	if fanout, exists := router.fanout[topic]; exists && fanout.Len() > 0 {
+		return fanout
+	}
+	fanout := core.NewSet()
+	filter := router.filterOut(topic, fanout, router.acceptPublish)
+	for _, neighborID := range router.getRandomNeighbors(*router.cfg.D, filter) {
+		fanout.Add(neighborID)
+	}
+	router.fanout[topic] = fanout
+	return fanout
+}
+
+// Backs off from the pruned peer and picks the peers exchanged if doPX
+func (router *Router) makePrune(neighborID int64, topic string, doPX bool, backoff time.Duration) *Prune {
+	router.addBackoff(neighborID, topic, backoff)
+	peers := []int64{}
+	if doPX && *router.cfg.DoPX {
+		// peers with a negative score are not exchanged
+		filter := router.filterOut(topic, core.NewSet(neighborID), router.acceptMesh)
+		peers = router.getRandomNeighbors(*router.cfg.PrunePeers, filter)
+	}
+	return &Prune{
+		topic:   topic,
+		peers:   peers,
+		backoff: backoff,
+	}
+}
+
+func (router *Router) addBackoff(neighborID int64, topic string, backoff time.Duration) {
+	if _, exists := router.backoff[topic]; !exists {
+		router.backoff[topic] = map[int64]time.Time{}
+	}
+	expiry := router.node.Sched.CurTime.Add(backoff)
+	if expiry.After(router.backoff[topic][neighborID]) {
+		router.backoff[topic][neighborID] = expiry
+	}
+}
+
+func (router *Router) inBackoff(neighborID int64, topic string) bool {
+	expiry, exists := router.backoff[topic][neighborID]
+	return exists && expiry.After(router.node.Sched.CurTime)
+}
+
+func (router *Router) graft(neighborID int64, topic string) {
+	// send graft
+	router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, nil, nil, []*Graft{{topic: topic}}, nil))
+
+	// add locally
+	router.addToMesh(neighborID, topic, true)
+}
+
+// outbound if grafted by the local node
+func (router *Router) addToMesh(neighborID int64, topic string, outbound bool) {
+	router.mesh[topic].Add(neighborID)
+	if outbound {
+		router.outbound[topic].Add(neighborID)
+	}
+	if router.score != nil {
+		router.score.Graft(neighborID, topic)
+	}
+}
+
+// The peer is pruned from the mesh without notifying it
+func (router *Router) removeFromMesh(neighborID int64, topic string) {
+	if !router.mesh[topic].Exists(neighborID) {
+		return
+	}
+	router.mesh[topic].Remove(neighborID)
+	router.outbound[topic].Remove(neighborID)
+	if router.score != nil {
+		router.score.Prune(neighborID, topic)
+	}
 }
 
+// Messages published on a topic that is not joined are sent to the fanout peers of the topic
+// The messages published by the local node are not sent to the peers below the publish threshold
+// Large messages received are announced with IDONTWANT to the mesh peers before forwarding
 func (router *Router) PublishMsg(srcID int64, msg pubsub.Message) {
 	// add message to cache
 	router.mcache.Add(msg)
 
+	published := srcID == router.node.ID()
+	if router.score != nil && !published {
+		router.score.DeliverMessage(srcID, msg)
+	}
+	if !published {
+		router.sendIDontWant(srcID, msg)
+	}
+
+	// flood the messages published by the local node (without setting up the fanout)
+	if published && *router.cfg.FloodPublish {
+		for _, neighborID := range router.getRandomNeighbors(router.node.NeighborIDs.Len(), router.filterOut(
+			msg.Topic(),
+			core.NewSet(),
+			router.acceptPublish,
+		)) {
+			router.node.SendRPC(neighborID, NewDataMsg(msg))
+		}
+		return
+	}
+
+	peerIDs, joined := router.mesh[msg.Topic()]
+	if !joined {
+		peerIDs = router.getFanout(msg.Topic())
+		router.lastPub[msg.Topic()] = router.node.Sched.CurTime
+	}
+
 	// publish to all our peers in the mesh
-	router.mesh.Traverse(func(iNeighborID interface{}) {
+	peerIDs.Traverse(func(iNeighborID interface{}) {
 		neighborID := iNeighborID.(int64)
-		if neighborID != srcID && neighborID != msg.From() {
+		if published && !router.acceptPublish(neighborID) {
+			return
+		}
+		if neighborID != srcID && neighborID != msg.From() && !router.dontWantMsg(neighborID, msg) {
 			router.node.SendRPC(neighborID, NewDataMsg(msg))
 		}
 	})
 }
 
+// The peers that sent or published the message already have it
+func (router *Router) sendIDontWant(srcID int64, msg pubsub.Message) {
+	mesh, joined := router.mesh[msg.Topic()]
+	if !*router.cfg.DoIDontWant || !joined || msg.GetSize() <= *router.cfg.IDontWantThreshold {
+		return
+	}
+	msgID := pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}
+	mesh.Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		if neighborID != srcID && neighborID != msg.From() {
+			router.node.SendRPC(neighborID, NewIDontWantMsg(core.NewSet(msgID)))
+		}
+	})
+}
+
+func (router *Router) handleIDontWant(remoteID int64, idontwant *IDontWant) {
+	if idontwant == nil {
+		return
+	}
+	if _, exists := router.dontWant[remoteID]; !exists {
+		router.dontWant[remoteID] = map[pubsub.MsgID]time.Time{}
+	}
+	idontwant.msgIDs.Traverse(func(iMsgID interface{}) {
+		router.dontWant[remoteID][iMsgID.(pubsub.MsgID)] = router.node.Sched.CurTime
+	})
+}
+
+func (router *Router) dontWantMsg(neighborID int64, msg pubsub.Message) bool {
+	_, exists := router.dontWant[neighborID][pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}]
+	return exists
+}
+
 func (router *Router) HandleRPC(srcID int64, rpcMsg pubsub.RPC) {
+	if router.score != nil {
+		// the first deliveries were already handled while publishing
+		for _, msg := range rpcMsg.GetMessages() {
+			router.score.DuplicateMessage(srcID, msg)
+		}
+	}
+
 	control := rpcMsg.(*RPCMsg).control
 	if control == nil {
 		return
 	}
 
-	iwant := router.handleIHave(control.ihave)
-	msgs := router.handleIWant(control.iwant)
+	// no gossip is accepted from the peers below the gossip threshold
+	iwant := (*IWant)(nil)
+	msgs := []pubsub.Message{}
+	if router.acceptGossip(srcID) {
+		iwant = router.handleIHave(control.ihave)
+		msgs = router.handleIWant(control.iwant)
+	}
 	prune := router.handleGraft(srcID, control.graft)
 	router.handlePrune(srcID, control.prune)
+	router.handleIDontWant(srcID, control.idontwant)
+
+	if iwant != nil && router.score != nil {
+		router.score.AddPromise(srcID, iwant.msgIDs)
+	}
 
-	if iwant == nil && len(msgs) == 0 && prune == nil {
+	if iwant == nil && len(msgs) == 0 && len(prune) == 0 {
 		return
 	}
 
@@ -168,19 +507,21 @@ func (router *Router) HandleRPC(srcID int64, rpcMsg pubsub.RPC) {
 	router.node.SendRPC(srcID, replyMsg)
 }
 
-func (router *Router) handleIHave(ihave *IHave) *IWant {
-	if ihave == nil {
-		return nil
-	}
-
+func (router *Router) handleIHave(ihave []*IHave) *IWant {
 	// retrieve messages that were not receieved in the fast path from the mesh
 	missing := core.NewSet()
-	ihave.msgIDs.Traverse(func(iMsgID interface{}) {
-		msgID := iMsgID.(pubsub.MsgID)
-		if !router.node.SeenMsgs.SeenMsg(msgID) {
-			missing.Add(msgID)
+	for _, topicIHave := range ihave {
+		// not interested in the messages of topics that are not joined
+		if !router.topics.Exists(topicIHave.topic) {
+			continue
 		}
-	})
+		topicIHave.msgIDs.Traverse(func(iMsgID interface{}) {
+			msgID := iMsgID.(pubsub.MsgID)
+			if !router.node.SeenMsgs.SeenMsg(msgID) {
+				missing.Add(msgID)
+			}
+		})
+	}
 	if missing.Len() == 0 {
 		return nil
 	}
@@ -209,105 +550,415 @@ func (router *Router) handleIWant(iwant *IWant) []pubsub.Message {
 	return msgs
 }
 
-func (router *Router) handleGraft(remoteID int64, graft *Graft) *Prune {
-	if graft == nil {
-		return nil
+// Peers are exchanged only when pruning the peer from a full mesh
+// NOTE: the mesh grows above Dhigh only by grafting outbound peers on the heartbeat
+func (router *Router) handleGraft(remoteID int64, graft []*Graft) []*Prune {
+	prune := []*Prune{}
+	for _, topicGraft := range graft {
+		topic := topicGraft.topic
+		mesh, joined := router.mesh[topic]
+		// cannot add peers to the mesh of a topic that is not joined
+		if !joined {
+			prune = append(prune, router.makePrune(remoteID, topic, false, *router.cfg.PruneBackoff))
+			continue
+		}
+
+		// already added
+		// do not prune
+		if mesh.Exists(remoteID) {
+			continue
+		}
+
+		// grafting within the backoff is penalized and extends the backoff
+		if router.inBackoff(remoteID, topic) {
+			if router.score != nil {
+				router.score.AddPenalty(remoteID, 1)
+			}
+			prune = append(prune, router.makePrune(remoteID, topic, false, *router.cfg.PruneBackoff))
+			continue
+		}
+
+		// cannot add peers with a negative score
+		if !router.acceptMesh(remoteID) {
+			prune = append(prune, router.makePrune(remoteID, topic, false, *router.cfg.PruneBackoff))
+			continue
+		}
+
+		// cannot add any more peers
+		if mesh.Len() >= *router.cfg.Dhigh {
+			prune = append(prune, router.makePrune(remoteID, topic, true, *router.cfg.PruneBackoff))
+			continue
+		}
+
+		// add peer to mesh
+		router.addToMesh(remoteID, topic, false)
 	}
+	return prune
+}
 
-	// already added
-	// do not prune
-	if router.mesh.Exists(remoteID) {
-		return nil
+// The exchanged peers are connected to only if the pruning peer has a high enough score
+func (router *Router) handlePrune(remoteID int64, prune []*Prune) {
+	for _, topicPrune := range prune {
+		if router.topics.Exists(topicPrune.topic) {
+			router.removeFromMesh(remoteID, topicPrune.topic)
+		}
+		router.addBackoff(remoteID, topicPrune.topic, topicPrune.backoff)
+		if len(topicPrune.peers) > 0 && router.acceptPX(remoteID) {
+			router.connectPX(topicPrune.peers)
+		}
 	}
+	// NOTE: number of peers in the mesh may fall below Dlow
+	//   this is adjusted for periodically during the mesh maintenance in heartbeat
+}
 
-	// cannot add any more peers
-	if router.mesh.Len() >= *router.cfg.Dhigh {
-		return &Prune{}
+// Connects to at most prune_peers new peers
+// The new peers are grafted once they announce their subscriptions (while the mesh is below Dlow)
+func (router *Router) connectPX(peers []int64) {
+	count := 0
+	for _, peerID := range peers {
+		if count >= *router.cfg.PrunePeers {
+			break
+		}
+		if peerID == router.node.ID() || router.node.NeighborIDs.Exists(peerID) {
+			continue
+		}
+		if router.node.Connect(peerID) {
+			count++
+		}
 	}
+}
 
-	// add peer to mesh
-	router.mesh.Add(remoteID)
-	return nil
+// The new peer is considered for the meshes once it announces its subscriptions
+func (router *Router) AddPeer(remoteID int64) {
+	if router.score != nil {
+		router.score.AddPeer(remoteID)
+	}
 }
 
-func (router *Router) handlePrune(remoteID int64, prune *Prune) {
-	if prune == nil {
+// The meshes are replenished on the next heartbeat if they fall below Dlow
+//   and the fanout peers if they fall below D
+// The score of the peer is retained in case it reconnects
+func (router *Router) RemovePeer(remoteID int64) {
+	for topic := range router.mesh {
+		router.removeFromMesh(remoteID, topic)
+	}
+	if router.score != nil {
+		router.score.RemovePeer(remoteID)
+	}
+	for _, fanout := range router.fanout {
+		fanout.Remove(remoteID)
+	}
+	delete(router.dontWant, remoteID)
+}
+
+// The new subscriber is grafted right away if the mesh is below Dlow
+//   otherwise it is considered for the mesh and gossip on the following heartbeats
+func (router *Router) HandleSubscription(remoteID int64, topic string, subscribe bool) {
+	if fanout, exists := router.fanout[topic]; exists && !subscribe {
+		fanout.Remove(remoteID)
+	}
+	mesh, joined := router.mesh[topic]
+	if !joined {
 		return
 	}
+	if !subscribe {
+		router.removeFromMesh(remoteID, topic)
+		return
+	}
+	if mesh.Len() >= *router.cfg.Dlow || mesh.Exists(remoteID) || !router.acceptGraft(topic)(remoteID) {
+		return
+	}
+	router.graft(remoteID, topic)
+}
 
-	router.mesh.Remove(remoteID)
-	// NOTE: number of peers in the mesh may fall below Dlow
-	//   this is adjusted for periodically during the mesh maintenance in heartbeat
+func (router *Router) Stop() {
+	router.ticker.Stop()
+	if router.score != nil {
+		router.score.Stop()
+	}
 }
 
+// All RPCs from the peers below the graylist threshold are ignored
+func (router *Router) AcceptFrom(srcID int64) bool {
+	return router.score == nil || router.score.Score(srcID) >= router.score.params.graylistThreshold
+}
+
+// Application specific score of the peers (P5), must be set before starting
+func (router *Router) SetAppScore(appScore func(peerID int64) float64) {
+	if router.score != nil {
+		router.score.appScore = appScore
+	}
+}
+
+// IP of the peer for the IP colocation factor (P6)
+// Peers without an IP are assumed to have a unique IP
+func (router *Router) SetPeerIP(peerID int64, ip string) {
+	if router.score != nil {
+		router.score.peerIPs[peerID] = ip
+	}
+}
+
+// Score of the peer, zero without peer scoring
+func (router *Router) Score(peerID int64) float64 {
+	if router.score == nil {
+		return 0
+	}
+	return router.score.Score(peerID)
+}
+
+// The meshes of the topics are maintained independently in the order of joining
 func (router *Router) HandleTick() {
-	// the mesh is potentially in a bad state because of too few peers
-	toGraft := router.fixMesh()
+	router.clearBackoff()
+	router.clearDontWant()
+	router.heartbeats++
+	opportunisticGraft := *router.cfg.OpportunisticGraft && router.heartbeats%*router.cfg.OpportunisticGraftTicks == 0
+
+	router.topics.Traverse(func(iTopic interface{}) {
+		topic := iTopic.(string)
+
+		// the mesh is potentially in a bad state because of too few or too many peers
+		toGraft := router.fixMesh(topic)
+		if opportunisticGraft {
+			router.graftOpportunistically(topic, toGraft)
+		}
+
+		// slow path gossip of available messages
+		lazy, gossip := router.emitGossip(topic, router.mesh[topic])
+
+		// send control messages for heartbeats
+		router.sendHeartbeats(topic, toGraft, lazy, gossip)
+	})
 
-	// NOTE: do not check if the peer count is too high since the count does not go that high
-	//   assert router.mesh.Len() <= *router.cfg.Dhigh
+	// fanout topics are visited in a fixed order for reproducibility
+	fanoutTopics := []string{}
+	for topic := range router.fanout {
+		fanoutTopics = append(fanoutTopics, topic)
+	}
+	sort.Strings(fanoutTopics)
+	for _, topic := range fanoutTopics {
+		// forget the fanout peers of the topics not published on recently
+		if router.lastPub[topic].Add(*router.cfg.FanoutTTL).Before(router.node.Sched.CurTime) {
+			delete(router.fanout, topic)
+			delete(router.lastPub, topic)
+			continue
+		}
 
-	// slow path gossip of available messages
-	lazy, gossip := router.emitGossip()
+		router.fixFanout(topic)
 
-	// send control messages for heartbeats
-	router.sendHeartbeats(toGraft, lazy, gossip)
+		// peers outside the fanout are gossipped about the published messages
+		lazy, gossip := router.emitGossip(topic, router.fanout[topic])
+		router.sendHeartbeats(topic, core.NewSet(), lazy, gossip)
+	}
 
 	// shift the cache
 	router.mcache.Shift()
 }
 
+// Prunes the peers with a negative score and the excess peers of an oversubscribed mesh right away
 // Return the set of peers to graft
-func (router *Router) fixMesh() *core.Set {
+func (router *Router) fixMesh(topic string) *core.Set {
 	// set of peers (int64)
 	toGraft := core.NewSet()
+	mesh := router.mesh[topic]
+
+	for _, iNeighborID := range mesh.Flatten() {
+		neighborID := iNeighborID.(int64)
+		if !router.acceptMesh(neighborID) {
+			prune := router.makePrune(neighborID, topic, false, *router.cfg.PruneBackoff)
+			router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, nil, nil, nil, []*Prune{prune}))
+			router.removeFromMesh(neighborID, topic)
+		}
+	}
 
 	// verify that the node is connected to enough peers in the mesh
-	if router.mesh.Len() < *router.cfg.Dlow {
+	if mesh.Len() < *router.cfg.Dlow {
 		// bring the number of peers up to the ideal value
-		deficit := *router.cfg.D - router.mesh.Len()
-		neighborIDs := router.getRandomNeighbors(deficit, router.filterOutMesh())
+		deficit := *router.cfg.D - mesh.Len()
+		neighborIDs := router.getRandomNeighbors(deficit, router.filterOut(topic, mesh, router.acceptGraft(topic)))
 		for _, neighborID := range neighborIDs {
 			// cache to send the grafts with gossip
 			toGraft.Add(neighborID)
 
 			// add peer to the mesh (since grafting)
-			router.mesh.Add(neighborID)
+			router.addToMesh(neighborID, topic, true)
+		}
+	}
+
+	// prune the excess peers with peer exchange
+	if mesh.Len() > *router.cfg.Dhigh {
+		for _, neighborID := range router.selectExcess(topic) {
+			prune := router.makePrune(neighborID, topic, true, *router.cfg.PruneBackoff)
+			router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, nil, nil, nil, []*Prune{prune}))
+			router.removeFromMesh(neighborID, topic)
+		}
+	}
+
+	// graft more outbound peers if too few, even if the mesh then exceeds Dhigh until the next heartbeat
+	if mesh.Len() >= *router.cfg.Dlow && router.outbound[topic].Len() < *router.cfg.Dout {
+		deficit := *router.cfg.Dout - router.outbound[topic].Len()
+		neighborIDs := router.getRandomNeighbors(deficit, router.filterOut(topic, mesh, router.acceptGraft(topic)))
+		for _, neighborID := range neighborIDs {
+			toGraft.Add(neighborID)
+			router.addToMesh(neighborID, topic, true)
 		}
 	}
 
 	return toGraft
 }
 
-// Do not send gossip to our peers in the mesh because they already have our messages
+// Grafts random peers scoring above the median of the mesh if the median is below the threshold
+// The grafted peers are added to toGraft
+func (router *Router) graftOpportunistically(topic string, toGraft *core.Set) {
+	mesh := router.mesh[topic]
+	if router.score == nil || mesh.Len() <= 1 {
+		return
+	}
+
+	scores := []float64{}
+	mesh.Traverse(func(iNeighborID interface{}) {
+		scores = append(scores, router.score.Score(iNeighborID.(int64)))
+	})
+	sort.Float64s(scores)
+	median := scores[len(scores)/2]
+	if median >= router.score.params.opportunisticGraftThreshold {
+		return
+	}
+
+	filter := router.filterOut(topic, mesh, func(neighborID int64) bool {
+		return router.acceptGraft(topic)(neighborID) && router.score.Score(neighborID) > median
+	})
+	for _, neighborID := range router.getRandomNeighbors(*router.cfg.OpportunisticGraftPeers, filter) {
+		toGraft.Add(neighborID)
+		router.addToMesh(neighborID, topic, true)
+	}
+}
+
+// Peers pruned from the oversubscribed mesh
+// The Dscore peers with the highest scores are retained and the rest of the D peers retained are random
+//   with at least Dout outbound peers among them, as far as the D - Dscore random peers allow
+func (router *Router) selectExcess(topic string) []int64 {
+	peerIDs := []int64{}
+	router.mesh[topic].Traverse(func(iNeighborID interface{}) {
+		peerIDs = append(peerIDs, iNeighborID.(int64))
+	})
+
+	// shuffle to break the ties between the scores at random
+	rng := exprand.New(router.rng)
+	rng.Shuffle(len(peerIDs), func(i, j int) {
+		peerIDs[i], peerIDs[j] = peerIDs[j], peerIDs[i]
+	})
+	scores := map[int64]float64{}
+	for _, neighborID := range peerIDs {
+		scores[neighborID] = router.Score(neighborID)
+	}
+	sort.SliceStable(peerIDs, func(i, j int) bool {
+		return scores[peerIDs[i]] > scores[peerIDs[j]]
+	})
+	rest := peerIDs[*router.cfg.Dscore:]
+	rng.Shuffle(len(rest), func(i, j int) {
+		rest[i], rest[j] = rest[j], rest[i]
+	})
+
+	// move the outbound peers to the front (preserving the order otherwise) until enough are retained
+	outbound := router.outbound[topic]
+	numOutbound := 0
+	for _, neighborID := range peerIDs[:*router.cfg.D] {
+		if outbound.Exists(neighborID) {
+			numOutbound++
+		}
+	}
+	if numOutbound < *router.cfg.Dout {
+		// the outbound peers are promoted only among the random peers
+		front := append([]int64{}, peerIDs[:*router.cfg.Dscore]...)
+		back := []int64{}
+		for i := *router.cfg.Dscore; i < len(peerIDs); i++ {
+			neighborID := peerIDs[i]
+			if outbound.Exists(neighborID) && (i < *router.cfg.D || numOutbound < *router.cfg.Dout) {
+				if i >= *router.cfg.D {
+					numOutbound++
+				}
+				front = append(front, neighborID)
+			} else {
+				back = append(back, neighborID)
+			}
+		}
+		peerIDs = append(front, back...)
+	}
+	return peerIDs[*router.cfg.D:]
+}
+
+// Forgets the expired backoffs
+func (router *Router) clearBackoff() {
+	for topic, backoff := range router.backoff {
+		for neighborID, expiry := range backoff {
+			if !expiry.After(router.node.Sched.CurTime) {
+				delete(backoff, neighborID)
+			}
+		}
+		if len(backoff) == 0 {
+			delete(router.backoff, topic)
+		}
+	}
+}
+
+// Forgets the IDONTWANT declarations older than the message cache
+func (router *Router) clearDontWant() {
+	ttl := time.Duration(*router.cfg.HistoryLength) * *router.cfg.HeartbeatInterval
+	for neighborID, msgs := range router.dontWant {
+		for msgID, declared := range msgs {
+			if !declared.Add(ttl).After(router.node.Sched.CurTime) {
+				delete(msgs, msgID)
+			}
+		}
+		if len(msgs) == 0 {
+			delete(router.dontWant, neighborID)
+		}
+	}
+}
+
+// Tops up the fanout peers to D with the peers subscribed to the topic
+// No grafts are sent since the fanout peers are not part of a mesh
+func (router *Router) fixFanout(topic string) {
+	fanout := router.fanout[topic]
+	if fanout.Len() >= *router.cfg.D {
+		return
+	}
+	deficit := *router.cfg.D - fanout.Len()
+	for _, neighborID := range router.getRandomNeighbors(deficit, router.filterOut(topic, fanout, router.acceptPublish)) {
+		fanout.Add(neighborID)
+	}
+}
+
+// Do not send gossip to our peers in the mesh (or fanout) because they already have our messages
 // returns
 // - the peers to send the gossip to
 // - messages that are in the cache
-func (router *Router) emitGossip() (*core.Set, *core.Set) {
-	// gossip to Dlazy peers
-	neighborIDs := router.getRandomNeighbors(*router.cfg.Dlazy, router.filterOutMesh())
+func (router *Router) emitGossip(topic string, peerIDs *core.Set) (*core.Set, *core.Set) {
+	// gossip to Dlazy peers subscribed to the topic
+	neighborIDs := router.getRandomNeighbors(*router.cfg.Dlazy, router.filterOut(topic, peerIDs, router.acceptGossip))
 	gossipSet := core.NewSet()
 	for _, neighborID := range neighborIDs {
 		gossipSet.Add(neighborID)
 	}
 
 	// retrieve messages
-	return gossipSet, router.mcache.GetGossipIDs(*router.cfg.HistoryGossip)
+	return gossipSet, router.mcache.GetGossipIDs(topic, *router.cfg.HistoryGossip)
 }
 
-func (router *Router) sendHeartbeats(toGraft *core.Set, lazy *core.Set, gossip *core.Set) {
+func (router *Router) sendHeartbeats(topic string, toGraft *core.Set, lazy *core.Set, gossip *core.Set) {
 	// send grafts
 	// sending ihaves to freshly grafted peers is not necessary
 	toGraft.Traverse(func(iNeighborID interface{}) {
 		neighborID := iNeighborID.(int64)
-		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, nil, nil, &Graft{}, nil))
+		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, nil, nil, []*Graft{{topic: topic}}, nil))
 		// neighborID was already added to the mesh locally
 	})
 
 	// send ihaves to the selected peers
 	lazy.Traverse(func(iNeighborID interface{}) {
 		neighborID := iNeighborID.(int64)
-		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, &IHave{msgIDs: gossip}, nil, nil, nil))
+		ihave := []*IHave{{topic: topic, msgIDs: gossip}}
+		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, ihave, nil, nil, nil))
 	})
 }
 
@@ -332,18 +983,39 @@ func (router *Router) getRandomNeighbors(count int, filter func(int64) bool) []i
 	return neighborIDs[:count]
 }
 
-func (router *Router) filterOutMesh() func(int64) bool {
+// Peers subscribed to the topic, not in the given set (typically the mesh) and accepted by the score threshold
+func (router *Router) filterOut(topic string, peerIDs *core.Set, accept func(int64) bool) func(int64) bool {
 	return func(neighborID int64) bool {
-		return !router.mesh.Exists(neighborID)
+		return router.node.PeerSubscribed(neighborID, topic) && !peerIDs.Exists(neighborID) && accept(neighborID)
 	}
 }
 
-func filterAll() func(int64) bool {
-	return func(int64) bool {
-		return true
+// Score thresholds are ignored without peer scoring
+
+// Only the peers with a non negative score are kept in the mesh
+func (router *Router) acceptMesh(neighborID int64) bool {
+	return router.score == nil || router.score.Score(neighborID) >= 0
+}
+
+// Peers are grafted unless they have a negative score or are in the backoff of the topic
+func (router *Router) acceptGraft(topic string) func(int64) bool {
+	return func(neighborID int64) bool {
+		return router.acceptMesh(neighborID) && !router.inBackoff(neighborID, topic)
 	}
 }
 
+func (router *Router) acceptGossip(neighborID int64) bool {
+	return router.score == nil || router.score.Score(neighborID) >= router.score.params.gossipThreshold
+}
+
+func (router *Router) acceptPublish(neighborID int64) bool {
+	return router.score == nil || router.score.Score(neighborID) >= router.score.params.publishThreshold
+}
+
+func (router *Router) acceptPX(neighborID int64) bool {
+	return router.score == nil || router.score.Score(neighborID) >= router.score.params.acceptPXThreshold
+}
+
 func (router *Router) ID() int64 {
 	return router.node.ID()
 }
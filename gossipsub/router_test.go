@@ -0,0 +1,419 @@
+package gossipsub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"github.com/marlinprotocol/p2psim/pubsub/pubsubtest"
+	exprand "golang.org/x/exp/rand"
+)
+
+// star around node 0
+func TestPeerCallbacks(t *testing.T) {
+	cfg := GetDefaultConfig()
+	sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 8, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, [][2]int64{{0, 1}, {0, 2}, {0, 3}, {0, 4}}, true)
+	connect := func(peerID int64) {
+		nodes[0].AddPeer(peerID)
+		nodes[peerID].AddPeer(0)
+	}
+	disconnect := func(peerID int64) {
+		nodes[0].RemovePeer(peerID)
+		nodes[peerID].RemovePeer(0)
+	}
+	mesh := routers[0].(*Router).mesh[pubsub.DefaultTopic]
+	if mesh.Len() != 4 {
+		t.Fatalf("Mesh of %v peers, expected 4", mesh.Len())
+	}
+
+	// new peers are not grafted while the mesh has at least Dlow peers
+	connect(5)
+	if mesh.Exists(int64(5)) {
+		t.Errorf("Grafted a new peer with %v peers in the mesh", mesh.Len())
+	}
+
+	// disconnected peers leave the mesh
+	for _, peerID := range []int64{1, 2, 3} {
+		disconnect(peerID)
+	}
+	if mesh.Len() != 1 || !mesh.Exists(int64(4)) {
+		t.Fatalf("Mesh contains disconnected peers: %v", mesh.Flatten())
+	}
+
+	// new peers are grafted once they announce their subscriptions while the mesh is below Dlow
+	for _, peerID := range []int64{6, 7} {
+		connect(peerID)
+	}
+	sched.RunFor(100 * time.Millisecond)
+	for _, peerID := range []int64{6, 7} {
+		if !mesh.Exists(peerID) || !routers[peerID].(*Router).mesh[pubsub.DefaultTopic].Exists(int64(0)) {
+			t.Errorf("Did not graft the new peer %v", peerID)
+		}
+	}
+
+	// the remaining peers are grafted on the heartbeat
+	sched.RunFor(*cfg.HeartbeatInterval)
+	if mesh.Len() != 4 || !mesh.Exists(int64(5)) {
+		t.Errorf("Mesh %v was not replenished", mesh.Flatten())
+	}
+}
+
+// star around node 0 subscribed to both the topics
+func TestTopicMeshes(t *testing.T) {
+	txTopic := "txs"
+	sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 5, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(GetDefaultConfig(), rng)
+	}, [][2]int64{{0, 1}, {0, 2}, {0, 3}, {0, 4}}, false)
+	nodes[0].Subscribe(txTopic)
+	for _, peerID := range []int64{3, 4} {
+		nodes[peerID].Unsubscribe(pubsub.DefaultTopic)
+		nodes[peerID].Subscribe(txTopic)
+	}
+	pubsubtest.StartNodes(t, sched, nodes)
+
+	blockMesh, txMesh := routers[0].(*Router).mesh[pubsub.DefaultTopic], routers[0].(*Router).mesh[txTopic]
+	if blockMesh.Len() != 2 || !blockMesh.Exists(int64(1)) || !blockMesh.Exists(int64(2)) {
+		t.Errorf("Mesh of the blocks %v contains peers not subscribed to the topic", blockMesh.Flatten())
+	}
+	if txMesh.Len() != 2 || !txMesh.Exists(int64(3)) || !txMesh.Exists(int64(4)) {
+		t.Errorf("Mesh of the transactions %v contains peers not subscribed to the topic", txMesh.Flatten())
+	}
+
+	// unsubscribing peers leave the mesh
+	nodes[3].Unsubscribe(txTopic)
+	sched.RunFor(100 * time.Millisecond)
+	if txMesh.Exists(int64(3)) {
+		t.Errorf("Mesh %v contains an unsubscribed peer", txMesh.Flatten())
+	}
+
+	// leaving the topic prunes the mesh peers
+	nodes[0].Unsubscribe(pubsub.DefaultTopic)
+	sched.RunFor(100 * time.Millisecond)
+	if _, joined := routers[0].(*Router).mesh[pubsub.DefaultTopic]; joined {
+		t.Error("Did not leave the topic")
+	}
+	if routers[1].(*Router).mesh[pubsub.DefaultTopic].Exists(int64(0)) {
+		t.Error("Did not prune the mesh peers on leaving the topic")
+	}
+}
+
+// star around node 0 publishing without subscribing
+func TestFanout(t *testing.T) {
+	cfg := GetDefaultConfig()
+	edges := [][2]int64{}
+	for peerID := int64(1); peerID <= 8; peerID++ {
+		edges = append(edges, [2]int64{0, peerID})
+	}
+	sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 9, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, edges, false)
+	nodes[0].Unsubscribe(pubsub.DefaultTopic)
+	pubsubtest.StartNodes(t, sched, nodes)
+
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	fanout, exists := routers[0].(*Router).fanout[pubsub.DefaultTopic]
+	if !exists || fanout.Len() != *cfg.D {
+		t.Fatalf("Fanout %v, expected %v peers", fanout, *cfg.D)
+	}
+	if _, joined := routers[0].(*Router).mesh[pubsub.DefaultTopic]; joined {
+		t.Error("Joined the mesh without subscribing")
+	}
+
+	// the fanout peers are retained across messages
+	peerIDs := fanout.Flatten()
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	if !reflect.DeepEqual(routers[0].(*Router).fanout[pubsub.DefaultTopic].Flatten(), peerIDs) {
+		t.Errorf("Fanout changed from %v to %v", peerIDs, routers[0].(*Router).fanout[pubsub.DefaultTopic].Flatten())
+	}
+
+	// the fanout is refreshed on the heartbeat
+	nodes[0].RemovePeer(peerIDs[0].(int64))
+	sched.RunFor(*cfg.HeartbeatInterval)
+	if fanout.Len() != *cfg.D || fanout.Exists(peerIDs[0]) {
+		t.Errorf("Fanout %v was not refreshed", fanout.Flatten())
+	}
+
+	// the fanout expires fanout_ttl after the last message
+	sched.RunFor(*cfg.FanoutTTL + *cfg.HeartbeatInterval)
+	if _, exists := routers[0].(*Router).fanout[pubsub.DefaultTopic]; exists {
+		t.Error("Fanout did not expire")
+	}
+
+	// subscribing to the topic grafts the fanout peers
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	peerIDs = routers[0].(*Router).fanout[pubsub.DefaultTopic].Flatten()
+	nodes[0].Subscribe(pubsub.DefaultTopic)
+	mesh := routers[0].(*Router).mesh[pubsub.DefaultTopic]
+	for _, peerID := range peerIDs {
+		if !mesh.Exists(peerID) {
+			t.Errorf("Fanout peer %v was not grafted", peerID)
+		}
+	}
+	if _, exists := routers[0].(*Router).fanout[pubsub.DefaultTopic]; exists {
+		t.Error("Fanout retained after joining the topic")
+	}
+}
+
+// star around node 0 leaving and rejoining the topic
+func TestPruneBackoff(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.Score = &ScoreConfig{}
+	sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 4, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, [][2]int64{{0, 1}, {0, 2}, {0, 3}}, true)
+
+	// neither side grafts the other again within the backoff
+	nodes[0].Unsubscribe(pubsub.DefaultTopic)
+	nodes[0].Subscribe(pubsub.DefaultTopic)
+	sched.RunFor(*cfg.UnsubscribeBackoff - time.Second)
+	mesh := routers[0].(*Router).mesh[pubsub.DefaultTopic]
+	if mesh.Len() != 0 {
+		t.Errorf("Grafted peers %v within the backoff", mesh.Flatten())
+	}
+	for _, peerID := range []int64{1, 2, 3} {
+		if routers[peerID].(*Router).mesh[pubsub.DefaultTopic].Exists(int64(0)) {
+			t.Errorf("Peer %v grafted within the backoff", peerID)
+		}
+	}
+
+	// grafting within the backoff is penalized
+	routers[0].(*Router).HandleRPC(1, NewControlMsg([]pubsub.Message{}, nil, nil, []*Graft{{topic: pubsub.DefaultTopic}}, nil))
+	if mesh.Exists(int64(1)) || routers[0].(*Router).score.peerStats[1].behaviourPenalty != 1 {
+		t.Errorf("Did not penalize grafting within the backoff")
+	}
+
+	// the mesh is replenished on the heartbeat once the backoff expires
+	sched.RunFor(*cfg.PruneBackoff + 2*time.Second)
+	if mesh.Len() != 3 {
+		t.Errorf("Mesh %v was not replenished after the backoff", mesh.Flatten())
+	}
+}
+
+// star around node 0 with a mesh too small for all its peers
+func TestPeerExchange(t *testing.T) {
+	for _, doPX := range []bool{false, true} {
+		cfg := GetDefaultConfig()
+		d, dlow, dhigh, dout, dscore := 2, 1, 2, 0, 1
+		cfg.D, cfg.Dlow, cfg.Dhigh, cfg.Dout, cfg.Dscore, cfg.DoPX = &d, &dlow, &dhigh, &dout, &dscore, &doPX
+		edges := [][2]int64{}
+		for peerID := int64(1); peerID <= 6; peerID++ {
+			edges = append(edges, [2]int64{0, peerID})
+		}
+		sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 7, func(rng exprand.Source) pubsub.Router {
+			return NewRouter(cfg, rng)
+		}, edges, true)
+		sched.RunFor(400 * time.Millisecond)
+
+		mesh := routers[0].(*Router).mesh[pubsub.DefaultTopic]
+		if mesh.Len() != dhigh {
+			t.Fatalf("Mesh of %v peers, expected %v", mesh.Len(), dhigh)
+		}
+		// the pruned peers connect to the exchanged peers
+		for peerID := int64(1); peerID <= 6; peerID++ {
+			if mesh.Exists(peerID) {
+				continue
+			}
+			numPeers := nodes[peerID].NeighborIDs.Len()
+			if doPX && numPeers == 1 {
+				t.Errorf("Pruned peer %v did not connect to the exchanged peers", peerID)
+			}
+			if !doPX && numPeers != 1 {
+				t.Errorf("Pruned peer %v connected to %v peers without peer exchange", peerID, numPeers)
+			}
+		}
+	}
+}
+
+// star around node 0 with an oversubscribed mesh where the outbound peers have the lowest scores
+// The peers with the highest scores are retained even if they leave no room for the outbound peers
+func TestOversubscription(t *testing.T) {
+	for _, dscore := range []int{Dscore, D} {
+		appWeight := 1.0
+		cfg := GetDefaultConfig()
+		cfg.Score = &ScoreConfig{
+			AppSpecificWeight: &appWeight,
+		}
+		cfg.Dscore = &dscore
+		edges := [][2]int64{}
+		for peerID := int64(1); peerID <= 16; peerID++ {
+			edges = append(edges, [2]int64{0, peerID})
+		}
+		sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 17, func(rng exprand.Source) pubsub.Router {
+			return NewRouter(cfg, rng)
+		}, edges, false)
+		outbound := map[int64]bool{}
+		routers[0].(*Router).SetAppScore(func(peerID int64) float64 {
+			if outbound[peerID] {
+				return 0
+			}
+			return 1
+		})
+		pubsubtest.StartNodes(t, sched, nodes)
+
+		mesh := routers[0].(*Router).mesh[pubsub.DefaultTopic]
+		routers[0].(*Router).outbound[pubsub.DefaultTopic].Traverse(func(iNeighborID interface{}) {
+			outbound[iNeighborID.(int64)] = true
+		})
+		if len(outbound) < *cfg.Dout {
+			t.Fatalf("Dscore %v: grafted %v outbound peers, expected at least %v", dscore, len(outbound), *cfg.Dout)
+		}
+		for peerID := int64(1); peerID <= 16; peerID++ {
+			routers[0].(*Router).addToMesh(peerID, pubsub.DefaultTopic, false)
+		}
+
+		// the mesh is pruned to D on the heartbeat
+		sched.RunFor(*cfg.HeartbeatInterval)
+		if mesh.Len() != *cfg.D {
+			t.Fatalf("Dscore %v: mesh of %v peers after pruning, expected %v", dscore, mesh.Len(), *cfg.D)
+		}
+		numOutbound, numBest := 0, 0
+		mesh.Traverse(func(iNeighborID interface{}) {
+			if outbound[iNeighborID.(int64)] {
+				numOutbound++
+			} else {
+				numBest++
+			}
+		})
+		minOutbound := *cfg.Dout
+		if *cfg.D-*cfg.Dscore < minOutbound {
+			minOutbound = *cfg.D - *cfg.Dscore
+		}
+		if numOutbound < minOutbound || numBest < *cfg.Dscore {
+			t.Errorf(
+				"Dscore %v: retained %v outbound peers and %v peers with the highest score",
+				dscore,
+				numOutbound,
+				numBest,
+			)
+		}
+
+		// the excess peers are sent a prune
+		sched.RunFor(100 * time.Millisecond)
+		for peerID := int64(1); peerID <= 16; peerID++ {
+			if !mesh.Exists(peerID) && routers[peerID].(*Router).mesh[pubsub.DefaultTopic].Exists(int64(0)) {
+				t.Errorf("Dscore %v: did not prune peer %v", dscore, peerID)
+			}
+		}
+	}
+}
+
+// star around node 0 with more peers than fit in its mesh
+func TestFloodPublish(t *testing.T) {
+	for _, floodPublish := range []bool{false, true} {
+		cfg := GetDefaultConfig()
+		cfg.FloodPublish = &floodPublish
+		edges := [][2]int64{}
+		for peerID := int64(1); peerID <= 16; peerID++ {
+			edges = append(edges, [2]int64{0, peerID})
+		}
+		sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 17, func(rng exprand.Source) pubsub.Router {
+			return NewRouter(cfg, rng)
+		}, edges, true)
+
+		// only the mesh peers receive the message without flood publishing
+		nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+		sched.RunFor(100 * time.Millisecond)
+		numRecv := 0
+		for peerID := int64(1); peerID <= 16; peerID++ {
+			if nodes[peerID].SeenMsgs.SeenMsg(pubsub.MsgID{From: 0, Seqno: 1}) {
+				numRecv++
+			}
+		}
+		expected := routers[0].(*Router).mesh[pubsub.DefaultTopic].Len()
+		if floodPublish {
+			expected = 16
+		}
+		if numRecv != expected {
+			t.Errorf("Flood publish %v: %v peers received the message, expected %v", floodPublish, numRecv, expected)
+		}
+	}
+}
+
+// star around node 0 where the peers left out of its full mesh have the highest scores
+func TestOpportunisticGraft(t *testing.T) {
+	for _, opportunisticGraft := range []bool{false, true} {
+		appWeight := 1.0
+		ticks := 1
+		d, dlow, dhigh := 4, 3, 4
+		cfg := GetDefaultConfig()
+		cfg.D, cfg.Dlow, cfg.Dhigh = &d, &dlow, &dhigh
+		cfg.OpportunisticGraft, cfg.OpportunisticGraftTicks = &opportunisticGraft, &ticks
+		cfg.Score = &ScoreConfig{
+			AppSpecificWeight: &appWeight,
+		}
+		edges := [][2]int64{}
+		for peerID := int64(1); peerID <= 8; peerID++ {
+			edges = append(edges, [2]int64{0, peerID})
+		}
+		sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 9, func(rng exprand.Source) pubsub.Router {
+			return NewRouter(cfg, rng)
+		}, edges, false)
+		lowScore := map[int64]bool{}
+		routers[0].(*Router).SetAppScore(func(peerID int64) float64 {
+			if lowScore[peerID] {
+				return 0
+			}
+			return 5
+		})
+		pubsubtest.StartNodes(t, sched, nodes)
+		mesh := routers[0].(*Router).mesh[pubsub.DefaultTopic]
+		mesh.Traverse(func(iNeighborID interface{}) {
+			lowScore[iNeighborID.(int64)] = true
+		})
+
+		// the peers pruned from the full mesh can be grafted once the backoff expires
+		sched.RunFor(*cfg.PruneBackoff + 2**cfg.HeartbeatInterval)
+		numHigh := 0
+		mesh.Traverse(func(iNeighborID interface{}) {
+			if !lowScore[iNeighborID.(int64)] {
+				numHigh++
+			}
+		})
+		if opportunisticGraft && numHigh < *cfg.OpportunisticGraftPeers {
+			t.Errorf("Grafted %v peers opportunistically, expected %v", numHigh, *cfg.OpportunisticGraftPeers)
+		}
+		if !opportunisticGraft && numHigh != 0 {
+			t.Errorf("Grafted %v peers with a high score without opportunistic grafting", numHigh)
+		}
+	}
+}
+
+// line from node 0 to node 2
+func TestIDontWant(t *testing.T) {
+	idontwant := true
+	cfg := GetDefaultConfig()
+	cfg.DoIDontWant = &idontwant
+	sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 3, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, [][2]int64{{0, 1}, {1, 2}}, true)
+
+	// node 1 announces the large message to node 2 but not the small one
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	nodes[0].Publish(pubsub.DefaultTopic, *cfg.IDontWantThreshold)
+	sched.RunFor(100 * time.Millisecond)
+	dontWant := routers[2].(*Router).dontWant[1]
+	if _, exists := dontWant[pubsub.MsgID{From: 0, Seqno: 1}]; !exists || len(dontWant) != 1 {
+		t.Errorf("Unexpected IDONTWANT %v from node 1", dontWant)
+	}
+	if len(routers[1].(*Router).dontWant) != 0 {
+		t.Errorf("Unexpected IDONTWANT %v to node 1", routers[1].(*Router).dontWant)
+	}
+
+	// the declarations are forgotten with the message cache
+	sched.RunFor(time.Duration(*cfg.HistoryLength+1) * *cfg.HeartbeatInterval)
+	if len(routers[2].(*Router).dontWant) != 0 {
+		t.Errorf("Did not forget IDONTWANT %v", routers[2].(*Router).dontWant)
+	}
+
+	// the message is not forwarded to the peer that does not want it
+	msgID := pubsub.MsgID{From: 0, Seqno: 3}
+	routers[1].(*Router).dontWant[2] = map[pubsub.MsgID]time.Time{msgID: sched.CurTime}
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	sched.RunFor(100 * time.Millisecond)
+	if !nodes[1].SeenMsgs.SeenMsg(msgID) || nodes[2].SeenMsgs.SeenMsg(msgID) {
+		t.Errorf("Forwarded the message to the peer that declared IDONTWANT")
+	}
+}
@@ -1,6 +1,8 @@
 package gossipsub
 
 import (
+	"time"
+
 	"github.com/marlinprotocol/p2psim/core"
 	"github.com/marlinprotocol/p2psim/pubsub"
 )
@@ -11,14 +13,17 @@ type RPCMsg struct {
 	control *ControlMessage
 }
 
+// IHAVE, GRAFT and PRUNE messages refer to a topic and hence can be sent for several topics at once
 type ControlMessage struct {
-	ihave *IHave
-	iwant *IWant
-	graft *Graft
-	prune *Prune
+	ihave     []*IHave
+	iwant     *IWant
+	graft     []*Graft
+	prune     []*Prune
+	idontwant *IDontWant
 }
 
 type IHave struct {
+	topic string
 	// Set of MsgID
 	msgIDs *core.Set
 }
@@ -28,9 +33,23 @@ type IWant struct {
 	msgIDs *core.Set
 }
 
-type Graft struct{}
+// Messages the sender already received and does not want forwarded to it (v1.2)
+type IDontWant struct {
+	// Set of MsgID
+	msgIDs *core.Set
+}
 
-type Prune struct{}
+type Graft struct {
+	topic string
+}
+
+// Carries the backoff before the pruned peer may graft again
+//   and optionally the peers of the topic the pruned peer may connect to (PX)
+type Prune struct {
+	topic   string
+	peers   []int64
+	backoff time.Duration
+}
 
 func NewDataMsg(msg pubsub.Message) *RPCMsg {
 	return &RPCMsg{
@@ -40,23 +59,24 @@ func NewDataMsg(msg pubsub.Message) *RPCMsg {
 	}
 }
 
-func NewControlMsg(msgs []pubsub.Message, ihave *IHave, iwant *IWant, graft *Graft, prune *Prune) *RPCMsg {
+func NewControlMsg(msgs []pubsub.Message, ihave []*IHave, iwant *IWant, graft []*Graft, prune []*Prune) *RPCMsg {
 	// compute size
 	size := int64(0)
 	for _, msg := range msgs {
 		size += msg.GetSize()
 	}
-	if ihave != nil {
-		size += int64(ihave.msgIDs.Len()) * 8
+	for _, topicIHave := range ihave {
+		size += int64(len(topicIHave.topic)) + int64(topicIHave.msgIDs.Len())*8
 	}
 	if iwant != nil {
 		size += int64(iwant.msgIDs.Len()) * 8
 	}
-	if graft != nil {
-		size++
+	for _, topicGraft := range graft {
+		size += int64(len(topicGraft.topic)) + 1
 	}
-	if prune != nil {
-		size++
+	for _, topicPrune := range prune {
+		// the backoff and the peer IDs take 8 bytes each (signed peer records are not simulated)
+		size += int64(len(topicPrune.topic)) + 1 + 8 + int64(len(topicPrune.peers))*8
 	}
 
 	control := &ControlMessage{
@@ -72,6 +92,16 @@ func NewControlMsg(msgs []pubsub.Message, ihave *IHave, iwant *IWant, graft *Gra
 	}
 }
 
+func NewIDontWantMsg(msgIDs *core.Set) *RPCMsg {
+	return &RPCMsg{
+		size: int64(msgIDs.Len()) * 8,
+		msgs: []pubsub.Message{},
+		control: &ControlMessage{
+			idontwant: &IDontWant{msgIDs: msgIDs},
+		},
+	}
+}
+
 func (rpcMsg *RPCMsg) GetSize() int64 {
 	return rpcMsg.size
 }
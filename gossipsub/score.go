@@ -0,0 +1,669 @@
+package gossipsub
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"go.uber.org/zap"
+)
+
+// Peer scoring from gossipsub v1.1
+// See https://github.com/libp2p/specs/blob/master/pubsub/gossipsub/gossipsub-v1.1.md#peer-scoring
+//
+// Score(p) = TopicCap(Σ_t TopicWeight(t) * (w1 P1 + w2 P2 + w3 P3 + w3b P3b + w4 P4)) + w5 P5 + w6 P6 + w7 P7
+// - P1: time in the mesh of the topic, in quanta and capped
+// - P2: first deliveries of messages of the topic, decayed and capped
+// - P3: square of the deficit of the mesh deliveries below the threshold, once active after grafting
+//   mesh deliveries count the first deliveries and the duplicates arriving within the window
+// - P3b: sticky penalty of the mesh delivery deficit on pruning the peer, decayed
+// - P4: square of the invalid messages of the topic, decayed
+//   all the simulated messages are valid so far
+// - P5: application specific score
+// - P6: square of the peers sharing the IP of the peer in excess of the threshold
+// - P7: square of the behaviour penalty in excess of the threshold, decayed
+//   the penalty is increased on broken IWANT promises (message not delivered within iwant_followup_time)
+// Topics without parameters do not contribute to the score
+//
+// The counters decay every decay_interval and are zeroed below decay_to_zero
+// The score of a disconnected peer is retained for retain_score so that reconnecting does not reset it
+//
+// Thresholds (all non-positive, graylist <= publish <= gossip <= 0)
+// - gossip: no gossip is emitted to or accepted from peers below the threshold
+// - publish: messages published by the local node are not sent to peers below the threshold
+// - graylist: all RPCs from peers below the threshold are ignored
+// - accept PX (non-negative): peers exchanged by peers below the threshold are not connected to
+// - opportunistic graft (non-negative): peers are grafted opportunistically while the median score of the mesh is below
+// Peers with a negative score are pruned from the mesh and not grafted
+
+var (
+	InvScoreErr = errors.New("Configured the peer score incorrectly!")
+)
+
+var (
+	// Default config params
+	// All weights are zero by default (disabling the corresponding component)
+	TopicWeight                      = 1.0
+	TimeInMeshQuantum                = 1 * time.Second
+	TimeInMeshCap                    = 3600.0
+	FirstMessageDeliveriesDecay      = 0.5
+	FirstMessageDeliveriesCap        = 100.0
+	MeshMessageDeliveriesDecay       = 0.5
+	MeshMessageDeliveriesCap         = 100.0
+	MeshMessageDeliveriesThreshold   = 1.0
+	MeshMessageDeliveriesWindow      = 10 * time.Millisecond
+	MeshMessageDeliveriesActivation  = 5 * time.Second
+	MeshFailurePenaltyDecay          = 0.5
+	InvalidMessageDeliveriesDecay    = 0.5
+	IPColocationFactorThreshold      = 1
+	BehaviourPenaltyDecay            = 0.5
+	IWantFollowupTime                = 3 * time.Second
+	DecayInterval                    = 1 * time.Second
+	DecayToZero                      = 0.01
+	RetainScore                      = 10 * time.Minute
+	GossipThreshold                  = -10.0
+	PublishThreshold                 = -50.0
+	GraylistThreshold                = -80.0
+	AcceptPXThreshold                = 0.0
+	OpportunisticGraftThreshold      = 1.0
+	defaultTopicScoreCap             = 0.0
+	defaultWeight                    = 0.0
+	defaultBehaviourPenaltyThreshold = 0.0
+)
+
+type ScoreConfig struct {
+	// topic -> parameters of the topic
+	Topics map[string]*TopicScoreConfig `toml:"topics,omitempty"`
+
+	// Upper bound on the contribution of the topics, zero for no cap
+	TopicScoreCap *float64 `toml:"topic_score_cap,omitempty"`
+
+	// P5
+	AppSpecificWeight *float64 `toml:"app_specific_weight,omitempty"`
+
+	// P6
+	IPColocationFactorWeight    *float64 `toml:"ip_colocation_factor_weight,omitempty"`
+	IPColocationFactorThreshold *int     `toml:"ip_colocation_factor_threshold,omitempty"`
+
+	// P7
+	BehaviourPenaltyWeight    *float64       `toml:"behaviour_penalty_weight,omitempty"`
+	BehaviourPenaltyThreshold *float64       `toml:"behaviour_penalty_threshold,omitempty"`
+	BehaviourPenaltyDecay     *float64       `toml:"behaviour_penalty_decay,omitempty"`
+	IWantFollowupTime         *time.Duration `toml:"iwant_followup_time,omitempty"`
+
+	DecayInterval *time.Duration `toml:"decay_interval,omitempty"`
+	DecayToZero   *float64       `toml:"decay_to_zero,omitempty"`
+	RetainScore   *time.Duration `toml:"retain_score,omitempty"`
+
+	GossipThreshold   *float64 `toml:"gossip_threshold,omitempty"`
+	PublishThreshold  *float64 `toml:"publish_threshold,omitempty"`
+	GraylistThreshold *float64 `toml:"graylist_threshold,omitempty"`
+
+	// Peers exchanged on pruning are connected to only if the pruning peer has at least this score
+	AcceptPXThreshold *float64 `toml:"accept_px_threshold,omitempty"`
+
+	// Peers are grafted opportunistically when the median score of the mesh is below this score
+	OpportunisticGraftThreshold *float64 `toml:"opportunistic_graft_threshold,omitempty"`
+}
+
+type TopicScoreConfig struct {
+	TopicWeight *float64 `toml:"topic_weight,omitempty"`
+
+	// P1
+	TimeInMeshWeight  *float64       `toml:"time_in_mesh_weight,omitempty"`
+	TimeInMeshQuantum *time.Duration `toml:"time_in_mesh_quantum,omitempty"`
+	TimeInMeshCap     *float64       `toml:"time_in_mesh_cap,omitempty"`
+
+	// P2
+	FirstMessageDeliveriesWeight *float64 `toml:"first_message_deliveries_weight,omitempty"`
+	FirstMessageDeliveriesDecay  *float64 `toml:"first_message_deliveries_decay,omitempty"`
+	FirstMessageDeliveriesCap    *float64 `toml:"first_message_deliveries_cap,omitempty"`
+
+	// P3
+	MeshMessageDeliveriesWeight     *float64       `toml:"mesh_message_deliveries_weight,omitempty"`
+	MeshMessageDeliveriesDecay      *float64       `toml:"mesh_message_deliveries_decay,omitempty"`
+	MeshMessageDeliveriesCap        *float64       `toml:"mesh_message_deliveries_cap,omitempty"`
+	MeshMessageDeliveriesThreshold  *float64       `toml:"mesh_message_deliveries_threshold,omitempty"`
+	MeshMessageDeliveriesWindow     *time.Duration `toml:"mesh_message_deliveries_window,omitempty"`
+	MeshMessageDeliveriesActivation *time.Duration `toml:"mesh_message_deliveries_activation,omitempty"`
+
+	// P3b
+	MeshFailurePenaltyWeight *float64 `toml:"mesh_failure_penalty_weight,omitempty"`
+	MeshFailurePenaltyDecay  *float64 `toml:"mesh_failure_penalty_decay,omitempty"`
+
+	// P4
+	InvalidMessageDeliveriesWeight *float64 `toml:"invalid_message_deliveries_weight,omitempty"`
+	InvalidMessageDeliveriesDecay  *float64 `toml:"invalid_message_deliveries_decay,omitempty"`
+}
+
+// Config with the defaults filled in
+type scoreParams struct {
+	topics map[string]*topicScoreParams
+	// topics with parameters in a fixed order since floating point sums depend on the order of addition
+	topicNames []string
+
+	topicScoreCap               float64
+	appSpecificWeight           float64
+	ipColocationFactorWeight    float64
+	ipColocationFactorThreshold int
+	behaviourPenaltyWeight      float64
+	behaviourPenaltyThreshold   float64
+	behaviourPenaltyDecay       float64
+	iwantFollowupTime           time.Duration
+	decayInterval               time.Duration
+	decayToZero                 float64
+	retainScore                 time.Duration
+	gossipThreshold             float64
+	publishThreshold            float64
+	graylistThreshold           float64
+	acceptPXThreshold           float64
+	opportunisticGraftThreshold float64
+}
+
+type topicScoreParams struct {
+	topicWeight                     float64
+	timeInMeshWeight                float64
+	timeInMeshQuantum               time.Duration
+	timeInMeshCap                   float64
+	firstMessageDeliveriesWeight    float64
+	firstMessageDeliveriesDecay     float64
+	firstMessageDeliveriesCap       float64
+	meshMessageDeliveriesWeight     float64
+	meshMessageDeliveriesDecay      float64
+	meshMessageDeliveriesCap        float64
+	meshMessageDeliveriesThreshold  float64
+	meshMessageDeliveriesWindow     time.Duration
+	meshMessageDeliveriesActivation time.Duration
+	meshFailurePenaltyWeight        float64
+	meshFailurePenaltyDecay         float64
+	invalidMessageDeliveriesWeight  float64
+	invalidMessageDeliveriesDecay   float64
+}
+
+// Tracks the scores of the peers of the local node
+type peerScore struct {
+	cfg *ScoreConfig
+
+	// initialized while starting the router
+	params *scoreParams
+	node   *pubsub.Node
+
+	// peer ID -> counters of the peer
+	peerStats map[int64]*peerStats
+
+	// MsgID -> first delivery of the message, retained for the longest delivery window
+	deliveries map[pubsub.MsgID]*deliveryRecord
+
+	// MsgID -> peer ID -> deadline for delivering the message requested using IWANT
+	promises map[pubsub.MsgID]map[int64]time.Time
+
+	// peer ID -> IP of the peer, peers without an IP are assumed to have a unique IP
+	peerIPs map[int64]string
+
+	// P5, zero if unspecified
+	appScore func(peerID int64) float64
+
+	// triggers the decay, stopped on leaving the network
+	ticker *core.Ticker
+}
+
+type peerStats struct {
+	connected bool
+	// the stats are forgotten after the expiry once disconnected
+	expiry time.Time
+
+	// topic -> counters of the topic
+	topics map[string]*topicStats
+
+	behaviourPenalty float64
+}
+
+type topicStats struct {
+	inMesh    bool
+	graftTime time.Time
+
+	firstMessageDeliveries   float64
+	meshMessageDeliveries    float64
+	meshFailurePenalty       float64
+	invalidMessageDeliveries float64
+}
+
+type deliveryRecord struct {
+	firstTime time.Time
+	// peers that delivered the message
+	peerIDs *core.Set
+}
+
+func newScoreParams(cfg *ScoreConfig) (*scoreParams, error) {
+	params := &scoreParams{
+		topics:                      map[string]*topicScoreParams{},
+		topicNames:                  []string{},
+		topicScoreCap:               getFloat(cfg.TopicScoreCap, defaultTopicScoreCap),
+		appSpecificWeight:           getFloat(cfg.AppSpecificWeight, defaultWeight),
+		ipColocationFactorWeight:    getFloat(cfg.IPColocationFactorWeight, defaultWeight),
+		ipColocationFactorThreshold: IPColocationFactorThreshold,
+		behaviourPenaltyWeight:      getFloat(cfg.BehaviourPenaltyWeight, defaultWeight),
+		behaviourPenaltyThreshold:   getFloat(cfg.BehaviourPenaltyThreshold, defaultBehaviourPenaltyThreshold),
+		behaviourPenaltyDecay:       getFloat(cfg.BehaviourPenaltyDecay, BehaviourPenaltyDecay),
+		iwantFollowupTime:           getDuration(cfg.IWantFollowupTime, IWantFollowupTime),
+		decayInterval:               getDuration(cfg.DecayInterval, DecayInterval),
+		decayToZero:                 getFloat(cfg.DecayToZero, DecayToZero),
+		retainScore:                 getDuration(cfg.RetainScore, RetainScore),
+		gossipThreshold:             getFloat(cfg.GossipThreshold, GossipThreshold),
+		publishThreshold:            getFloat(cfg.PublishThreshold, PublishThreshold),
+		graylistThreshold:           getFloat(cfg.GraylistThreshold, GraylistThreshold),
+		acceptPXThreshold:           getFloat(cfg.AcceptPXThreshold, AcceptPXThreshold),
+		opportunisticGraftThreshold: getFloat(cfg.OpportunisticGraftThreshold, OpportunisticGraftThreshold),
+	}
+	if cfg.IPColocationFactorThreshold != nil {
+		params.ipColocationFactorThreshold = *cfg.IPColocationFactorThreshold
+	}
+
+	switch {
+	case params.topicScoreCap < 0:
+		return nil, fmt.Errorf("%w: topic_score_cap must not be negative", InvScoreErr)
+	case params.ipColocationFactorWeight > 0 || params.behaviourPenaltyWeight > 0:
+		return nil, fmt.Errorf("%w: penalty weights must not be positive", InvScoreErr)
+	case params.ipColocationFactorThreshold < 1:
+		return nil, fmt.Errorf("%w: ip_colocation_factor_threshold must be at least 1", InvScoreErr)
+	case params.behaviourPenaltyThreshold < 0:
+		return nil, fmt.Errorf("%w: behaviour_penalty_threshold must not be negative", InvScoreErr)
+	case !isDecay(params.behaviourPenaltyDecay):
+		return nil, fmt.Errorf("%w: decays must lie between 0 and 1", InvScoreErr)
+	case params.iwantFollowupTime <= 0 || params.decayInterval <= 0 || params.retainScore < 0:
+		return nil, fmt.Errorf("%w: intervals must be positive", InvScoreErr)
+	case !isDecay(params.decayToZero):
+		return nil, fmt.Errorf("%w: decay_to_zero must lie between 0 and 1", InvScoreErr)
+	case !(params.graylistThreshold <= params.publishThreshold &&
+		params.publishThreshold <= params.gossipThreshold &&
+		params.gossipThreshold <= 0):
+		return nil, fmt.Errorf("%w: require graylist_threshold <= publish_threshold <= gossip_threshold <= 0", InvScoreErr)
+	case params.acceptPXThreshold < 0 || params.opportunisticGraftThreshold < 0:
+		return nil, fmt.Errorf("%w: accept_px_threshold and opportunistic_graft_threshold must not be negative", InvScoreErr)
+	}
+
+	for topic, topicCfg := range cfg.Topics {
+		topicParams, err := newTopicScoreParams(topicCfg)
+		if err != nil {
+			return nil, fmt.Errorf("topic %v: %w", topic, err)
+		}
+		params.topics[topic] = topicParams
+		params.topicNames = append(params.topicNames, topic)
+	}
+	sort.Strings(params.topicNames)
+	return params, nil
+}
+
+func newTopicScoreParams(cfg *TopicScoreConfig) (*topicScoreParams, error) {
+	params := &topicScoreParams{
+		topicWeight:                     getFloat(cfg.TopicWeight, TopicWeight),
+		timeInMeshWeight:                getFloat(cfg.TimeInMeshWeight, defaultWeight),
+		timeInMeshQuantum:               getDuration(cfg.TimeInMeshQuantum, TimeInMeshQuantum),
+		timeInMeshCap:                   getFloat(cfg.TimeInMeshCap, TimeInMeshCap),
+		firstMessageDeliveriesWeight:    getFloat(cfg.FirstMessageDeliveriesWeight, defaultWeight),
+		firstMessageDeliveriesDecay:     getFloat(cfg.FirstMessageDeliveriesDecay, FirstMessageDeliveriesDecay),
+		firstMessageDeliveriesCap:       getFloat(cfg.FirstMessageDeliveriesCap, FirstMessageDeliveriesCap),
+		meshMessageDeliveriesWeight:     getFloat(cfg.MeshMessageDeliveriesWeight, defaultWeight),
+		meshMessageDeliveriesDecay:      getFloat(cfg.MeshMessageDeliveriesDecay, MeshMessageDeliveriesDecay),
+		meshMessageDeliveriesCap:        getFloat(cfg.MeshMessageDeliveriesCap, MeshMessageDeliveriesCap),
+		meshMessageDeliveriesThreshold:  getFloat(cfg.MeshMessageDeliveriesThreshold, MeshMessageDeliveriesThreshold),
+		meshMessageDeliveriesWindow:     getDuration(cfg.MeshMessageDeliveriesWindow, MeshMessageDeliveriesWindow),
+		meshMessageDeliveriesActivation: getDuration(cfg.MeshMessageDeliveriesActivation, MeshMessageDeliveriesActivation),
+		meshFailurePenaltyWeight:        getFloat(cfg.MeshFailurePenaltyWeight, defaultWeight),
+		meshFailurePenaltyDecay:         getFloat(cfg.MeshFailurePenaltyDecay, MeshFailurePenaltyDecay),
+		invalidMessageDeliveriesWeight:  getFloat(cfg.InvalidMessageDeliveriesWeight, defaultWeight),
+		invalidMessageDeliveriesDecay:   getFloat(cfg.InvalidMessageDeliveriesDecay, InvalidMessageDeliveriesDecay),
+	}
+
+	switch {
+	case params.topicWeight < 0:
+		return nil, fmt.Errorf("%w: topic_weight must not be negative", InvScoreErr)
+	case params.timeInMeshWeight < 0 || params.firstMessageDeliveriesWeight < 0:
+		return nil, fmt.Errorf("%w: reward weights must not be negative", InvScoreErr)
+	case params.meshMessageDeliveriesWeight > 0 ||
+		params.meshFailurePenaltyWeight > 0 ||
+		params.invalidMessageDeliveriesWeight > 0:
+		return nil, fmt.Errorf("%w: penalty weights must not be positive", InvScoreErr)
+	case params.timeInMeshQuantum <= 0:
+		return nil, fmt.Errorf("%w: time_in_mesh_quantum must be positive", InvScoreErr)
+	case params.timeInMeshCap <= 0 || params.firstMessageDeliveriesCap <= 0 || params.meshMessageDeliveriesCap <= 0:
+		return nil, fmt.Errorf("%w: caps must be positive", InvScoreErr)
+	case params.meshMessageDeliveriesThreshold <= 0 ||
+		params.meshMessageDeliveriesThreshold > params.meshMessageDeliveriesCap:
+		return nil, fmt.Errorf("%w: require 0 < mesh_message_deliveries_threshold <= cap", InvScoreErr)
+	case params.meshMessageDeliveriesWindow < 0 || params.meshMessageDeliveriesActivation < 0:
+		return nil, fmt.Errorf("%w: mesh delivery window and activation must not be negative", InvScoreErr)
+	case !isDecay(params.firstMessageDeliveriesDecay) ||
+		!isDecay(params.meshMessageDeliveriesDecay) ||
+		!isDecay(params.meshFailurePenaltyDecay) ||
+		!isDecay(params.invalidMessageDeliveriesDecay):
+		return nil, fmt.Errorf("%w: decays must lie between 0 and 1", InvScoreErr)
+	}
+	return params, nil
+}
+
+func newPeerScore(cfg *ScoreConfig) *peerScore {
+	return &peerScore{
+		cfg:        cfg,
+		params:     nil,
+		node:       nil,
+		peerStats:  map[int64]*peerStats{},
+		deliveries: map[pubsub.MsgID]*deliveryRecord{},
+		promises:   map[pubsub.MsgID]map[int64]time.Time{},
+		peerIPs:    map[int64]string{},
+		appScore:   nil,
+	}
+}
+
+// Peers connected before starting are tracked right away
+func (score *peerScore) Start(node *pubsub.Node, logger *zap.Logger) error {
+	var err error
+
+	score.params, err = newScoreParams(score.cfg)
+	if err != nil {
+		return err
+	}
+	score.node = node
+
+	node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
+		score.AddPeer(iNeighborID.(int64))
+	})
+
+	// Start timer for decaying the counters
+	score.ticker, err = core.StartTicker(node.Sched, score.params.decayInterval, core.DefaultPriority, score, logger)
+	return err
+}
+
+func (score *peerScore) Stop() {
+	score.ticker.Stop()
+}
+
+func (score *peerScore) Score(peerID int64) float64 {
+	stats, exists := score.peerStats[peerID]
+	if !exists {
+		return 0
+	}
+	now := score.node.Sched.CurTime
+
+	topicScore := 0.0
+	for _, topic := range score.params.topicNames {
+		tstats, exists := stats.topics[topic]
+		if !exists {
+			continue
+		}
+		params := score.params.topics[topic]
+
+		// P1
+		p1 := 0.0
+		if tstats.inMesh {
+			p1 = math.Min(float64(now.Sub(tstats.graftTime))/float64(params.timeInMeshQuantum), params.timeInMeshCap)
+		}
+		// P2
+		p2 := tstats.firstMessageDeliveries
+		// P3
+		p3 := 0.0
+		if tstats.inMesh && !now.Before(tstats.graftTime.Add(params.meshMessageDeliveriesActivation)) &&
+			tstats.meshMessageDeliveries < params.meshMessageDeliveriesThreshold {
+			deficit := params.meshMessageDeliveriesThreshold - tstats.meshMessageDeliveries
+			p3 = deficit * deficit
+		}
+		// P3b
+		p3b := tstats.meshFailurePenalty
+		// P4
+		p4 := tstats.invalidMessageDeliveries * tstats.invalidMessageDeliveries
+
+		topicScore += params.topicWeight * (params.timeInMeshWeight*p1 +
+			params.firstMessageDeliveriesWeight*p2 +
+			params.meshMessageDeliveriesWeight*p3 +
+			params.meshFailurePenaltyWeight*p3b +
+			params.invalidMessageDeliveriesWeight*p4)
+	}
+	if score.params.topicScoreCap > 0 {
+		topicScore = math.Min(topicScore, score.params.topicScoreCap)
+	}
+
+	// P5
+	p5 := 0.0
+	if score.appScore != nil {
+		p5 = score.appScore(peerID)
+	}
+	// P6
+	p6 := 0.0
+	if ip, exists := score.peerIPs[peerID]; exists {
+		excess := score.countIP(ip) - score.params.ipColocationFactorThreshold
+		if excess > 0 {
+			p6 = float64(excess * excess)
+		}
+	}
+	// P7
+	p7 := 0.0
+	if excess := stats.behaviourPenalty - score.params.behaviourPenaltyThreshold; excess > 0 {
+		p7 = excess * excess
+	}
+
+	return topicScore +
+		score.params.appSpecificWeight*p5 +
+		score.params.ipColocationFactorWeight*p6 +
+		score.params.behaviourPenaltyWeight*p7
+}
+
+// Number of connected peers sharing the IP
+func (score *peerScore) countIP(ip string) int {
+	count := 0
+	for peerID, peerIP := range score.peerIPs {
+		if stats, exists := score.peerStats[peerID]; exists && stats.connected && peerIP == ip {
+			count++
+		}
+	}
+	return count
+}
+
+// The retained stats are restored on reconnecting
+func (score *peerScore) AddPeer(peerID int64) {
+	stats, exists := score.peerStats[peerID]
+	if !exists {
+		stats = &peerStats{
+			topics: map[string]*topicStats{},
+		}
+		score.peerStats[peerID] = stats
+	}
+	stats.connected = true
+}
+
+func (score *peerScore) RemovePeer(peerID int64) {
+	stats, exists := score.peerStats[peerID]
+	if !exists {
+		return
+	}
+	stats.connected = false
+	stats.expiry = score.node.Sched.CurTime.Add(score.params.retainScore)
+}
+
+func (score *peerScore) Graft(peerID int64, topic string) {
+	tstats := score.getTopicStats(peerID, topic)
+	if tstats == nil {
+		return
+	}
+	tstats.inMesh = true
+	tstats.graftTime = score.node.Sched.CurTime
+	tstats.meshMessageDeliveries = 0
+}
+
+// The mesh delivery deficit sticks as the mesh failure penalty
+func (score *peerScore) Prune(peerID int64, topic string) {
+	tstats := score.getTopicStats(peerID, topic)
+	if tstats == nil || !tstats.inMesh {
+		return
+	}
+	params := score.params.topics[topic]
+	if !score.node.Sched.CurTime.Before(tstats.graftTime.Add(params.meshMessageDeliveriesActivation)) &&
+		tstats.meshMessageDeliveries < params.meshMessageDeliveriesThreshold {
+		deficit := params.meshMessageDeliveriesThreshold - tstats.meshMessageDeliveries
+		tstats.meshFailurePenalty += deficit * deficit
+	}
+	tstats.inMesh = false
+}
+
+// Called on the first delivery of the message
+func (score *peerScore) DeliverMessage(peerID int64, msg pubsub.Message) {
+	msgID := pubsub.MsgID{
+		From:  msg.From(),
+		Seqno: msg.Seqno(),
+	}
+	score.deliveries[msgID] = &deliveryRecord{
+		firstTime: score.node.Sched.CurTime,
+		peerIDs:   core.NewSet(peerID),
+	}
+	// the promises are kept by delivering the message from any peer
+	delete(score.promises, msgID)
+
+	tstats := score.getTopicStats(peerID, msg.Topic())
+	if tstats == nil {
+		return
+	}
+	params := score.params.topics[msg.Topic()]
+	tstats.firstMessageDeliveries = math.Min(tstats.firstMessageDeliveries+1, params.firstMessageDeliveriesCap)
+	if tstats.inMesh {
+		tstats.meshMessageDeliveries = math.Min(tstats.meshMessageDeliveries+1, params.meshMessageDeliveriesCap)
+	}
+}
+
+// Called on every delivery of the message after the first delivery
+// Duplicates from the mesh peers within the window count as mesh deliveries
+func (score *peerScore) DuplicateMessage(peerID int64, msg pubsub.Message) {
+	msgID := pubsub.MsgID{
+		From:  msg.From(),
+		Seqno: msg.Seqno(),
+	}
+	record, exists := score.deliveries[msgID]
+	if !exists || record.peerIDs.Exists(peerID) {
+		return
+	}
+	record.peerIDs.Add(peerID)
+
+	tstats := score.getTopicStats(peerID, msg.Topic())
+	if tstats == nil || !tstats.inMesh {
+		return
+	}
+	params := score.params.topics[msg.Topic()]
+	if score.node.Sched.CurTime.After(record.firstTime.Add(params.meshMessageDeliveriesWindow)) {
+		return
+	}
+	tstats.meshMessageDeliveries = math.Min(tstats.meshMessageDeliveries+1, params.meshMessageDeliveriesCap)
+}
+
+// Called after requesting the messages using IWANT
+// Only the first message is tracked as in libp2p
+func (score *peerScore) AddPromise(peerID int64, msgIDs *core.Set) {
+	if msgIDs.Len() == 0 {
+		return
+	}
+	msgID := msgIDs.Flatten()[0].(pubsub.MsgID)
+	if _, exists := score.promises[msgID]; !exists {
+		score.promises[msgID] = map[int64]time.Time{}
+	}
+	if _, exists := score.promises[msgID][peerID]; !exists {
+		score.promises[msgID][peerID] = score.node.Sched.CurTime.Add(score.params.iwantFollowupTime)
+	}
+}
+
+func (score *peerScore) AddPenalty(peerID int64, count int) {
+	if stats, exists := score.peerStats[peerID]; exists {
+		stats.behaviourPenalty += float64(count)
+	}
+}
+
+// Counters decay, the broken promises are penalized and the expired stats are forgotten
+func (score *peerScore) HandleTick() {
+	now := score.node.Sched.CurTime
+	for msgID, deadlines := range score.promises {
+		for peerID, deadline := range deadlines {
+			if deadline.Before(now) {
+				score.AddPenalty(peerID, 1)
+				delete(deadlines, peerID)
+			}
+		}
+		if len(deadlines) == 0 {
+			delete(score.promises, msgID)
+		}
+	}
+
+	maxWindow := time.Duration(0)
+	for _, params := range score.params.topics {
+		if params.meshMessageDeliveriesWindow > maxWindow {
+			maxWindow = params.meshMessageDeliveriesWindow
+		}
+	}
+	for msgID, record := range score.deliveries {
+		if record.firstTime.Add(maxWindow).Before(now) {
+			delete(score.deliveries, msgID)
+		}
+	}
+
+	for peerID, stats := range score.peerStats {
+		if !stats.connected && stats.expiry.Before(now) {
+			delete(score.peerStats, peerID)
+			continue
+		}
+		for topic, tstats := range stats.topics {
+			params := score.params.topics[topic]
+			tstats.firstMessageDeliveries = score.decay(tstats.firstMessageDeliveries, params.firstMessageDeliveriesDecay)
+			tstats.meshMessageDeliveries = score.decay(tstats.meshMessageDeliveries, params.meshMessageDeliveriesDecay)
+			tstats.meshFailurePenalty = score.decay(tstats.meshFailurePenalty, params.meshFailurePenaltyDecay)
+			tstats.invalidMessageDeliveries = score.decay(
+				tstats.invalidMessageDeliveries,
+				params.invalidMessageDeliveriesDecay,
+			)
+		}
+		stats.behaviourPenalty = score.decay(stats.behaviourPenalty, score.params.behaviourPenaltyDecay)
+	}
+}
+
+func (score *peerScore) ID() int64 {
+	return score.node.ID()
+}
+
+func (score *peerScore) decay(value float64, decay float64) float64 {
+	value *= decay
+	if value < score.params.decayToZero {
+		return 0
+	}
+	return value
+}
+
+// nil for the topics without parameters and the unknown peers
+func (score *peerScore) getTopicStats(peerID int64, topic string) *topicStats {
+	if _, exists := score.params.topics[topic]; !exists {
+		return nil
+	}
+	stats, exists := score.peerStats[peerID]
+	if !exists {
+		return nil
+	}
+	if _, exists := stats.topics[topic]; !exists {
+		stats.topics[topic] = &topicStats{}
+	}
+	return stats.topics[topic]
+}
+
+func isDecay(decay float64) bool {
+	return 0 < decay && decay < 1
+}
+
+func getFloat(param *float64, defaultValue float64) float64 {
+	if param == nil {
+		return defaultValue
+	}
+	return *param
+}
+
+func getDuration(param *time.Duration, defaultValue time.Duration) time.Duration {
+	if param == nil {
+		return defaultValue
+	}
+	return *param
+}
@@ -0,0 +1,144 @@
+package gossipsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"github.com/marlinprotocol/p2psim/pubsub/pubsubtest"
+	exprand "golang.org/x/exp/rand"
+)
+
+func TestInvScore(t *testing.T) {
+	negative := -1.0
+	positive := 1.0
+	zero := 0.0
+	duration := time.Duration(0)
+	cfgs := []*ScoreConfig{
+		{GossipThreshold: &positive},
+		{GossipThreshold: &negative, PublishThreshold: &zero},
+		{BehaviourPenaltyWeight: &positive},
+		{BehaviourPenaltyDecay: &positive},
+		{DecayInterval: &duration},
+		{Topics: map[string]*TopicScoreConfig{pubsub.DefaultTopic: {TopicWeight: &negative}}},
+		{Topics: map[string]*TopicScoreConfig{pubsub.DefaultTopic: {TimeInMeshWeight: &negative}}},
+		{Topics: map[string]*TopicScoreConfig{pubsub.DefaultTopic: {MeshMessageDeliveriesWeight: &positive}}},
+		{Topics: map[string]*TopicScoreConfig{pubsub.DefaultTopic: {FirstMessageDeliveriesDecay: &zero}}},
+		{Topics: map[string]*TopicScoreConfig{pubsub.DefaultTopic: {MeshMessageDeliveriesThreshold: &zero}}},
+	}
+	invRouters := []pubsubtest.InvRouter{}
+	for _, scoreCfg := range cfgs {
+		cfg := GetDefaultConfig()
+		cfg.Score = scoreCfg
+		invRouters = append(invRouters, pubsubtest.InvRouter{Router: NewRouter(cfg, exprand.NewSource(55)), Err: InvScoreErr})
+	}
+	pubsubtest.CheckInvRouters(t, invRouters)
+}
+
+// star around node 0 where only node 1 publishes
+func TestTopicScore(t *testing.T) {
+	timeInMeshWeight := 0.1
+	deliveriesWeight := 1.0
+	deliveriesDecay := 0.9
+	penaltyWeight := -1.0
+	activation := 2 * time.Second
+	cfg := GetDefaultConfig()
+	cfg.Score = &ScoreConfig{
+		Topics: map[string]*TopicScoreConfig{
+			pubsub.DefaultTopic: {
+				TimeInMeshWeight:                &timeInMeshWeight,
+				FirstMessageDeliveriesWeight:    &deliveriesWeight,
+				FirstMessageDeliveriesDecay:     &deliveriesDecay,
+				MeshMessageDeliveriesWeight:     &penaltyWeight,
+				MeshMessageDeliveriesDecay:      &deliveriesDecay,
+				MeshMessageDeliveriesActivation: &activation,
+				MeshFailurePenaltyWeight:        &penaltyWeight,
+			},
+		},
+	}
+	sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 4, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, [][2]int64{{0, 1}, {0, 2}, {0, 3}}, true)
+	mesh := routers[0].(*Router).mesh[pubsub.DefaultTopic]
+
+	nodes[1].Publish(pubsub.DefaultTopic, 1000)
+	sched.RunFor(1400 * time.Millisecond)
+	// time in mesh and the first delivery
+	if !(routers[0].(*Router).Score(1) > routers[0].(*Router).Score(2) && routers[0].(*Router).Score(2) > 0) {
+		t.Fatalf("Unexpected scores %v and %v before the activation", routers[0].(*Router).Score(1), routers[0].(*Router).Score(2))
+	}
+
+	// peers not delivering any messages in the mesh turn negative after the activation and are pruned
+	sched.RunFor(2 * time.Second)
+	for _, peerID := range []int64{2, 3} {
+		if routers[0].(*Router).Score(peerID) >= 0 {
+			t.Errorf("Score %v of peer %v is not negative", routers[0].(*Router).Score(peerID), peerID)
+		}
+		if mesh.Exists(peerID) || routers[peerID].(*Router).mesh[pubsub.DefaultTopic].Exists(int64(0)) {
+			t.Errorf("Did not prune peer %v with a negative score", peerID)
+		}
+	}
+	// NOTE: node 1 prunes node 0 in turn since node 0 does not deliver any messages to node 1
+	if routers[0].(*Router).Score(1) <= 0 {
+		t.Errorf("Unexpected score %v of the delivering peer", routers[0].(*Router).Score(1))
+	}
+
+	// the score is retained across reconnections
+	nodes[0].RemovePeer(1)
+	nodes[1].RemovePeer(0)
+	if routers[0].(*Router).Score(1) <= 0 {
+		t.Errorf("Did not retain the score of the disconnected peer")
+	}
+	nodes[0].AddPeer(1)
+	nodes[1].AddPeer(0)
+	if routers[0].(*Router).Score(1) <= 0 {
+		t.Errorf("Did not restore the score of the reconnected peer")
+	}
+}
+
+// star around node 0 where peers 1 to 3 share an IP and peer 4 is graylisted by the application
+func TestScoreThresholds(t *testing.T) {
+	appWeight := 1.0
+	colocationWeight := -1.0
+	cfg := GetDefaultConfig()
+	cfg.Score = &ScoreConfig{
+		AppSpecificWeight:        &appWeight,
+		IPColocationFactorWeight: &colocationWeight,
+	}
+	sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 5, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg, rng)
+	}, [][2]int64{{0, 1}, {0, 2}, {0, 3}, {0, 4}}, false)
+	for _, peerID := range []int64{1, 2, 3} {
+		routers[0].(*Router).SetPeerIP(peerID, "10.0.0.1")
+	}
+	routers[0].(*Router).SetAppScore(func(peerID int64) float64 {
+		if peerID == 4 {
+			return 2 * GraylistThreshold
+		}
+		return 0
+	})
+	pubsubtest.StartNodes(t, sched, nodes)
+
+	for _, peerID := range []int64{1, 2, 3} {
+		// two peers in excess of the threshold
+		if routers[0].(*Router).Score(peerID) != -4 {
+			t.Errorf("Score %v of peer %v, expected -4", routers[0].(*Router).Score(peerID), peerID)
+		}
+		if !routers[0].(*Router).AcceptFrom(peerID) {
+			t.Errorf("Ignored peer %v above the graylist threshold", peerID)
+		}
+	}
+	if routers[0].(*Router).AcceptFrom(4) {
+		t.Errorf("Accepted the graylisted peer")
+	}
+	if mesh := routers[0].(*Router).mesh[pubsub.DefaultTopic]; mesh.Len() != 0 {
+		t.Errorf("Grafted peers %v with a negative score", mesh.Flatten())
+	}
+
+	// messages from the graylisted peer are ignored
+	nodes[4].Publish(pubsub.DefaultTopic, 1000)
+	sched.RunFor(100 * time.Millisecond)
+	if nodes[0].SeenMsgs.SeenMsg(pubsub.MsgID{From: 4, Seqno: 1}) {
+		t.Errorf("Received a message from the graylisted peer")
+	}
+}
@@ -0,0 +1,202 @@
+package invsub
+
+import (
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/gossipsub"
+	"github.com/marlinprotocol/p2psim/pubsub"
+)
+
+// Fetches the announced messages for the announcement based routers
+//
+// Tracks the peers known to have every message, i.e., the peers that sent or announced it,
+//   and caches the messages to respond to the requests of the peers
+// A missing message is requested from its first announcer once the arrival timeout expires
+//   and from the next announcer every request timeout while it does not arrive
+// The known peers, the requests and the cache are forgotten after history_length heartbeats
+
+type Fetcher struct {
+	node *pubsub.Node
+
+	// sends the request for the message to the announcer
+	sendRequest func(peerID int64, msgID pubsub.MsgID)
+
+	// duration after the first announcement before requesting the message, in case it arrives meanwhile
+	arriveTimeout time.Duration
+	// duration after a request before requesting the message from the next announcer
+	requestTimeout time.Duration
+	// duration for which the known peers and the requests are remembered
+	ttl time.Duration
+
+	// MsgID -> set of peers known to have the message
+	// underlying type => int64 (peer ID)
+	known map[pubsub.MsgID]*knownMsg
+
+	// MsgID -> announcements of a message not received yet
+	requests map[pubsub.MsgID]*request
+
+	// To respond to the requests
+	mcache *gossipsub.MessageCache
+}
+
+type knownMsg struct {
+	peerIDs *core.Set
+	// time the message was first sent or announced to the local node
+	time time.Time
+}
+
+type request struct {
+	// peers that announced the message and are yet to be requested, in the order of announcement
+	announcers []int64
+	// time of the first announcement
+	time time.Time
+	// requests the message from the next announcer once the timeout expires
+	task *core.Task
+}
+
+// Fires once the arrival or the request timeout of a message expires
+type fetchEvent struct {
+	fetcher *Fetcher
+	msgID   pubsub.MsgID
+}
+
+func NewFetcher(
+	node *pubsub.Node,
+	historyLength int,
+	heartbeatInterval time.Duration,
+	arriveTimeout time.Duration,
+	requestTimeout time.Duration,
+	sendRequest func(peerID int64, msgID pubsub.MsgID),
+) *Fetcher {
+	return &Fetcher{
+		node:           node,
+		sendRequest:    sendRequest,
+		arriveTimeout:  arriveTimeout,
+		requestTimeout: requestTimeout,
+		ttl:            time.Duration(historyLength) * heartbeatInterval,
+		known:          map[pubsub.MsgID]*knownMsg{},
+		requests:       map[pubsub.MsgID]*request{},
+		mcache:         gossipsub.NewMessageCache(historyLength),
+	}
+}
+
+// Caches the message received from the peer and stops requesting it
+func (fetcher *Fetcher) AddMsg(srcID int64, msg pubsub.Message) {
+	fetcher.mcache.Add(msg)
+
+	msgID := pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}
+	if req, exists := fetcher.requests[msgID]; exists {
+		if req.task != nil {
+			req.task.Cancel()
+		}
+		delete(fetcher.requests, msgID)
+	}
+	if srcID != fetcher.node.ID() {
+		fetcher.AddKnown(msgID, srcID)
+	}
+}
+
+// Requests the message announced by the peer unless it was received already
+// New messages are requested once the arrival timeout expires and later announcements once all the previous announcers were requested
+func (fetcher *Fetcher) HandleAnnounce(peerID int64, msgID pubsub.MsgID) {
+	fetcher.AddKnown(msgID, peerID)
+	if fetcher.node.SeenMsgs.SeenMsg(msgID) {
+		return
+	}
+	req, exists := fetcher.requests[msgID]
+	if !exists {
+		req = &request{
+			announcers: []int64{},
+			time:       fetcher.node.Sched.CurTime,
+			task:       nil,
+		}
+		fetcher.requests[msgID] = req
+	}
+	req.announcers = append(req.announcers, peerID)
+	if req.task == nil && fetcher.arriveTimeout > 0 {
+		req.task = fetcher.node.Sched.Schedule(fetcher.arriveTimeout, &fetchEvent{fetcher: fetcher, msgID: msgID})
+	} else if req.task == nil || !req.task.IsPending() {
+		fetcher.fetch(msgID)
+	}
+}
+
+// Requests the message from the next announcer still connected and retries after the timeout
+func (fetcher *Fetcher) fetch(msgID pubsub.MsgID) {
+	req := fetcher.requests[msgID]
+	for len(req.announcers) > 0 {
+		neighborID := req.announcers[0]
+		req.announcers = req.announcers[1:]
+		if !fetcher.node.NeighborIDs.Exists(neighborID) {
+			continue
+		}
+		fetcher.sendRequest(neighborID, msgID)
+		if req.task == nil {
+			req.task = fetcher.node.Sched.Schedule(fetcher.requestTimeout, &fetchEvent{fetcher: fetcher, msgID: msgID})
+		} else {
+			req.task.Reschedule(fetcher.requestTimeout)
+		}
+		return
+	}
+	// the next announcement is requested right away
+}
+
+func (fetcher *Fetcher) AddKnown(msgID pubsub.MsgID, peerID int64) {
+	if _, exists := fetcher.known[msgID]; !exists {
+		fetcher.known[msgID] = &knownMsg{
+			peerIDs: core.NewSet(),
+			time:    fetcher.node.Sched.CurTime,
+		}
+	}
+	fetcher.known[msgID].peerIDs.Add(peerID)
+}
+
+// Returns true if the peer sent or announced the message
+func (fetcher *Fetcher) IsKnown(msgID pubsub.MsgID, peerID int64) bool {
+	known, exists := fetcher.known[msgID]
+	return exists && known.peerIDs.Exists(peerID)
+}
+
+// Returns the cached messages among the requested ones
+func (fetcher *Fetcher) GetMessages(msgIDs *core.Set) []pubsub.Message {
+	msgs := []pubsub.Message{}
+	msgIDs.Traverse(func(iMsgID interface{}) {
+		if msg, exists := fetcher.mcache.GetMessage(iMsgID.(pubsub.MsgID)); exists {
+			msgs = append(msgs, msg)
+		}
+	})
+	return msgs
+}
+
+// Forgets the messages known to the peers and the requests older than the message cache and shifts the cache
+// Called on every heartbeat
+func (fetcher *Fetcher) Shift() {
+	for msgID, known := range fetcher.known {
+		if !known.time.Add(fetcher.ttl).After(fetcher.node.Sched.CurTime) {
+			delete(fetcher.known, msgID)
+		}
+	}
+	for msgID, req := range fetcher.requests {
+		if !req.time.Add(fetcher.ttl).After(fetcher.node.Sched.CurTime) {
+			if req.task != nil {
+				req.task.Cancel()
+			}
+			delete(fetcher.requests, msgID)
+		}
+	}
+
+	fetcher.mcache.Shift()
+}
+
+// Cancels the pending requests
+func (fetcher *Fetcher) Stop() {
+	for _, req := range fetcher.requests {
+		if req.task != nil {
+			req.task.Cancel()
+		}
+	}
+}
+
+func (event *fetchEvent) Trigger() {
+	event.fetcher.fetch(event.msgID)
+}
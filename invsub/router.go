@@ -0,0 +1,258 @@
+package invsub
+
+import (
+	"errors"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"go.uber.org/zap"
+)
+
+// Announcement based relay as in bitcoin, see https://developer.bitcoin.org/devguide/p2p_network.html#inventory-messages
+//
+// A node announces every new message with INV to its peers subscribed to the topic
+//   except the peers known to have the message, i.e., the peers that sent or announced it
+// The message is requested with GETDATA from the first announcer
+//   and from the next announcer every getdata_timeout while it does not arrive (see Fetcher)
+// With high_bandwidth_peers, a node asks the peers that last delivered a new message first
+//   to send the messages right away without announcing them, as in BIP152 high bandwidth mode
+//   trading duplicate messages for a round trip per hop
+
+var (
+	InvHistErr    = errors.New("History length must be positive!")
+	InvTimeoutErr = errors.New("GETDATA timeout must be positive!")
+	InvPeersErr   = errors.New("Number of high bandwidth peers cannot be negative!")
+)
+
+var (
+	// default config params
+	HeartbeatInterval  = 1 * time.Second
+	HeartbeatPriority  = int(core.DefaultPriority)
+	HistoryLength      = 5
+	GetDataTimeout     = 1 * time.Second
+	HighBandwidthPeers = 0
+)
+
+type Router struct {
+	// invsub config params
+	cfg *Config
+
+	// initialized while initializing the pubsub node
+	node *pubsub.Node
+
+	// requests the announced messages with GETDATA and responds to GETDATA
+	fetcher *Fetcher
+
+	// peers the messages are sent to without announcing them
+	// underlying type => int64 (peer ID)
+	hbTo *core.Set
+
+	// peers asked to send the messages without announcing them, the least recent first
+	hbFrom []int64
+
+	// triggers the heartbeats, stopped on leaving the network
+	ticker *core.Ticker
+}
+
+type Config struct {
+	// Interval between consecutive heartbeats
+	// The message cache is shifted on every heartbeat
+	HeartbeatInterval *time.Duration `toml:"heartbeat_interval,omitempty"`
+
+	// Negative values expire the announcements before the deliveries at the same instant and positive values after
+	HeartbeatPriority *int `toml:"heartbeat_priority,omitempty"`
+
+	// Number of heartbeat events for which the message cache remembers seen messages
+	HistoryLength *int `toml:"history_length,omitempty"`
+
+	// Duration after a GETDATA before the message is requested from the next announcer
+	GetDataTimeout *time.Duration `toml:"getdata_timeout,omitempty"`
+
+	// Number of peers asked to send the messages without announcing them (BIP152 uses 3)
+	// Disabled if zero
+	HighBandwidthPeers *int `toml:"high_bandwidth_peers,omitempty"`
+}
+
+func GetDefaultConfig() *Config {
+	return &Config{
+		HeartbeatInterval:  &HeartbeatInterval,
+		HeartbeatPriority:  &HeartbeatPriority,
+		HistoryLength:      &HistoryLength,
+		GetDataTimeout:     &GetDataTimeout,
+		HighBandwidthPeers: &HighBandwidthPeers,
+	}
+}
+
+func NewRouter(cfg *Config) *Router {
+	return &Router{
+		cfg:    cfg,
+		node:   nil,
+		hbTo:   core.NewSet(),
+		hbFrom: []int64{},
+	}
+}
+
+func (router *Router) Start(node *pubsub.Node, logger *zap.Logger) error {
+	var err error
+
+	if *router.cfg.HistoryLength <= 0 {
+		return InvHistErr
+	}
+	if *router.cfg.GetDataTimeout <= 0 {
+		return InvTimeoutErr
+	}
+	if *router.cfg.HighBandwidthPeers < 0 {
+		return InvPeersErr
+	}
+
+	router.node = node
+	router.fetcher = NewFetcher(
+		node,
+		*router.cfg.HistoryLength,
+		*router.cfg.HeartbeatInterval,
+		// the announced messages are requested right away
+		0,
+		*router.cfg.GetDataTimeout,
+		func(peerID int64, msgID pubsub.MsgID) {
+			getData := &GetData{msgIDs: core.NewSet(msgID)}
+			router.node.SendRPC(peerID, NewControlMsg([]pubsub.Message{}, nil, getData, nil))
+		},
+	)
+
+	// Start timer for heartbeats
+	router.ticker, err = core.StartTicker(
+		node.Sched,
+		*router.cfg.HeartbeatInterval,
+		core.Priority(*router.cfg.HeartbeatPriority),
+		router,
+		logger,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Messages are sent to the high bandwidth peers and announced to the other peers subscribed to the topic
+// The peer delivering the message first becomes a high bandwidth peer
+func (router *Router) PublishMsg(srcID int64, msg pubsub.Message) {
+	router.fetcher.AddMsg(srcID, msg)
+	if srcID != router.node.ID() {
+		router.addHighBandwidth(srcID)
+	}
+
+	msgID := pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}
+	router.node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		if !router.node.PeerSubscribed(neighborID, msg.Topic()) || neighborID == msg.From() {
+			return
+		}
+		if router.fetcher.IsKnown(msgID, neighborID) {
+			return
+		}
+		if router.hbTo.Exists(neighborID) {
+			router.node.SendRPC(neighborID, NewDataMsg([]pubsub.Message{msg}))
+			return
+		}
+		inv := []*Inv{{topic: msg.Topic(), msgIDs: core.NewSet(msgID)}}
+		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, inv, nil, nil))
+	})
+}
+
+func (router *Router) HandleRPC(srcID int64, rpcMsg pubsub.RPC) {
+	for _, msg := range rpcMsg.GetMessages() {
+		router.fetcher.AddKnown(pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}, srcID)
+	}
+
+	control := rpcMsg.(*RPCMsg).control
+	if control == nil {
+		return
+	}
+
+	router.handleInv(srcID, control.inv)
+	if control.sendHB != nil {
+		if control.sendHB.highBandwidth {
+			router.hbTo.Add(srcID)
+		} else {
+			router.hbTo.Remove(srcID)
+		}
+	}
+
+	if control.getData == nil {
+		return
+	}
+	if msgs := router.fetcher.GetMessages(control.getData.msgIDs); len(msgs) > 0 {
+		router.node.SendRPC(srcID, NewDataMsg(msgs))
+	}
+}
+
+// New messages are requested from the first announcer right away
+func (router *Router) handleInv(remoteID int64, inv []*Inv) {
+	for _, topicInv := range inv {
+		// not interested in the messages of topics that are not subscribed to
+		if !router.node.Topics.Exists(topicInv.topic) {
+			continue
+		}
+		topicInv.msgIDs.Traverse(func(iMsgID interface{}) {
+			router.fetcher.HandleAnnounce(remoteID, iMsgID.(pubsub.MsgID))
+		})
+	}
+}
+
+// Replaces the least recent high bandwidth peer
+func (router *Router) addHighBandwidth(peerID int64) {
+	if *router.cfg.HighBandwidthPeers == 0 {
+		return
+	}
+	for i, hbID := range router.hbFrom {
+		if hbID == peerID {
+			// most recent last
+			router.hbFrom = append(append(router.hbFrom[:i:i], router.hbFrom[i+1:]...), peerID)
+			return
+		}
+	}
+	router.hbFrom = append(router.hbFrom, peerID)
+	router.node.SendRPC(peerID, NewControlMsg([]pubsub.Message{}, nil, nil, &SendHB{highBandwidth: true}))
+	if len(router.hbFrom) > *router.cfg.HighBandwidthPeers {
+		router.node.SendRPC(router.hbFrom[0], NewControlMsg([]pubsub.Message{}, nil, nil, &SendHB{highBandwidth: false}))
+		router.hbFrom = router.hbFrom[1:]
+	}
+}
+
+// Messages are announced to the new peer from the next message on
+func (router *Router) AddPeer(remoteID int64) {}
+
+func (router *Router) RemovePeer(remoteID int64) {
+	router.hbTo.Remove(remoteID)
+	for i, hbID := range router.hbFrom {
+		if hbID == remoteID {
+			router.hbFrom = append(router.hbFrom[:i:i], router.hbFrom[i+1:]...)
+			break
+		}
+	}
+}
+
+func (router *Router) Stop() {
+	router.ticker.Stop()
+	router.fetcher.Stop()
+}
+
+func (router *Router) Join(topic string) {}
+
+func (router *Router) Leave(topic string) {}
+
+func (router *Router) HandleSubscription(remoteID int64, topic string, subscribe bool) {}
+
+func (router *Router) AcceptFrom(srcID int64) bool {
+	return true
+}
+
+func (router *Router) HandleTick() {
+	router.fetcher.Shift()
+}
+
+func (router *Router) ID() int64 {
+	return router.node.ID()
+}
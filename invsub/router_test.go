@@ -0,0 +1,81 @@
+package invsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/gossipsub"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"github.com/marlinprotocol/p2psim/pubsub/pubsubtest"
+	exprand "golang.org/x/exp/rand"
+)
+
+func TestInvConfig(t *testing.T) {
+	zero := 0
+	zeroDur := time.Duration(0)
+	negative := -1
+	newRouter := func(update func(*Config)) pubsub.Router {
+		cfg := GetDefaultConfig()
+		update(cfg)
+		return NewRouter(cfg)
+	}
+	pubsubtest.CheckInvRouters(t, []pubsubtest.InvRouter{
+		{Router: newRouter(func(cfg *Config) { cfg.HistoryLength = &zero }), Err: InvHistErr},
+		{Router: newRouter(func(cfg *Config) { cfg.GetDataTimeout = &zeroDur }), Err: InvTimeoutErr},
+		{Router: newRouter(func(cfg *Config) { cfg.HighBandwidthPeers = &negative }), Err: InvPeersErr},
+	})
+}
+
+// node 2 requests the message from node 1, which fails to respond, and then from node 0
+func TestGetDataTimeout(t *testing.T) {
+	timeout := 200 * time.Millisecond
+	cfg := GetDefaultConfig()
+	cfg.GetDataTimeout = &timeout
+	sched, _, nodes, routers := pubsubtest.SpawnNodesWithLatencies(t, 3, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg)
+	}, [][3]int64{{0, 1, 10}, {1, 2, 10}, {0, 2, 50}}, true)
+
+	// node 1 receives the message in a round trip and a half and announces it to node 2 10ms later
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	sched.RunFor(35 * time.Millisecond)
+	msgID := pubsub.MsgID{From: 0, Seqno: 1}
+	if !nodes[1].SeenMsgs.SeenMsg(msgID) {
+		t.Fatalf("Did not request the announced message")
+	}
+	routers[1].(*Router).fetcher.mcache = gossipsub.NewMessageCache(*cfg.HistoryLength)
+
+	// the announcement of node 0 arrives after the request to node 1
+	sched.RunFor(timeout)
+	if nodes[2].SeenMsgs.SeenMsg(msgID) {
+		t.Fatalf("Received the message before the timeout")
+	}
+	sched.RunFor(2*50*time.Millisecond + 10*time.Millisecond)
+	if !nodes[2].SeenMsgs.SeenMsg(msgID) {
+		t.Errorf("Did not request the message from the next announcer")
+	}
+}
+
+// line of nodes where node 0 publishes
+func TestHighBandwidth(t *testing.T) {
+	hbPeers := 1
+	cfg := GetDefaultConfig()
+	cfg.HighBandwidthPeers = &hbPeers
+	sched, _, nodes, routers := pubsubtest.SpawnNodesWithLatencies(t, 3, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg)
+	}, [][3]int64{{0, 1, 10}, {1, 2, 10}}, true)
+
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	sched.RunFor(100 * time.Millisecond)
+	for nodeID := 0; nodeID < 2; nodeID++ {
+		if routers[nodeID].(*Router).hbTo.Len() != 1 || !routers[nodeID].(*Router).hbTo.Exists(int64(nodeID+1)) {
+			t.Errorf("Node %v sends the messages unannounced to %v", nodeID, routers[nodeID].(*Router).hbTo.Flatten())
+		}
+	}
+
+	// the next message is pushed without the round trips
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	sched.RunFor(25 * time.Millisecond)
+	if !nodes[2].SeenMsgs.SeenMsg(pubsub.MsgID{From: 0, Seqno: 2}) {
+		t.Errorf("Did not push the message to the high bandwidth peers")
+	}
+}
@@ -0,0 +1,76 @@
+package invsub
+
+import (
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+)
+
+type RPCMsg struct {
+	size    int64
+	msgs    []pubsub.Message
+	control *ControlMessage
+}
+
+type ControlMessage struct {
+	inv     []*Inv
+	getData *GetData
+	sendHB  *SendHB
+}
+
+// Announces the messages of a topic
+type Inv struct {
+	topic string
+	// Set of MsgID
+	msgIDs *core.Set
+}
+
+// Requests the announced messages
+type GetData struct {
+	// Set of MsgID
+	msgIDs *core.Set
+}
+
+// Asks the peer to send (or stop sending) the messages without announcing them first, as SENDCMPCT in BIP152
+type SendHB struct {
+	highBandwidth bool
+}
+
+func NewDataMsg(msgs []pubsub.Message) *RPCMsg {
+	return NewControlMsg(msgs, nil, nil, nil)
+}
+
+func NewControlMsg(msgs []pubsub.Message, inv []*Inv, getData *GetData, sendHB *SendHB) *RPCMsg {
+	// compute size
+	size := int64(0)
+	for _, msg := range msgs {
+		size += msg.GetSize()
+	}
+	for _, topicInv := range inv {
+		size += int64(len(topicInv.topic)) + int64(topicInv.msgIDs.Len())*8
+	}
+	if getData != nil {
+		size += int64(getData.msgIDs.Len()) * 8
+	}
+	if sendHB != nil {
+		size += 1
+	}
+
+	control := &ControlMessage{
+		inv:     inv,
+		getData: getData,
+		sendHB:  sendHB,
+	}
+	return &RPCMsg{
+		size:    size,
+		msgs:    msgs,
+		control: control,
+	}
+}
+
+func (rpcMsg *RPCMsg) GetSize() int64 {
+	return rpcMsg.size
+}
+
+func (rpcMsg *RPCMsg) GetMessages() []pubsub.Message {
+	return rpcMsg.msgs
+}
@@ -0,0 +1,399 @@
+package plumtree
+
+import (
+	"errors"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/gossipsub"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"go.uber.org/zap"
+)
+
+// Epidemic broadcast trees, see https://asc.di.fct.unl.pt/~jleitao/pdf/srds07-leitao.pdf
+//
+// Every topic splits the peers subscribed to it into eager and lazy peers
+// - messages are pushed to the eager peers right away (eager push)
+// - message IDs are announced to the lazy peers with IHAVE right away (lazy push)
+// All the peers start out eager and a peer delivering a duplicate is turned lazy with PRUNE
+//   so that the eager links converge to a spanning tree
+// A message announced but not received within lazy_timeout is requested with GRAFT from the first announcer
+//   which also turns the link eager again, repairing the tree
+//   the next announcer is grafted every graft_timeout while the message is missing
+// NOTE: the optimisation swapping an eager link for a lazy link with fewer hops is not simulated
+
+var (
+	InvHistErr    = errors.New("History length must be positive!")
+	InvTimeoutErr = errors.New("Timeouts must be positive!")
+)
+
+var (
+	// default config params
+	HeartbeatInterval = 1 * time.Second
+	HeartbeatPriority = int(core.DefaultPriority)
+	HistoryLength     = 5
+	LazyTimeout       = 100 * time.Millisecond
+	GraftTimeout      = 50 * time.Millisecond
+)
+
+type Router struct {
+	// plumtree config params
+	cfg *Config
+
+	// initialized while initializing the pubsub node
+	node *pubsub.Node
+
+	// topics joined by the local node
+	// underlying type => string
+	topics *core.Set
+
+	// topic -> set of eager peers of the topic
+	// underlying type => int64 (peer ID)
+	eager map[string]*core.Set
+
+	// topic -> set of lazy peers of the topic
+	// underlying type => int64 (peer ID)
+	lazy map[string]*core.Set
+
+	// messages delivered for the first time by the RPC being handled
+	// underlying type => pubsub.MsgID
+	fresh *core.Set
+
+	// MsgID -> announcements of a message not received yet
+	// forgotten after history_length heartbeats
+	missing map[pubsub.MsgID]*missingMsg
+
+	// To respond to grafts requesting messages
+	mcache *gossipsub.MessageCache
+
+	// triggers the heartbeats, stopped on leaving the network
+	ticker *core.Ticker
+}
+
+type missingMsg struct {
+	topic string
+	// peers that announced the message and are yet to be grafted, in the order of announcement
+	announcers []int64
+	// time of the first announcement
+	time time.Time
+	// grafts the next announcer once the timeout expires
+	task *core.Task
+}
+
+type Config struct {
+	// Interval between consecutive heartbeats
+	// The message cache is shifted on every heartbeat
+	HeartbeatInterval *time.Duration `toml:"heartbeat_interval,omitempty"`
+
+	// Negative values shift the message cache before the deliveries at the same instant and positive values after
+	HeartbeatPriority *int `toml:"heartbeat_priority,omitempty"`
+
+	// Number of heartbeat events for which the message cache remembers seen messages
+	HistoryLength *int `toml:"history_length,omitempty"`
+
+	// Duration after the first announcement before the missing message is requested
+	LazyTimeout *time.Duration `toml:"lazy_timeout,omitempty"`
+
+	// Duration after a graft before the next announcer of the missing message is grafted
+	GraftTimeout *time.Duration `toml:"graft_timeout,omitempty"`
+}
+
+func GetDefaultConfig() *Config {
+	return &Config{
+		HeartbeatInterval: &HeartbeatInterval,
+		HeartbeatPriority: &HeartbeatPriority,
+		HistoryLength:     &HistoryLength,
+		LazyTimeout:       &LazyTimeout,
+		GraftTimeout:      &GraftTimeout,
+	}
+}
+
+func NewRouter(cfg *Config) *Router {
+	return &Router{
+		cfg:     cfg,
+		node:    nil,
+		topics:  core.NewSet(),
+		eager:   map[string]*core.Set{},
+		lazy:    map[string]*core.Set{},
+		fresh:   core.NewSet(),
+		missing: map[pubsub.MsgID]*missingMsg{},
+		mcache:  gossipsub.NewMessageCache(*cfg.HistoryLength),
+	}
+}
+
+func (router *Router) Start(node *pubsub.Node, logger *zap.Logger) error {
+	var err error
+
+	if *router.cfg.HistoryLength <= 0 {
+		return InvHistErr
+	}
+	if *router.cfg.LazyTimeout <= 0 || *router.cfg.GraftTimeout <= 0 {
+		return InvTimeoutErr
+	}
+
+	router.node = node
+
+	// Start timer for heartbeats
+	router.ticker, err = core.StartTicker(
+		node.Sched,
+		*router.cfg.HeartbeatInterval,
+		core.Priority(*router.cfg.HeartbeatPriority),
+		router,
+		logger,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// All the peers subscribed to the topic start out eager
+func (router *Router) Join(topic string) {
+	if router.topics.Exists(topic) {
+		return
+	}
+	router.topics.Add(topic)
+	router.eager[topic] = core.NewSet()
+	router.lazy[topic] = core.NewSet()
+	router.node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		if router.node.PeerSubscribed(neighborID, topic) {
+			router.eager[topic].Add(neighborID)
+		}
+	})
+}
+
+// The peers learn of leaving the topic from the subscription announcement
+func (router *Router) Leave(topic string) {
+	if !router.topics.Exists(topic) {
+		return
+	}
+	delete(router.eager, topic)
+	delete(router.lazy, topic)
+	router.topics.Remove(topic)
+}
+
+// Messages are pushed to the eager peers and announced to the lazy peers
+// Messages published on a topic that is not joined are pushed to all the peers subscribed to the topic
+func (router *Router) PublishMsg(srcID int64, msg pubsub.Message) {
+	// add message to cache
+	router.mcache.Add(msg)
+
+	msgID := pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}
+	if srcID != router.node.ID() {
+		router.fresh.Add(msgID)
+	}
+	if missing, exists := router.missing[msgID]; exists {
+		missing.task.Cancel()
+		delete(router.missing, msgID)
+	}
+
+	eager, joined := router.eager[msg.Topic()]
+	if !joined {
+		router.node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
+			neighborID := iNeighborID.(int64)
+			if router.node.PeerSubscribed(neighborID, msg.Topic()) {
+				router.node.SendRPC(neighborID, NewDataMsg(msg))
+			}
+		})
+		return
+	}
+
+	// the link the message arrived on is part of the tree
+	//   unless the sender publishes without subscribing to the topic, as it would never prune the link
+	if srcID != router.node.ID() && router.node.PeerSubscribed(srcID, msg.Topic()) {
+		router.makeEager(srcID, msg.Topic())
+	}
+
+	eager.Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		if neighborID != srcID && neighborID != msg.From() {
+			router.node.SendRPC(neighborID, NewDataMsg(msg))
+		}
+	})
+	router.lazy[msg.Topic()].Traverse(func(iNeighborID interface{}) {
+		neighborID := iNeighborID.(int64)
+		if neighborID != srcID && neighborID != msg.From() {
+			ihave := []*IHave{{topic: msg.Topic(), msgIDs: core.NewSet(msgID)}}
+			router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, ihave, nil, nil))
+		}
+	})
+}
+
+func (router *Router) HandleRPC(srcID int64, rpcMsg pubsub.RPC) {
+	// eager peers delivering duplicates are pruned
+	prune := []string{}
+	for _, msg := range rpcMsg.GetMessages() {
+		msgID := pubsub.MsgID{From: msg.From(), Seqno: msg.Seqno()}
+		if router.fresh.Exists(msgID) {
+			continue
+		}
+		eager, joined := router.eager[msg.Topic()]
+		if joined && eager.Exists(srcID) {
+			router.makeLazy(srcID, msg.Topic())
+			prune = append(prune, msg.Topic())
+		}
+	}
+	router.fresh = core.NewSet()
+
+	msgs := []pubsub.Message{}
+	if control := rpcMsg.(*RPCMsg).control; control != nil {
+		router.handleIHave(srcID, control.ihave)
+		router.handlePrune(srcID, control.prune)
+		msgs = router.handleGraft(srcID, control.graft)
+	}
+
+	if len(msgs) == 0 && len(prune) == 0 {
+		return
+	}
+	router.node.SendRPC(srcID, NewControlMsg(msgs, nil, nil, prune))
+}
+
+// The missing messages are requested once the lazy timeout expires
+func (router *Router) handleIHave(remoteID int64, ihave []*IHave) {
+	for _, topicIHave := range ihave {
+		// not interested in the messages of topics that are not joined
+		if !router.topics.Exists(topicIHave.topic) {
+			continue
+		}
+		topicIHave.msgIDs.Traverse(func(iMsgID interface{}) {
+			msgID := iMsgID.(pubsub.MsgID)
+			if router.node.SeenMsgs.SeenMsg(msgID) {
+				return
+			}
+			if missing, exists := router.missing[msgID]; exists {
+				missing.announcers = append(missing.announcers, remoteID)
+				// all the previous announcers were grafted already
+				if !missing.task.IsPending() {
+					missing.task.Reschedule(*router.cfg.GraftTimeout)
+				}
+				return
+			}
+			router.missing[msgID] = &missingMsg{
+				topic:      topicIHave.topic,
+				announcers: []int64{remoteID},
+				time:       router.node.Sched.CurTime,
+				task:       router.node.Sched.Schedule(*router.cfg.LazyTimeout, &graftEvent{router: router, msgID: msgID}),
+			}
+		})
+	}
+}
+
+// Returns the requested messages available in the cache
+func (router *Router) handleGraft(remoteID int64, graft []*Graft) []pubsub.Message {
+	msgs := []pubsub.Message{}
+	for _, topicGraft := range graft {
+		if !router.topics.Exists(topicGraft.topic) {
+			continue
+		}
+		router.makeEager(remoteID, topicGraft.topic)
+		topicGraft.msgIDs.Traverse(func(iMsgID interface{}) {
+			if msg, exists := router.mcache.GetMessage(iMsgID.(pubsub.MsgID)); exists {
+				msgs = append(msgs, msg)
+			}
+		})
+	}
+	return msgs
+}
+
+func (router *Router) handlePrune(remoteID int64, prune []string) {
+	for _, topic := range prune {
+		if router.topics.Exists(topic) {
+			router.makeLazy(remoteID, topic)
+		}
+	}
+}
+
+func (router *Router) makeEager(neighborID int64, topic string) {
+	router.lazy[topic].Remove(neighborID)
+	router.eager[topic].Add(neighborID)
+}
+
+func (router *Router) makeLazy(neighborID int64, topic string) {
+	router.eager[topic].Remove(neighborID)
+	router.lazy[topic].Add(neighborID)
+}
+
+// Grafts the next announcer still connected and retries after the graft timeout
+func (router *Router) graftMissing(msgID pubsub.MsgID) {
+	missing := router.missing[msgID]
+	for len(missing.announcers) > 0 {
+		neighborID := missing.announcers[0]
+		missing.announcers = missing.announcers[1:]
+		if !router.node.NeighborIDs.Exists(neighborID) || !router.topics.Exists(missing.topic) {
+			continue
+		}
+		router.makeEager(neighborID, missing.topic)
+		graft := []*Graft{{topic: missing.topic, msgIDs: core.NewSet(msgID)}}
+		router.node.SendRPC(neighborID, NewControlMsg([]pubsub.Message{}, nil, graft, nil))
+		break
+	}
+	// the timer is restarted by the next announcement otherwise
+	if len(missing.announcers) > 0 {
+		missing.task.Reschedule(*router.cfg.GraftTimeout)
+	}
+}
+
+// The new peer is added to the eager peers of the topics it announces
+func (router *Router) AddPeer(remoteID int64) {}
+
+func (router *Router) RemovePeer(remoteID int64) {
+	router.topics.Traverse(func(iTopic interface{}) {
+		topic := iTopic.(string)
+		router.eager[topic].Remove(remoteID)
+		router.lazy[topic].Remove(remoteID)
+	})
+}
+
+func (router *Router) HandleSubscription(remoteID int64, topic string, subscribe bool) {
+	if !router.topics.Exists(topic) {
+		return
+	}
+	if !subscribe {
+		router.eager[topic].Remove(remoteID)
+		router.lazy[topic].Remove(remoteID)
+		return
+	}
+	if !router.lazy[topic].Exists(remoteID) {
+		router.eager[topic].Add(remoteID)
+	}
+}
+
+func (router *Router) Stop() {
+	router.ticker.Stop()
+	for _, missing := range router.missing {
+		missing.task.Cancel()
+	}
+}
+
+func (router *Router) AcceptFrom(srcID int64) bool {
+	return true
+}
+
+// Forgets the missing messages announced before the message cache and shifts the cache
+func (router *Router) HandleTick() {
+	ttl := time.Duration(*router.cfg.HistoryLength) * *router.cfg.HeartbeatInterval
+	for msgID, missing := range router.missing {
+		if !missing.time.Add(ttl).After(router.node.Sched.CurTime) {
+			missing.task.Cancel()
+			delete(router.missing, msgID)
+		}
+	}
+
+	router.mcache.Shift()
+}
+
+func (router *Router) ID() int64 {
+	return router.node.ID()
+}
+
+// Fires once the lazy (or graft) timeout of a missing message expires
+type graftEvent struct {
+	router *Router
+	msgID  pubsub.MsgID
+}
+
+func (event *graftEvent) Trigger() {
+	event.router.graftMissing(event.msgID)
+}
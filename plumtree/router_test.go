@@ -0,0 +1,89 @@
+package plumtree
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"github.com/marlinprotocol/p2psim/pubsub/pubsubtest"
+	exprand "golang.org/x/exp/rand"
+)
+
+func TestInvTimeout(t *testing.T) {
+	zero := time.Duration(0)
+	cfg := GetDefaultConfig()
+	cfg.GraftTimeout = &zero
+	pubsubtest.CheckInvRouters(t, []pubsubtest.InvRouter{{Router: NewRouter(cfg), Err: InvTimeoutErr}})
+}
+
+// triangle of nodes where node 0 publishes
+func TestTree(t *testing.T) {
+	cfg := GetDefaultConfig()
+	sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 3, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(cfg)
+	}, [][2]int64{{0, 1}, {0, 2}, {1, 2}}, true)
+	for _, iRouter := range routers {
+		router := iRouter.(*Router)
+		if router.eager[pubsub.DefaultTopic].Len() != 2 {
+			t.Fatalf("Peers %v start out eager, expected 2", router.eager[pubsub.DefaultTopic].Flatten())
+		}
+	}
+
+	// nodes 1 and 2 push the message to each other and prune the link on the duplicates
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	sched.RunFor(100 * time.Millisecond)
+	for _, nodeID := range []int64{1, 2} {
+		eager := routers[nodeID].(*Router).eager[pubsub.DefaultTopic]
+		lazy := routers[nodeID].(*Router).lazy[pubsub.DefaultTopic]
+		if eager.Len() != 1 || !eager.Exists(int64(0)) || lazy.Len() != 1 || !lazy.Exists(3-nodeID) {
+			t.Errorf("Node %v has eager peers %v and lazy peers %v", nodeID, eager.Flatten(), lazy.Flatten())
+		}
+	}
+
+	// the link to node 2 breaks and node 2 grafts node 1 after the lazy timeout
+	nodes[0].RemovePeer(2)
+	nodes[2].RemovePeer(0)
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	sched.RunFor(20 * time.Millisecond)
+	msgID := pubsub.MsgID{From: 0, Seqno: 2}
+	if nodes[2].SeenMsgs.SeenMsg(msgID) {
+		t.Fatalf("Received the message before the lazy timeout")
+	}
+	// the graft and the message take another round trip
+	sched.RunFor(*cfg.LazyTimeout + 30*time.Millisecond)
+	if !nodes[2].SeenMsgs.SeenMsg(msgID) {
+		t.Fatalf("Did not graft the announcer of the missing message")
+	}
+	if !routers[1].(*Router).eager[pubsub.DefaultTopic].Exists(int64(2)) || !routers[2].(*Router).eager[pubsub.DefaultTopic].Exists(int64(1)) {
+		t.Errorf("Did not repair the tree")
+	}
+
+	// the next message is pushed along the repaired tree
+	nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+	sched.RunFor(30 * time.Millisecond)
+	if !nodes[2].SeenMsgs.SeenMsg(pubsub.MsgID{From: 0, Seqno: 3}) {
+		t.Errorf("Did not push the message along the repaired tree")
+	}
+}
+
+// triangle of nodes where node 0 publishes without subscribing
+func TestUnsubscribedPublisher(t *testing.T) {
+	sched, _, nodes, routers := pubsubtest.SpawnNodes(t, 3, func(rng exprand.Source) pubsub.Router {
+		return NewRouter(GetDefaultConfig())
+	}, [][2]int64{{0, 1}, {0, 2}, {1, 2}}, false)
+	nodes[0].Unsubscribe(pubsub.DefaultTopic)
+	pubsubtest.StartNodes(t, sched, nodes)
+
+	for seqno := int64(1); seqno <= 5; seqno++ {
+		nodes[0].Publish(pubsub.DefaultTopic, pubsub.BlockSize)
+		sched.RunFor(100 * time.Millisecond)
+		for _, nodeID := range []int64{1, 2} {
+			if !nodes[nodeID].SeenMsgs.SeenMsg(pubsub.MsgID{From: 0, Seqno: seqno}) {
+				t.Errorf("Node %v did not receive message %v", nodeID, seqno)
+			}
+			if routers[nodeID].(*Router).eager[pubsub.DefaultTopic].Exists(int64(0)) {
+				t.Errorf("Node %v has the unsubscribed publisher as an eager peer", nodeID)
+			}
+		}
+	}
+}
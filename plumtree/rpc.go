@@ -0,0 +1,77 @@
+package plumtree
+
+import (
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+)
+
+type RPCMsg struct {
+	size    int64
+	msgs    []pubsub.Message
+	control *ControlMessage
+}
+
+// PRUNE messages carry the topics they refer to
+type ControlMessage struct {
+	ihave []*IHave
+	graft []*Graft
+	prune []string
+}
+
+// Lazy push of the messages to the lazy peers
+type IHave struct {
+	topic string
+	// Set of MsgID
+	msgIDs *core.Set
+}
+
+// Turns the link into an eager one and requests the missing messages, if any
+type Graft struct {
+	topic string
+	// Set of MsgID
+	msgIDs *core.Set
+}
+
+func NewDataMsg(msg pubsub.Message) *RPCMsg {
+	return &RPCMsg{
+		size:    msg.GetSize(),
+		msgs:    []pubsub.Message{msg},
+		control: nil,
+	}
+}
+
+func NewControlMsg(msgs []pubsub.Message, ihave []*IHave, graft []*Graft, prune []string) *RPCMsg {
+	// compute size
+	size := int64(0)
+	for _, msg := range msgs {
+		size += msg.GetSize()
+	}
+	for _, topicIHave := range ihave {
+		size += int64(len(topicIHave.topic)) + int64(topicIHave.msgIDs.Len())*8
+	}
+	for _, topicGraft := range graft {
+		size += int64(len(topicGraft.topic)) + 1 + int64(topicGraft.msgIDs.Len())*8
+	}
+	for _, topic := range prune {
+		size += int64(len(topic)) + 1
+	}
+
+	control := &ControlMessage{
+		ihave: ihave,
+		graft: graft,
+		prune: prune,
+	}
+	return &RPCMsg{
+		size:    size,
+		msgs:    msgs,
+		control: control,
+	}
+}
+
+func (rpcMsg *RPCMsg) GetSize() int64 {
+	return rpcMsg.size
+}
+
+func (rpcMsg *RPCMsg) GetMessages() []pubsub.Message {
+	return rpcMsg.msgs
+}
@@ -0,0 +1,128 @@
+package pubsub
+
+import (
+	"errors"
+	"time"
+)
+
+// Large messages take longer to deliver than small ones
+// A message is delivered after it is transmitted and then propagated (see latency.go) to the receiver
+//
+// Transmitting an RPC occupies
+// - the outgoing link from the sender to the receiver
+// - the uplink of the sender shared by all its outgoing links
+// - the downlink of the receiver shared by all its incoming links
+// Each of these is a serialization queue transmitting one RPC at a time in the order of sending
+// The transmission of an RPC starts once the outgoing link is free and completes when the slowest of the three finishes
+//   the time taken by each is the size of the RPC on the wire (see GetWireSize) divided by its bandwidth
+//   the uplink starts on the RPC once it is done with the previous RPCs of the sender, even if the outgoing link is busy
+// Bandwidths are measured in Mbit/s and a zero bandwidth is unlimited, i.e., transmits instantaneously
+
+var (
+	NegBandwidthErr = errors.New("Cannot specify a negative bandwidth!")
+)
+
+var (
+	// Default config params
+	// unlimited
+	Upload   = 0.0
+	Download = 0.0
+)
+
+type BandwidthConfig struct {
+	// Upload bandwidth of every node in Mbit/s
+	Upload *float64 `toml:"upload,omitempty"`
+
+	// Download bandwidth of every node in Mbit/s
+	Download *float64 `toml:"download,omitempty"`
+}
+
+type bandwidthModel struct {
+	// node ID -> bandwidth
+	uploads   map[int64]float64
+	downloads map[int64]float64
+	// bandwidths of specific links in both directions
+	linkBandwidths map[linkKey]float64
+
+	// times until which the queues are busy transmitting
+	uploadBusy   map[int64]time.Time
+	downloadBusy map[int64]time.Time
+	// indexed by the (sender, receiver) pair
+	linkBusy map[[2]int64]time.Time
+}
+
+func GetDefaultBandwidthConfig() *BandwidthConfig {
+	upload := Upload
+	download := Download
+	return &BandwidthConfig{
+		Upload:   &upload,
+		Download: &download,
+	}
+}
+
+func newBandwidthModel() *bandwidthModel {
+	return &bandwidthModel{
+		uploads:        map[int64]float64{},
+		downloads:      map[int64]float64{},
+		linkBandwidths: map[linkKey]float64{},
+		uploadBusy:     map[int64]time.Time{},
+		downloadBusy:   map[int64]time.Time{},
+		linkBusy:       map[[2]int64]time.Time{},
+	}
+}
+
+// Sets the upload and download bandwidths (in Mbit/s) of the node
+func (net *Network) SetBandwidth(nodeID int64, upload float64, download float64) error {
+	if upload < 0 || download < 0 {
+		return NegBandwidthErr
+	}
+	net.bandwidth.uploads[nodeID] = upload
+	net.bandwidth.downloads[nodeID] = download
+	return nil
+}
+
+// Sets the bandwidth (in Mbit/s) of the link in both directions
+func (net *Network) SetLinkBandwidth(nodeID int64, otherID int64, bandwidth float64) error {
+	if bandwidth < 0 {
+		return NegBandwidthErr
+	}
+	net.bandwidth.linkBandwidths[newLinkKey(nodeID, otherID)] = bandwidth
+	return nil
+}
+
+// Queues an RPC of the given size on the wire (in bytes) and returns the time its transmission completes
+func (model *bandwidthModel) transmit(srcID int64, dstID int64, wireSize int64, curTime time.Time) time.Time {
+	link := [2]int64{srcID, dstID}
+	start := maxTime(curTime, model.linkBusy[link])
+	end := start.Add(getTxTime(wireSize, model.linkBandwidths[newLinkKey(srcID, dstID)]))
+
+	// the uplink does not wait for the outgoing link, which may be held up by the downlink of the receiver
+	if upload := model.uploads[srcID]; upload > 0 {
+		uploadEnd := maxTime(curTime, model.uploadBusy[srcID]).Add(getTxTime(wireSize, upload))
+		model.uploadBusy[srcID] = uploadEnd
+		end = maxTime(end, uploadEnd)
+	}
+	if download := model.downloads[dstID]; download > 0 {
+		downloadEnd := maxTime(start, model.downloadBusy[dstID]).Add(getTxTime(wireSize, download))
+		model.downloadBusy[dstID] = downloadEnd
+		end = maxTime(end, downloadEnd)
+	}
+
+	model.linkBusy[link] = end
+	return end
+}
+
+func getTxTime(wireSize int64, bandwidth float64) time.Duration {
+	if bandwidth == 0 {
+		return 0
+	}
+	// Mbit/s -> bits per second
+	return time.Duration(float64(8*wireSize) / (bandwidth * 1e6) * float64(time.Second))
+}
+
+func maxTime(time1 time.Time, time2 time.Time) time.Time {
+	if time1.Before(time2) {
+		return time2
+	}
+	return time1
+}
@@ -0,0 +1,248 @@
+package pubsub
+
+import (
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+)
+
+// Cut-through forwarding
+// A node forwarding with cut-through splits the messages of an RPC into chunks of MaxPayloadSize bytes sent individually
+//   after a header carrying the rest of the RPC, i.e., the control messages of the router
+// The receiver hands the RPC to its router on the arrival of the header, before the messages are complete,
+//   and forwards every chunk of a message as it arrives to the peers the router sent the message to
+// A message is delivered and marked as seen once all of its chunks arrive (see collector.go)
+//   so that the routers recover the messages missing chunks as they recover missing messages, ex: with IWANT
+//   the router is handed a message whose header is lost once the message completes
+// Every node accepts chunks, whether or not it forwards with cut-through itself
+
+// RPC carrying a single chunk of a message, either sent with cut-through or by a router coding the messages
+// The message is complete once `ChunksNeeded` distinct chunks of the message arrive (see collector.go)
+type Chunk interface {
+	RPC
+	Msg() Message
+	// distinct chunks of a message have distinct indices
+	Index() int64
+	ChunksNeeded() int64
+}
+
+// Rest of an RPC whose messages are sent as chunks
+type HeaderRPC struct {
+	rpc  RPC
+	size int64
+}
+
+// Part of a message sent as a separate RPC
+type ChunkRPC struct {
+	msg       Message
+	index     int64
+	numChunks int64
+	size      int64
+}
+
+// Chunks of a message known to the local node
+type chunkedMsg struct {
+	// set of indices of the chunks received
+	// underlying type => int64
+	received  *core.Set
+	numChunks int64
+	// peers the message is forwarded to
+	// underlying type => int64 (peer ID)
+	dstIDs *core.Set
+	// whether the router was handed the message
+	published bool
+}
+
+// Forwards the messages of the RPCs sent from here on with cut-through
+func (node *Node) EnableCutThrough() {
+	node.cutThrough = true
+}
+
+// Sends the header and the chunks received so far
+// The other chunks are sent as they arrive
+func (node *Node) sendChunks(remoteID int64, rpcMsg RPC) {
+	msgs := rpcMsg.GetMessages()
+	headerSize := rpcMsg.GetSize()
+	for _, msg := range msgs {
+		headerSize -= msg.GetSize()
+	}
+	if headerSize < 0 {
+		headerSize = 0
+	}
+	node.link.SendRPC(remoteID, &HeaderRPC{rpc: rpcMsg, size: headerSize})
+
+	for _, msg := range msgs {
+		msgID := MsgID{From: msg.From(), Seqno: msg.Seqno()}
+		chunked, exists := node.chunkedMsgs[msgID]
+		if !exists {
+			// published locally or received in one piece
+			chunked = node.addChunkedMsg(msgID, msg, true)
+		}
+		chunked.dstIDs.Add(remoteID)
+		for index := int64(0); index < chunked.numChunks; index++ {
+			if chunked.received.Exists(index) {
+				node.link.SendRPC(remoteID, newChunkRPC(msg, index))
+			}
+		}
+	}
+}
+
+// Hands the messages of the header to the router before their chunks arrive
+// The messages are handed only once, whether or not they complete
+func (node *Node) handleHeader(srcID int64, header *HeaderRPC) {
+	for _, msg := range header.rpc.GetMessages() {
+		msgID := MsgID{From: msg.From(), Seqno: msg.Seqno()}
+		if !node.Topics.Exists(msg.Topic()) || node.SeenMsgs.SeenMsg(msgID) {
+			continue
+		}
+		chunked, exists := node.chunkedMsgs[msgID]
+		if !exists {
+			chunked = node.addChunkedMsg(msgID, msg, false)
+		}
+		if !chunked.published {
+			chunked.published = true
+			node.router.PublishMsg(srcID, msg)
+		}
+	}
+}
+
+// Forwards a chunk received for the first time to the peers the message is forwarded to
+func (node *Node) handleChunk(srcID int64, chunk *ChunkRPC) {
+	msg := chunk.msg
+	if !node.Topics.Exists(msg.Topic()) {
+		return
+	}
+	msgID := MsgID{From: msg.From(), Seqno: msg.Seqno()}
+	chunked, exists := node.chunkedMsgs[msgID]
+	if !exists {
+		// the message was complete already
+		if node.SeenMsgs.SeenMsg(msgID) {
+			return
+		}
+		// chunks may overtake their header
+		chunked = node.addChunkedMsg(msgID, msg, false)
+	}
+	if chunked.received.Exists(chunk.index) {
+		return
+	}
+	chunked.received.Add(chunk.index)
+	chunked.dstIDs.Traverse(func(iDstID interface{}) {
+		dstID := iDstID.(int64)
+		if dstID != srcID {
+			node.link.SendRPC(dstID, chunk)
+		}
+	})
+
+	if int64(chunked.received.Len()) < chunked.numChunks {
+		return
+	}
+	// the header may be lost
+	if node.SeenMsgs.MarkSeen(msgID, node.Sched.CurTime) && !chunked.published {
+		chunked.published = true
+		node.router.PublishMsg(srcID, msg)
+	}
+}
+
+// Forwards the missing chunks of a message received in one piece
+// Returns whether the router was handed the message already
+func (node *Node) completeChunks(msg Message) bool {
+	msgID := MsgID{From: msg.From(), Seqno: msg.Seqno()}
+	chunked, exists := node.chunkedMsgs[msgID]
+	if !exists {
+		return false
+	}
+	for index := int64(0); index < chunked.numChunks; index++ {
+		if chunked.received.Exists(index) {
+			continue
+		}
+		chunked.received.Add(index)
+		chunk := newChunkRPC(msg, index)
+		chunked.dstIDs.Traverse(func(iDstID interface{}) {
+			node.link.SendRPC(iDstID.(int64), chunk)
+		})
+	}
+	return chunked.published
+}
+
+// Chunks are remembered as long as the messages are marked as seen
+func (node *Node) addChunkedMsg(msgID MsgID, msg Message, complete bool) *chunkedMsg {
+	node.sweepChunks(node.Sched.CurTime)
+	chunked := &chunkedMsg{
+		received:  core.NewSet(),
+		numChunks: getChunkCount(msg.GetSize()),
+		dstIDs:    core.NewSet(),
+		published: complete,
+	}
+	if complete {
+		for index := int64(0); index < chunked.numChunks; index++ {
+			chunked.received.Add(index)
+		}
+	}
+	node.chunkedMsgs[msgID] = chunked
+	node.chunkEntries = append(node.chunkEntries, SeenEntry{msgID: msgID, entryTime: node.Sched.CurTime})
+	return chunked
+}
+
+func (node *Node) sweepChunks(curTime time.Time) {
+	oldestValidTime := curTime.Add(-1 * node.SeenMsgs.seenTTL)
+	for 0 < len(node.chunkEntries) && node.chunkEntries[0].entryTime.Before(oldestValidTime) {
+		delete(node.chunkedMsgs, node.chunkEntries[0].msgID.(MsgID))
+		// the first element is garbage collected on reallocation
+		node.chunkEntries = node.chunkEntries[1:]
+	}
+}
+
+// Empty messages are sent as a single empty chunk
+func getChunkCount(msgSize int64) int64 {
+	if msgSize <= 0 {
+		return 1
+	}
+	return (msgSize + MaxPayloadSize - 1) / MaxPayloadSize
+}
+
+func newChunkRPC(msg Message, index int64) *ChunkRPC {
+	size := msg.GetSize() - index*MaxPayloadSize
+	if size > MaxPayloadSize {
+		size = MaxPayloadSize
+	}
+	if size < 0 {
+		size = 0
+	}
+	return &ChunkRPC{
+		msg:       msg,
+		index:     index,
+		numChunks: getChunkCount(msg.GetSize()),
+		size:      size,
+	}
+}
+
+func (header *HeaderRPC) GetSize() int64 {
+	return header.size
+}
+
+// The messages are sent as chunks
+func (header *HeaderRPC) GetMessages() []Message {
+	return []Message{}
+}
+
+func (chunk *ChunkRPC) GetSize() int64 {
+	return chunk.size
+}
+
+// Only the part of the message in the chunk is transferred (see the stat collector)
+func (chunk *ChunkRPC) GetMessages() []Message {
+	return []Message{chunk.msg}
+}
+
+func (chunk *ChunkRPC) Msg() Message {
+	return chunk.msg
+}
+
+func (chunk *ChunkRPC) Index() int64 {
+	return chunk.index
+}
+
+// All the chunks are needed to reassemble the message
+func (chunk *ChunkRPC) ChunksNeeded() int64 {
+	return chunk.numChunks
+}
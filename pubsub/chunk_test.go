@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	exprand "golang.org/x/exp/rand"
+)
+
+// Records the messages the node hands to the router
+type publishRecorder struct {
+	published []Message
+}
+
+func (router *publishRecorder) Start(node *Node, logger *zap.Logger) error {
+	return nil
+}
+
+func (router *publishRecorder) PublishMsg(srcID int64, msg Message) {
+	router.published = append(router.published, msg)
+}
+
+func (router *publishRecorder) HandleRPC(srcID int64, rpcMsg RPC) {}
+
+func (router *publishRecorder) AddPeer(remoteID int64) {}
+
+func (router *publishRecorder) RemovePeer(remoteID int64) {}
+
+func (router *publishRecorder) Stop() {}
+
+func (router *publishRecorder) Join(topic string) {}
+
+func (router *publishRecorder) Leave(topic string) {}
+
+func (router *publishRecorder) HandleSubscription(remoteID int64, topic string, subscribe bool) {}
+
+func (router *publishRecorder) AcceptFrom(srcID int64) bool {
+	return true
+}
+
+func newRecordedNode(t *testing.T) (*Node, *publishRecorder) {
+	sched, net := newTestNetwork(t, 10)
+	router := &publishRecorder{}
+	node, err := SpawnNewNode(sched, net, time.Minute, router, 1, exprand.NewSource(55), zap.L())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	node.Subscribe(testTopic)
+	return node, router
+}
+
+// chunks of a message whose header is lost
+func TestLostHeader(t *testing.T) {
+	node, router := newRecordedNode(t)
+	msg := &CollectorMsg{from: 0, seqno: 1, size: 3 * MaxPayloadSize}
+	msgID := MsgID{From: 0, Seqno: 1}
+	for _, index := range []int64{0, 2} {
+		node.HandleRPC(0, newChunkRPC(msg, index))
+	}
+	// the message is not complete and remains missing for the router to recover
+	if node.SeenMsgs.SeenMsg(msgID) || len(router.published) != 0 {
+		t.Fatalf("Marked the message with a missing chunk as seen")
+	}
+
+	node.HandleRPC(0, newChunkRPC(msg, 1))
+	if !node.SeenMsgs.SeenMsg(msgID) || len(router.published) != 1 {
+		t.Errorf("Did not hand the complete message to the router")
+	}
+
+	// the header arriving late does not hand the message again
+	node.HandleRPC(0, &HeaderRPC{rpc: &CollectorRPC{size: msg.size, msg: msg}, size: 0})
+	if len(router.published) != 1 {
+		t.Errorf("Handed the message to the router %v times", len(router.published))
+	}
+}
+
+// the header arrives and a chunk is lost
+func TestLostChunk(t *testing.T) {
+	node, router := newRecordedNode(t)
+	msg := &CollectorMsg{from: 0, seqno: 1, size: 3 * MaxPayloadSize}
+	msgID := MsgID{From: 0, Seqno: 1}
+
+	// the router forwards the message on the arrival of the header
+	node.HandleRPC(0, &HeaderRPC{rpc: &CollectorRPC{size: msg.size, msg: msg}, size: 0})
+	for _, index := range []int64{0, 2} {
+		node.HandleRPC(0, newChunkRPC(msg, index))
+	}
+	if len(router.published) != 1 {
+		t.Fatalf("Handed the message to the router %v times", len(router.published))
+	}
+	// the router may still request the message, ex: with IWANT
+	if node.SeenMsgs.SeenMsg(msgID) {
+		t.Fatalf("Marked the message with a missing chunk as seen")
+	}
+
+	// the message is recovered from another peer
+	node.HandleRPC(2, &HeaderRPC{rpc: &CollectorRPC{size: msg.size, msg: msg}, size: 0})
+	node.HandleRPC(2, newChunkRPC(msg, 1))
+	if !node.SeenMsgs.SeenMsg(msgID) || len(router.published) != 1 {
+		t.Errorf("Seen: %v, handed to the router %v times", node.SeenMsgs.SeenMsg(msgID), len(router.published))
+	}
+}
@@ -26,17 +26,11 @@ type StatCollector struct {
 	// duration after which messages are retired
 	seenTTL time.Duration
 
-	// compute final stats here
-	curStats core.Stats
+	// stats of all the messages, control messages count as overhead here
+	overall *statAccumulator
 
-	// count of retired messages
-	msgCount int64
-
-	// packets are counted during the send event
-	totalPacketCount int64
-
-	// bytes are counted during the send event
-	totalBytesTransferred int64
+	// topic -> stats of the messages published on the topic
+	topics map[string]*statAccumulator
 
 	// populated the first time the message is encountered
 	// entries retired on expiry
@@ -52,15 +46,53 @@ type StatCollector struct {
 	// Messages automatically retired on expiry
 	remNodesPerMsg map[MsgID]*core.Set
 
+	// MsgID -> number of nodes expected to receive the message
+	// Nodes offline or not subscribed to the topic when the message was published
+	//   or leaving or unsubscribing before receiving it are not expected to receive it
+	// Messages automatically retired on expiry
+	numTargetsPerMsg map[MsgID]int
+
+	// each entry represents the mean delay in milliseconds over the chunks received by each node
+	// entries retired on expiry
+	chunkDelayMsPerMsg map[MsgID]*core.MeanStat
+
+	// MsgID -> node ID -> set of indices of the chunks received by the node
+	// entries of a node removed once the node receives enough chunks to complete the message
+	// messages retired on expiry
+	chunksPerMsg map[MsgID]map[int64]*core.Set
+
 	// messages sorted by the non-decreasing order of their origin times
 	chronoMsgs []*ChronoMsg
 
-	// list of all nodes populated at the origin of each message
-	nodeIDs *core.Set
+	// topic -> set of online nodes subscribed to the topic
+	// the nodes expected to receive a message are taken from here at the origin of the message
+	subscribers map[string]*core.Set
+}
+
+// Stats accumulated over a set of messages
+type statAccumulator struct {
+	// compute final stats here
+	curStats core.Stats
+
+	// count of retired messages
+	msgCount int64
+
+	// packets are counted during the send event
+	totalPacketCount int64
+
+	// bytes are counted during the send event
+	totalBytesTransferred int64
+
+	// bytes of the duplicates are counted during the receive event
+	totalDuplicateBytes int64
+
+	// chunks are counted during the send event
+	totalChunkCount int64
 }
 
 type ChronoMsg struct {
 	msgID      MsgID
+	topic      string
 	originTime time.Time
 }
 
@@ -72,15 +104,17 @@ func NewStatCollector(seenTTL time.Duration) (*StatCollector, error) {
 	}
 
 	collector := &StatCollector{
-		curStats:              core.Stats{},
-		totalPacketCount:      0,
-		totalBytesTransferred: 0,
-		originTimePerMsg:      map[MsgID]time.Time{},
-		delayMsPerMsg:         map[MsgID]*core.MeanStat{},
-		remNodesPerMsg:        map[MsgID]*core.Set{},
-		chronoMsgs:            []*ChronoMsg{},
-		nodeIDs:               core.NewSet(),
-		seenTTL:               seenTTL,
+		overall:            &statAccumulator{},
+		topics:             map[string]*statAccumulator{},
+		originTimePerMsg:   map[MsgID]time.Time{},
+		delayMsPerMsg:      map[MsgID]*core.MeanStat{},
+		remNodesPerMsg:     map[MsgID]*core.Set{},
+		numTargetsPerMsg:   map[MsgID]int{},
+		chunkDelayMsPerMsg: map[MsgID]*core.MeanStat{},
+		chunksPerMsg:       map[MsgID]map[int64]*core.Set{},
+		chronoMsgs:         []*ChronoMsg{},
+		subscribers:        map[string]*core.Set{},
+		seenTTL:            seenTTL,
 	}
 	return collector, nil
 }
@@ -88,58 +122,45 @@ func NewStatCollector(seenTTL time.Duration) (*StatCollector, error) {
 // Called after simulation run is complete and typically only once
 // Returns the final stats after collecting stats from send and receive operations
 func (collector *StatCollector) GetFinalStats() core.Stats {
-	// retire all messages
-	collector.msgCount += int64(len(collector.originTimePerMsg))
-
-	// Collect packet count stats
-	collector.curStats.PacketCountPerMsg = core.MeanStat{
-		Count: collector.msgCount,
-		Value: float64(collector.totalPacketCount) / float64(collector.msgCount),
-	}
-
-	// Collect traffic stats
-	collector.curStats.TrafficPerMsg = core.MeanStat{
-		Count: collector.msgCount,
-		Value: float64(collector.totalBytesTransferred) / float64(collector.msgCount),
+	// Messages are retired in chronological order (and not by ranging over the maps)
+	//   since floating point sums depend on the order of addition
+	for _, chronoMsg := range collector.chronoMsgs {
+		collector.retireMsg(chronoMsg)
 	}
 
-	// Collect message delays
-	for _, meanDur := range collector.delayMsPerMsg {
-		collector.curStats.DelayMsPerMsg.AddMeanStat(meanDur)
+	stats := collector.overall.getStats()
+	stats.Topics = map[string]core.Stats{}
+	for topic, topicStats := range collector.topics {
+		stats.Topics[topic] = topicStats.getStats()
 	}
-
-	// Collect reachbility stats
-	for _, remNodes := range collector.remNodesPerMsg {
-		// We subtract one in the denominator to exclude the originator of the message
-		remRatio := float64(remNodes.Len()) / float64(collector.nodeIDs.Len()-1)
-		deliveredRatio := 1.0 - remRatio
-		collector.curStats.DeliveredPart.AddValue(100.0 * deliveredRatio)
-	}
-
-	// We make a copy to clear the curStats field
-	stats := collector.curStats
 	collector.clear()
 	return stats
 }
 
 // Reset the stats
 func (collector *StatCollector) clear() {
-	collector.curStats = core.Stats{}
-	collector.msgCount = 0
-	collector.totalPacketCount = 0
-	collector.totalBytesTransferred = 0
+	collector.overall = &statAccumulator{}
+	collector.topics = map[string]*statAccumulator{}
 	collector.originTimePerMsg = map[MsgID]time.Time{}
 	collector.delayMsPerMsg = map[MsgID]*core.MeanStat{}
 	collector.remNodesPerMsg = map[MsgID]*core.Set{}
+	collector.numTargetsPerMsg = map[MsgID]int{}
+	collector.chunkDelayMsPerMsg = map[MsgID]*core.MeanStat{}
+	collector.chunksPerMsg = map[MsgID]map[int64]*core.Set{}
 	collector.chronoMsgs = []*ChronoMsg{}
-	collector.nodeIDs = core.NewSet()
+	collector.subscribers = map[string]*core.Set{}
 }
 
 // Called to collect stats on message/packet send
 func (collector *StatCollector) CollectSendStats(srcID int64, rpcMsg RPC, curTime time.Time) {
 	newMsgAlreadyFound := false
 
-	for _, msg := range rpcMsg.GetMessages() {
+	msgs := rpcMsg.GetMessages()
+	// chunks of coded messages carry no complete message
+	if chunk, ok := rpcMsg.(Chunk); ok {
+		msgs = []Message{chunk.Msg()}
+	}
+	for _, msg := range msgs {
 		msgID := MsgID{
 			From:  msg.From(),
 			Seqno: msg.Seqno(),
@@ -158,28 +179,48 @@ func (collector *StatCollector) CollectSendStats(srcID int64, rpcMsg RPC, curTim
 			collector.originTimePerMsg[msgID] = curTime
 			collector.chronoMsgs = append(collector.chronoMsgs, &ChronoMsg{
 				msgID:      msgID,
+				topic:      msg.Topic(),
 				originTime: curTime,
 			})
 
 			// Delay calculated on the receiving end
 			collector.delayMsPerMsg[msgID] = &core.MeanStat{}
+			collector.chunkDelayMsPerMsg[msgID] = &core.MeanStat{}
+			collector.chunksPerMsg[msgID] = map[int64]*core.Set{}
 
-			// Add all the nodes (except src) to the remaining nodes set
+			// Add all the subscribers of the topic (except src) to the remaining nodes set
 			// Delivered percentage calculated on retiring messages
 			// Messages are removed from the set whenever the appropriate node receives the message
-			collector.remNodesPerMsg[msgID] = collector.excludeSource(srcID)
+			collector.remNodesPerMsg[msgID] = collector.excludeSource(srcID, msg.Topic())
+			collector.numTargetsPerMsg[msgID] = collector.remNodesPerMsg[msgID].Len()
 		}
+
+		// the stats of a topic only count the messages of the topic
+		// chunks carry only a part of the message
+		topicStats := collector.getTopicStats(msg.Topic())
+		msgSize := msg.GetSize()
+		if chunk, ok := rpcMsg.(Chunk); ok {
+			msgSize = chunk.GetSize()
+			topicStats.totalChunkCount++
+			collector.overall.totalChunkCount++
+		}
+		topicStats.totalPacketCount += getPacketCount(msgSize)
+		topicStats.totalBytesTransferred += GetWireSize(msgSize)
 	}
 
 	rpcMsgSize := rpcMsg.GetSize()
-	packetCount := getPacketCount(rpcMsgSize)
 	// replies are not counted here
-	collector.totalPacketCount += packetCount
-	collector.totalBytesTransferred += packetCount*RPCOverhead + rpcMsgSize
+	collector.overall.totalPacketCount += getPacketCount(rpcMsgSize)
+	collector.overall.totalBytesTransferred += GetWireSize(rpcMsgSize)
 }
 
 // Called to collect stats on message/packet receive
 func (collector *StatCollector) CollectRecvStats(dstID int64, rpcMsg RPC, curTime time.Time) {
+	if chunk, ok := rpcMsg.(Chunk); ok {
+		collector.collectChunkRecvStats(dstID, chunk, curTime)
+		return
+	}
+
 	for _, msg := range rpcMsg.GetMessages() {
 		var exists bool
 
@@ -189,11 +230,16 @@ func (collector *StatCollector) CollectRecvStats(dstID int64, rpcMsg RPC, curTim
 		}
 
 		remNodes, exists := collector.remNodesPerMsg[msgID]
-		if !exists || !remNodes.Exists(dstID) {
-			// This particular message is
-			// - Either never seen, globally
-			// - Or already seen on this particular node
-			return
+		if !exists {
+			// This particular message is either never seen globally or already retired
+			continue
+		}
+		if !remNodes.Exists(dstID) {
+			// This particular message is already seen on this particular node (or the node is not a target)
+			duplicateBytes := GetWireSize(msg.GetSize())
+			collector.overall.totalDuplicateBytes += duplicateBytes
+			collector.getTopicStats(msg.Topic()).totalDuplicateBytes += duplicateBytes
+			continue
 		}
 
 		// Remove the receiver from the set for the delivery stat
@@ -208,35 +254,136 @@ func (collector *StatCollector) CollectRecvStats(dstID int64, rpcMsg RPC, curTim
 	}
 }
 
-func (collector *StatCollector) AddNode(nodeID int64) {
-	collector.nodeIDs.Add(nodeID)
+// A message sent as chunks is delivered once the node receives enough distinct chunks
+func (collector *StatCollector) collectChunkRecvStats(dstID int64, chunk Chunk, curTime time.Time) {
+	msg := chunk.Msg()
+	msgID := MsgID{
+		From:  msg.From(),
+		Seqno: msg.Seqno(),
+	}
+
+	remNodes, exists := collector.remNodesPerMsg[msgID]
+	if !exists {
+		// This particular message is either never seen globally or already retired
+		return
+	}
+	chunks, exists := collector.chunksPerMsg[msgID][dstID]
+	if !remNodes.Exists(dstID) || (exists && chunks.Exists(chunk.Index())) {
+		// This particular chunk is already seen on this particular node (or the node is not a target)
+		duplicateBytes := GetWireSize(chunk.GetSize())
+		collector.overall.totalDuplicateBytes += duplicateBytes
+		collector.getTopicStats(msg.Topic()).totalDuplicateBytes += duplicateBytes
+		return
+	}
+	// the nodes done with the message are not tracked
+	if !exists {
+		chunks = core.NewSet()
+		collector.chunksPerMsg[msgID][dstID] = chunks
+	}
+	chunks.Add(chunk.Index())
+
+	delay := curTime.Sub(collector.originTimePerMsg[msgID]).Milliseconds()
+	collector.chunkDelayMsPerMsg[msgID].AddValue(float64(delay))
+	if int64(chunks.Len()) < chunk.ChunksNeeded() {
+		return
+	}
+
+	// the message is reassembled or decoded
+	remNodes.Remove(dstID)
+	delete(collector.chunksPerMsg[msgID], dstID)
+	collector.delayMsPerMsg[msgID].AddValue(float64(delay))
+}
+
+func (collector *StatCollector) Subscribe(nodeID int64, topic string) {
+	if _, exists := collector.subscribers[topic]; !exists {
+		collector.subscribers[topic] = core.NewSet()
+	}
+	collector.subscribers[topic].Add(nodeID)
+}
+
+// Nodes that unsubscribe before receiving a message of the topic are not expected to receive it
+func (collector *StatCollector) Unsubscribe(nodeID int64, topic string) {
+	if subscribers, exists := collector.subscribers[topic]; exists {
+		subscribers.Remove(nodeID)
+	}
+	for _, chronoMsg := range collector.chronoMsgs {
+		if chronoMsg.topic == topic {
+			collector.removeTarget(nodeID, chronoMsg.msgID)
+		}
+	}
+}
+
+// Nodes that leave the network before receiving a message are not expected to receive it
+func (collector *StatCollector) RemoveNode(nodeID int64) {
+	for _, subscribers := range collector.subscribers {
+		subscribers.Remove(nodeID)
+	}
+	for _, chronoMsg := range collector.chronoMsgs {
+		collector.removeTarget(nodeID, chronoMsg.msgID)
+	}
+}
+
+func (collector *StatCollector) removeTarget(nodeID int64, msgID MsgID) {
+	remNodes := collector.remNodesPerMsg[msgID]
+	if remNodes.Exists(nodeID) {
+		remNodes.Remove(nodeID)
+		collector.numTargetsPerMsg[msgID]--
+	}
 }
 
 func (collector *StatCollector) retireOldMsgs(curTime time.Time) {
 	// go back seenTTL
 	oldestValidTime := curTime.Add(-1 * collector.seenTTL)
 	for 0 < len(collector.chronoMsgs) && collector.chronoMsgs[0].originTime.Before(oldestValidTime) {
-		msgID := collector.chronoMsgs[0].msgID
-
-		collector.msgCount++
-
-		delete(collector.originTimePerMsg, msgID)
+		collector.retireMsg(collector.chronoMsgs[0])
 		// first element garbage collected on reallocation
 		collector.chronoMsgs = collector.chronoMsgs[1:]
+	}
+}
 
-		collector.curStats.DelayMsPerMsg.AddMeanStat(collector.delayMsPerMsg[msgID])
-		delete(collector.delayMsPerMsg, msgID)
+// Collects the stats of the message in both the overall stats and the stats of its topic
+func (collector *StatCollector) retireMsg(chronoMsg *ChronoMsg) {
+	msgID := chronoMsg.msgID
+	for _, accumulator := range []*statAccumulator{collector.overall, collector.getTopicStats(chronoMsg.topic)} {
+		accumulator.msgCount++
+		accumulator.curStats.DelayMsPerMsg.AddMeanStat(collector.delayMsPerMsg[msgID])
+		accumulator.curStats.ChunkDelayMsPerMsg.AddMeanStat(collector.chunkDelayMsPerMsg[msgID])
+		collector.collectDeliveredPart(accumulator, msgID)
+	}
+
+	delete(collector.originTimePerMsg, msgID)
+	delete(collector.delayMsPerMsg, msgID)
+	delete(collector.remNodesPerMsg, msgID)
+	delete(collector.numTargetsPerMsg, msgID)
+	delete(collector.chunkDelayMsPerMsg, msgID)
+	delete(collector.chunksPerMsg, msgID)
+}
+
+func (collector *StatCollector) collectDeliveredPart(accumulator *statAccumulator, msgID MsgID) {
+	numTargets := collector.numTargetsPerMsg[msgID]
+	// messages published while no other node is subscribed cannot be delivered
+	if numTargets == 0 {
+		return
+	}
+	remRatio := float64(collector.remNodesPerMsg[msgID].Len()) / float64(numTargets)
+	deliveredRatio := 1.0 - remRatio
+	accumulator.curStats.DeliveredPart.AddValue(100.0 * deliveredRatio)
+}
 
-		remRatio := float64(collector.remNodesPerMsg[msgID].Len()) / float64(collector.nodeIDs.Len()-1)
-		deliveredRatio := 1.0 - remRatio
-		collector.curStats.DeliveredPart.AddValue(100.0 * deliveredRatio)
-		delete(collector.remNodesPerMsg, msgID)
+func (collector *StatCollector) getTopicStats(topic string) *statAccumulator {
+	if _, exists := collector.topics[topic]; !exists {
+		collector.topics[topic] = &statAccumulator{}
 	}
+	return collector.topics[topic]
 }
 
-func (collector *StatCollector) excludeSource(srcID int64) *core.Set {
+func (collector *StatCollector) excludeSource(srcID int64, topic string) *core.Set {
 	nodeSet := core.NewSet()
-	collector.nodeIDs.Traverse(func(iNodeID interface{}) {
+	subscribers, exists := collector.subscribers[topic]
+	if !exists {
+		return nodeSet
+	}
+	subscribers.Traverse(func(iNodeID interface{}) {
 		nodeID := iNodeID.(int64)
 		if nodeID != srcID {
 			nodeSet.Add(nodeID)
@@ -245,6 +392,34 @@ func (collector *StatCollector) excludeSource(srcID int64) *core.Set {
 	return nodeSet
 }
 
+// Packet count, traffic, duplicate traffic and chunk count are averaged over the retired messages
+func (accumulator *statAccumulator) getStats() core.Stats {
+	stats := accumulator.curStats
+	stats.PacketCountPerMsg = core.MeanStat{
+		Count: accumulator.msgCount,
+		Value: float64(accumulator.totalPacketCount) / float64(accumulator.msgCount),
+	}
+	stats.TrafficPerMsg = core.MeanStat{
+		Count: accumulator.msgCount,
+		Value: float64(accumulator.totalBytesTransferred) / float64(accumulator.msgCount),
+	}
+	stats.DuplicateTrafficPerMsg = core.MeanStat{
+		Count: accumulator.msgCount,
+		Value: float64(accumulator.totalDuplicateBytes) / float64(accumulator.msgCount),
+	}
+	stats.ChunkCountPerMsg = core.MeanStat{
+		Count: accumulator.msgCount,
+		Value: float64(accumulator.totalChunkCount) / float64(accumulator.msgCount),
+	}
+	return stats
+}
+
 func getPacketCount(rpcMsgSize int64) int64 {
 	return (rpcMsgSize + MaxPayloadSize - 1) / MaxPayloadSize
 }
+
+// Bytes transferred over the wire for an RPC including the overhead of every packet
+// Also determines the transmission delay of the RPC (see bandwidth.go)
+func GetWireSize(rpcMsgSize int64) int64 {
+	return getPacketCount(rpcMsgSize)*RPCOverhead + rpcMsgSize
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 )
 
+const testTopic = "test"
+
 type CollectorRPC struct {
 	size int64
 	msg  *CollectorMsg
@@ -15,6 +17,9 @@ type CollectorRPC struct {
 type CollectorMsg struct {
 	from  int64
 	seqno int64
+	// testTopic if unspecified
+	topic string
+	size  int64
 }
 
 func (rpcMsg *CollectorRPC) GetSize() int64 {
@@ -22,11 +27,14 @@ func (rpcMsg *CollectorRPC) GetSize() int64 {
 }
 
 func (rpcMsg *CollectorRPC) GetMessages() []Message {
+	if rpcMsg.msg == nil {
+		return []Message{}
+	}
 	return []Message{rpcMsg.msg}
 }
 
 func (msg *CollectorMsg) GetSize() int64 {
-	return 0
+	return msg.size
 }
 
 func (msg *CollectorMsg) From() int64 {
@@ -37,6 +45,13 @@ func (msg *CollectorMsg) Seqno() int64 {
 	return msg.seqno
 }
 
+func (msg *CollectorMsg) Topic() string {
+	if msg.topic == "" {
+		return testTopic
+	}
+	return msg.topic
+}
+
 func TestNegTTL(t *testing.T) {
 	_, err := NewStatCollector(-1 * time.Second)
 	if !errors.Is(err, NegTTLErr) {
@@ -52,7 +67,7 @@ func TestHalfRecv(t *testing.T) {
 
 	nodeIDs := []int64{16, 8, 24}
 	for _, nodeID := range nodeIDs {
-		collector.AddNode(nodeID)
+		collector.Subscribe(nodeID, testTopic)
 	}
 
 	tolerance := 1e-6
@@ -94,7 +109,7 @@ func TestForward(t *testing.T) {
 
 	nodeIDs := []int64{22, 11, 34}
 	for _, nodeID := range nodeIDs {
-		collector.AddNode(nodeID)
+		collector.Subscribe(nodeID, testTopic)
 	}
 
 	tolerance := 1e-6
@@ -111,6 +126,7 @@ func TestForward(t *testing.T) {
 			msg: &CollectorMsg{
 				from:  nodeIDs[0],
 				seqno: 36,
+				size:  rpcMsgSize,
 			},
 		}
 
@@ -133,6 +149,8 @@ func TestForward(t *testing.T) {
 	// avg delay: 200ms
 	packetCount := int64(3)
 	traffic := packetCount * packetSize
+	// B receives the retransmission again
+	duplicateTraffic := packetSize
 	avgDelay := float64(firstDelay) + float64(secondDelay)/2
 	deliveredPart := 100.0
 
@@ -144,6 +162,10 @@ func TestForward(t *testing.T) {
 		t.Errorf("traffic value: %v", stats.TrafficPerMsg.Value)
 	}
 
+	if math.Abs(stats.DuplicateTrafficPerMsg.Value-float64(duplicateTraffic)) > tolerance {
+		t.Errorf("duplicate traffic value: %v", stats.DuplicateTrafficPerMsg.Value)
+	}
+
 	if math.Abs(stats.DelayMsPerMsg.Value-avgDelay) > tolerance {
 		t.Errorf("delay value: %v", stats.DelayMsPerMsg.Value)
 	}
@@ -159,7 +181,7 @@ func TestCacheEviction(t *testing.T) {
 
 	nodeIDs := []int64{13, 40}
 	for _, nodeID := range nodeIDs {
-		collector.AddNode(nodeID)
+		collector.Subscribe(nodeID, testTopic)
 	}
 
 	tolerance := 1e-6
@@ -209,3 +231,142 @@ func TestCacheEviction(t *testing.T) {
 		t.Errorf("delivered part value: %v", stats.DeliveredPart.Value)
 	}
 }
+
+// only the nodes online at the time of publishing and yet to leave are expected to receive the message
+func TestChurnRecv(t *testing.T) {
+	collector, _ := NewStatCollector(time.Hour)
+
+	nodeIDs := []int64{5, 6, 7, 8}
+	for _, nodeID := range nodeIDs[:3] {
+		collector.Subscribe(nodeID, testTopic)
+	}
+
+	tolerance := 1e-6
+	rpcMsg := &CollectorRPC{
+		size: 1_000,
+		msg: &CollectorMsg{
+			from:  nodeIDs[0],
+			seqno: 1,
+		},
+	}
+	sendTime := time.Time{}
+	recvTime := sendTime.Add(100 * time.Millisecond)
+
+	collector.CollectSendStats(nodeIDs[0], rpcMsg, sendTime)
+	// joins after the message is published
+	collector.Subscribe(nodeIDs[3], testTopic)
+	// leaves before receiving the message
+	collector.RemoveNode(nodeIDs[2])
+	collector.CollectRecvStats(nodeIDs[1], rpcMsg, recvTime)
+	collector.CollectRecvStats(nodeIDs[3], rpcMsg, recvTime)
+	stats := collector.GetFinalStats()
+
+	if math.Abs(stats.DeliveredPart.Value-100) > tolerance {
+		t.Errorf("delivered part value: %v", stats.DeliveredPart.Value)
+	}
+	if stats.DelayMsPerMsg.Count != 1 {
+		t.Errorf("delay count: %v", stats.DelayMsPerMsg.Count)
+	}
+}
+
+// only the subscribers of the topic are expected to receive its messages
+// control RPCs count only towards the overall stats
+func TestTopicStats(t *testing.T) {
+	collector, _ := NewStatCollector(time.Hour)
+
+	nodeIDs := []int64{1, 2, 3}
+	for _, nodeID := range nodeIDs {
+		collector.Subscribe(nodeID, "blocks")
+	}
+	collector.Subscribe(nodeIDs[0], "txs")
+	collector.Subscribe(nodeIDs[1], "txs")
+
+	tolerance := 1e-6
+	blockRPC := &CollectorRPC{
+		size: 2_000,
+		msg:  &CollectorMsg{from: nodeIDs[0], seqno: 1, topic: "blocks", size: 2_000},
+	}
+	txRPC := &CollectorRPC{
+		size: 100,
+		msg:  &CollectorMsg{from: nodeIDs[0], seqno: 2, topic: "txs", size: 100},
+	}
+	controlRPC := &CollectorRPC{
+		size: 10,
+	}
+	sendTime := time.Time{}
+	recvTime := sendTime.Add(100 * time.Millisecond)
+
+	collector.CollectSendStats(nodeIDs[0], blockRPC, sendTime)
+	collector.CollectSendStats(nodeIDs[0], txRPC, sendTime)
+	collector.CollectSendStats(nodeIDs[0], controlRPC, sendTime)
+	collector.CollectRecvStats(nodeIDs[1], blockRPC, recvTime)
+	collector.CollectRecvStats(nodeIDs[1], txRPC, recvTime)
+	stats := collector.GetFinalStats()
+
+	if len(stats.Topics) != 2 {
+		t.Fatalf("stats of %v topics, expected 2", len(stats.Topics))
+	}
+	blockStats, txStats := stats.Topics["blocks"], stats.Topics["txs"]
+	if math.Abs(blockStats.DeliveredPart.Value-50) > tolerance {
+		t.Errorf("blocks delivered part value: %v", blockStats.DeliveredPart.Value)
+	}
+	if math.Abs(txStats.DeliveredPart.Value-100) > tolerance {
+		t.Errorf("txs delivered part value: %v", txStats.DeliveredPart.Value)
+	}
+	if blockStats.PacketCountPerMsg.Value != 2 || txStats.PacketCountPerMsg.Value != 1 {
+		t.Errorf("packet count values: %v, %v", blockStats.PacketCountPerMsg.Value, txStats.PacketCountPerMsg.Value)
+	}
+	if txStats.TrafficPerMsg.Value != float64(100+RPCOverhead) {
+		t.Errorf("txs traffic value: %v", txStats.TrafficPerMsg.Value)
+	}
+
+	// the control RPC is overhead for both messages
+	traffic := float64(2_000+2*RPCOverhead+100+RPCOverhead+10+RPCOverhead) / 2
+	if math.Abs(stats.TrafficPerMsg.Value-traffic) > tolerance {
+		t.Errorf("traffic value: %v", stats.TrafficPerMsg.Value)
+	}
+	if stats.DelayMsPerMsg.Count != 2 {
+		t.Errorf("delay count: %v", stats.DelayMsPerMsg.Count)
+	}
+}
+
+// a message sent as chunks is delivered with its last chunk
+func TestChunkRecv(t *testing.T) {
+	collector, _ := NewStatCollector(time.Hour)
+
+	nodeIDs := []int64{5, 6}
+	for _, nodeID := range nodeIDs {
+		collector.Subscribe(nodeID, testTopic)
+	}
+
+	tolerance := 1e-6
+	msg := &CollectorMsg{from: nodeIDs[0], seqno: 1, size: 3 * MaxPayloadSize}
+	sendTime := time.Time{}
+	for index := int64(0); index < 3; index++ {
+		chunk := newChunkRPC(msg, index)
+		collector.CollectSendStats(nodeIDs[0], chunk, sendTime)
+		collector.CollectRecvStats(nodeIDs[1], chunk, sendTime.Add(time.Duration(100*(index+1))*time.Millisecond))
+	}
+	// the first chunk again
+	collector.CollectRecvStats(nodeIDs[1], newChunkRPC(msg, 0), sendTime.Add(400*time.Millisecond))
+	if _, exists := collector.chunksPerMsg[MsgID{From: nodeIDs[0], Seqno: 1}][nodeIDs[1]]; exists {
+		t.Errorf("Kept the chunks of the node done with the message")
+	}
+	stats := collector.GetFinalStats()
+
+	if math.Abs(stats.DeliveredPart.Value-100) > tolerance {
+		t.Errorf("delivered part value: %v", stats.DeliveredPart.Value)
+	}
+	if math.Abs(stats.DelayMsPerMsg.Value-300) > tolerance {
+		t.Errorf("delay ms value: %v", stats.DelayMsPerMsg.Value)
+	}
+	if math.Abs(stats.ChunkDelayMsPerMsg.Value-200) > tolerance {
+		t.Errorf("chunk delay ms value: %v", stats.ChunkDelayMsPerMsg.Value)
+	}
+	if stats.ChunkCountPerMsg.Value != 3 {
+		t.Errorf("chunk count value: %v", stats.ChunkCountPerMsg.Value)
+	}
+	if stats.DuplicateTrafficPerMsg.Value != float64(GetWireSize(MaxPayloadSize)) {
+		t.Errorf("duplicate traffic value: %v", stats.DuplicateTrafficPerMsg.Value)
+	}
+}
@@ -0,0 +1,340 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"go.uber.org/zap"
+	exprand "golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Messages may be lost or held up by faults in the network
+// - loss: an RPC transmitted over a link is lost with the loss probability of the link
+// - link failures: a link is down from the scheduled down time until the up time
+// - partitions: a named set of nodes is split from the rest of the network from the start time until the end time
+//     in the drop mode, RPCs across the partition are dropped
+//     in the delay mode, RPCs across the partition are held by the sender and transmitted once the partition heals
+// RPCs over failed links or across partitions in the drop mode are dropped without being transmitted
+// Faults are checked when an RPC is sent, RPCs already in flight are unaffected
+// Dropped RPCs are still counted in the traffic stats since the router sent them
+// Times are measured from the start of the simulation
+
+const (
+	// partition modes
+	DropMode  = "drop"
+	DelayMode = "delay"
+)
+
+var (
+	InvLossProbErr          = errors.New("Configured an invalid loss probability!")
+	InvLinkFailureErr       = errors.New("Configured an invalid link failure!")
+	InvPartitionErr         = errors.New("Configured an invalid partition!")
+	UnknownPartitionModeErr = errors.New("Could not recognize the partition mode!")
+	UnknownNodeErr          = errors.New("Configured a fault for an unknown node!")
+)
+
+type FaultConfig struct {
+	// Loss probability of every link
+	LossProb *float64 `toml:"loss_prob,omitempty"`
+
+	LinkFailures []*LinkFailureConfig `toml:"link_failures,omitempty"`
+
+	Partitions []*PartitionConfig `toml:"partitions,omitempty"`
+}
+
+type LinkFailureConfig struct {
+	// IDs of the two endpoints of the link
+	Link []int64 `toml:"link"`
+
+	Down *time.Duration `toml:"down"`
+
+	// The link stays down until the end if unspecified
+	Up *time.Duration `toml:"up,omitempty"`
+}
+
+type PartitionConfig struct {
+	// Identifies the partition in the logs
+	Name *string `toml:"name"`
+
+	// Nodes split from the rest of the network
+	// Either the IDs of the nodes or the fraction of nodes chosen at random must be configured
+	Nodes    []int64  `toml:"nodes,omitempty"`
+	Fraction *float64 `toml:"fraction,omitempty"`
+
+	Start *time.Duration `toml:"start"`
+
+	// The partition never heals if unspecified
+	End *time.Duration `toml:"end,omitempty"`
+
+	// Either drop (default) or delay
+	Mode *string `toml:"mode,omitempty"`
+}
+
+type faultModel struct {
+	lossProb float64
+	// loss probabilities of specific links overriding lossProb
+	linkLossProbs map[linkKey]float64
+	lossDist      *distuv.Uniform
+
+	// link -> number of overlapping failures the link is down for
+	downLinks map[linkKey]int
+
+	partitions []*partition
+
+	rng exprand.Source
+}
+
+type partition struct {
+	name    string
+	nodeIDs *core.Set
+	mode    string
+	// zero if the partition never heals
+	healTime time.Time
+	active   bool
+}
+
+type linkFailureEvent struct {
+	net  *Network
+	link linkKey
+	down bool
+}
+
+type partitionEvent struct {
+	net       *Network
+	partition *partition
+	active    bool
+}
+
+func GetDefaultFaultConfig() *FaultConfig {
+	lossProb := 0.0
+	return &FaultConfig{
+		LossProb: &lossProb,
+	}
+}
+
+func newFaultModel(rng exprand.Source) *faultModel {
+	return &faultModel{
+		linkLossProbs: map[linkKey]float64{},
+		lossDist: &distuv.Uniform{
+			Min: 0,
+			Max: 1,
+			Src: rng,
+		},
+		downLinks:  map[linkKey]int{},
+		partitions: []*partition{},
+		rng:        rng,
+	}
+}
+
+// Sets up the configured faults
+// Must be called after adding the nodes and before running the simulation
+func (net *Network) ConfigureFaults(cfg *FaultConfig) error {
+	if cfg.LossProb != nil {
+		if *cfg.LossProb < 0 || *cfg.LossProb > 1 {
+			return InvLossProbErr
+		}
+		net.faults.lossProb = *cfg.LossProb
+	}
+
+	for _, failureCfg := range cfg.LinkFailures {
+		if err := net.scheduleLinkFailure(failureCfg); err != nil {
+			return err
+		}
+	}
+
+	for _, partitionCfg := range cfg.Partitions {
+		if err := net.schedulePartition(partitionCfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets the loss probability of the link in both directions
+func (net *Network) SetLinkLoss(nodeID int64, otherID int64, lossProb float64) error {
+	if lossProb < 0 || lossProb > 1 {
+		return InvLossProbErr
+	}
+	net.faults.linkLossProbs[newLinkKey(nodeID, otherID)] = lossProb
+	return nil
+}
+
+func (net *Network) scheduleLinkFailure(cfg *LinkFailureConfig) error {
+	if len(cfg.Link) != 2 || cfg.Link[0] == cfg.Link[1] {
+		return fmt.Errorf("%w: a link must have two distinct endpoints", InvLinkFailureErr)
+	}
+	for _, nodeID := range cfg.Link {
+		if _, exists := net.nodes[nodeID]; !exists {
+			return fmt.Errorf("%w: %v", UnknownNodeErr, nodeID)
+		}
+	}
+	if cfg.Down == nil || *cfg.Down < 0 {
+		return fmt.Errorf("%w: down time must not be negative", InvLinkFailureErr)
+	}
+	if cfg.Up != nil && *cfg.Up < *cfg.Down {
+		return fmt.Errorf("%w: up time must not precede the down time", InvLinkFailureErr)
+	}
+
+	link := newLinkKey(cfg.Link[0], cfg.Link[1])
+	net.sched.Schedule(*cfg.Down, &linkFailureEvent{
+		net:  net,
+		link: link,
+		down: true,
+	})
+	if cfg.Up != nil {
+		net.sched.Schedule(*cfg.Up, &linkFailureEvent{
+			net:  net,
+			link: link,
+			down: false,
+		})
+	}
+	return nil
+}
+
+func (net *Network) schedulePartition(cfg *PartitionConfig) error {
+	if cfg.Name == nil {
+		return fmt.Errorf("%w: unnamed partition", InvPartitionErr)
+	}
+	mode := DropMode
+	if cfg.Mode != nil {
+		mode = *cfg.Mode
+	}
+	if mode != DropMode && mode != DelayMode {
+		return fmt.Errorf("%w: %v", UnknownPartitionModeErr, mode)
+	}
+	if cfg.Start == nil || *cfg.Start < 0 {
+		return fmt.Errorf("%w: %v: start time must not be negative", InvPartitionErr, *cfg.Name)
+	}
+	if cfg.End != nil && *cfg.End < *cfg.Start {
+		return fmt.Errorf("%w: %v: end time must not precede the start time", InvPartitionErr, *cfg.Name)
+	}
+	if cfg.End == nil && mode == DelayMode {
+		return fmt.Errorf("%w: %v: messages cannot be delayed by a partition that never heals", InvPartitionErr, *cfg.Name)
+	}
+
+	nodeIDs, err := net.getPartitionNodes(cfg)
+	if err != nil {
+		return err
+	}
+	part := &partition{
+		name:    *cfg.Name,
+		nodeIDs: nodeIDs,
+		mode:    mode,
+	}
+	if cfg.End != nil {
+		part.healTime = net.sched.CurTime.Add(*cfg.End)
+	}
+	net.faults.partitions = append(net.faults.partitions, part)
+
+	net.sched.Schedule(*cfg.Start, &partitionEvent{
+		net:       net,
+		partition: part,
+		active:    true,
+	})
+	if cfg.End != nil {
+		net.sched.Schedule(*cfg.End, &partitionEvent{
+			net:       net,
+			partition: part,
+			active:    false,
+		})
+	}
+	return nil
+}
+
+func (net *Network) getPartitionNodes(cfg *PartitionConfig) (*core.Set, error) {
+	if (len(cfg.Nodes) == 0) == (cfg.Fraction == nil) {
+		return nil, fmt.Errorf("%w: %v: configure either the nodes or the fraction", InvPartitionErr, *cfg.Name)
+	}
+
+	nodeIDs := core.NewSet()
+	for _, nodeID := range cfg.Nodes {
+		if _, exists := net.nodes[nodeID]; !exists {
+			return nil, fmt.Errorf("%w: %v", UnknownNodeErr, nodeID)
+		}
+		nodeIDs.Add(nodeID)
+	}
+	if cfg.Fraction == nil {
+		return nodeIDs, nil
+	}
+
+	if *cfg.Fraction < 0 || *cfg.Fraction > 1 {
+		return nil, fmt.Errorf("%w: %v: fraction must lie between 0 and 1", InvPartitionErr, *cfg.Name)
+	}
+	// nodes are sorted before shuffling for reproducibility
+	allNodeIDs := make([]int64, 0, len(net.nodes))
+	for nodeID := range net.nodes {
+		allNodeIDs = append(allNodeIDs, nodeID)
+	}
+	sort.Slice(allNodeIDs, func(i, j int) bool {
+		return allNodeIDs[i] < allNodeIDs[j]
+	})
+	numNodes := int(*cfg.Fraction * float64(len(allNodeIDs)))
+	for _, index := range exprand.New(net.faults.rng).Perm(len(allNodeIDs))[:numNodes] {
+		nodeIDs.Add(allNodeIDs[index])
+	}
+	return nodeIDs, nil
+}
+
+// Returns the time the RPC can be transmitted from or false if it must be dropped
+func (faults *faultModel) getSendTime(srcID int64, dstID int64, curTime time.Time) (time.Time, bool) {
+	if faults.downLinks[newLinkKey(srcID, dstID)] > 0 {
+		return time.Time{}, false
+	}
+
+	sendTime := curTime
+	for _, part := range faults.partitions {
+		if !part.active || part.nodeIDs.Exists(srcID) == part.nodeIDs.Exists(dstID) {
+			continue
+		}
+		if part.mode == DropMode {
+			return time.Time{}, false
+		}
+		sendTime = maxTime(sendTime, part.healTime)
+	}
+	return sendTime, true
+}
+
+func (faults *faultModel) isLost(srcID int64, dstID int64) bool {
+	lossProb, exists := faults.linkLossProbs[newLinkKey(srcID, dstID)]
+	if !exists {
+		lossProb = faults.lossProb
+	}
+	// random numbers are not drawn without loss so that the other random draws are unaffected
+	if lossProb == 0 {
+		return false
+	}
+	return faults.lossDist.Rand() < lossProb
+}
+
+func (event *linkFailureEvent) Trigger() {
+	faults := event.net.faults
+	if event.down {
+		faults.downLinks[event.link]++
+	} else {
+		faults.downLinks[event.link]--
+		if faults.downLinks[event.link] == 0 {
+			delete(faults.downLinks, event.link)
+		}
+	}
+	event.net.logger.Debug(
+		"Link failure",
+		zap.Time("CurTime", event.net.sched.CurTime),
+		zap.Int64("nodeID", event.link[0]),
+		zap.Int64("otherID", event.link[1]),
+		zap.Bool("down", event.down),
+	)
+}
+
+func (event *partitionEvent) Trigger() {
+	event.partition.active = event.active
+	event.net.logger.Debug(
+		"Network partition",
+		zap.Time("CurTime", event.net.sched.CurTime),
+		zap.String("name", event.partition.name),
+		zap.Bool("active", event.active),
+	)
+}
@@ -0,0 +1,166 @@
+package pubsub
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+type sendEvent struct {
+	net   *Network
+	srcID int64
+	dstID int64
+	seqno int64
+}
+
+func (event *sendEvent) Trigger() {
+	event.net.SendRPC(event.srcID, event.dstID, &CollectorRPC{
+		size: 1,
+		msg: &CollectorMsg{
+			from:  event.srcID,
+			seqno: event.seqno,
+		},
+	})
+}
+
+func TestLoss(t *testing.T) {
+	sched, net := newTestNetwork(t, 10)
+	recv := &recvTimes{sched: sched}
+	net.AddNode(0, &recvTimes{sched: sched})
+	net.AddNode(1, recv)
+	net.AddNode(2, recv)
+	lossProb := 0.25
+	if err := net.ConfigureFaults(&FaultConfig{LossProb: &lossProb}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the link to 2 is reliable
+	if err := net.SetLinkLoss(2, 0, 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	numRPCs := 4000
+	for i := 0; i < numRPCs; i++ {
+		net.SendRPC(0, 1, &CollectorRPC{size: 1, msg: &CollectorMsg{from: 0, seqno: int64(i)}})
+		net.SendRPC(0, 2, &CollectorRPC{size: 1, msg: &CollectorMsg{from: 0, seqno: int64(i)}})
+	}
+	sched.Run()
+
+	expected := float64(numRPCs) + (1-lossProb)*float64(numRPCs)
+	// 2% tolerance
+	if math.Abs(float64(len(recv.times))-expected) > 0.02*expected {
+		t.Errorf("Received %v RPCs, expected %v", len(recv.times), expected)
+	}
+}
+
+func TestLinkFailure(t *testing.T) {
+	sched, net := newTestNetwork(t, 10)
+	recv := &recvTimes{sched: sched}
+	net.AddNode(0, &recvTimes{sched: sched})
+	net.AddNode(1, recv)
+	down, up := time.Second, 2*time.Second
+	if err := net.ConfigureFaults(&FaultConfig{
+		LinkFailures: []*LinkFailureConfig{{Link: []int64{1, 0}, Down: &down, Up: &up}},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i, after := range []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond, 2500 * time.Millisecond} {
+		sched.Schedule(after, &sendEvent{net: net, srcID: 0, dstID: 1, seqno: int64(i)})
+	}
+	sched.Run()
+
+	expected := []time.Duration{510 * time.Millisecond, 2510 * time.Millisecond}
+	if len(recv.times) != len(expected) {
+		t.Fatalf("Received %v RPCs, expected %v", len(recv.times), len(expected))
+	}
+	for i, recvTime := range recv.times {
+		if recvTime != expected[i] {
+			t.Errorf("RPC %v received at %v, expected %v", i, recvTime, expected[i])
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	for _, testCase := range []struct {
+		mode     string
+		expected []time.Duration
+	}{
+		{DropMode, []time.Duration{510 * time.Millisecond, 1510 * time.Millisecond, 2510 * time.Millisecond}},
+		{DelayMode, []time.Duration{510 * time.Millisecond, 1510 * time.Millisecond, 2010 * time.Millisecond, 2510 * time.Millisecond}},
+	} {
+		sched, net := newTestNetwork(t, 10)
+		recv := &recvTimes{sched: sched}
+		net.AddNode(0, &recvTimes{sched: sched})
+		net.AddNode(1, recv)
+		net.AddNode(2, recv)
+		name := "split"
+		start, end := time.Second, 2*time.Second
+		if err := net.ConfigureFaults(&FaultConfig{
+			Partitions: []*PartitionConfig{{
+				Name:  &name,
+				Nodes: []int64{1},
+				Start: &start,
+				End:   &end,
+				Mode:  &testCase.mode,
+			}},
+		}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// 0 and 2 remain connected throughout
+		sched.Schedule(500*time.Millisecond, &sendEvent{net: net, srcID: 0, dstID: 1, seqno: 1})
+		sched.Schedule(1500*time.Millisecond, &sendEvent{net: net, srcID: 0, dstID: 1, seqno: 2})
+		sched.Schedule(1500*time.Millisecond, &sendEvent{net: net, srcID: 0, dstID: 2, seqno: 3})
+		sched.Schedule(2500*time.Millisecond, &sendEvent{net: net, srcID: 0, dstID: 1, seqno: 4})
+		sched.Run()
+
+		if len(recv.times) != len(testCase.expected) {
+			t.Fatalf("%v: received %v RPCs, expected %v", testCase.mode, len(recv.times), len(testCase.expected))
+		}
+		for i, recvTime := range recv.times {
+			if recvTime != testCase.expected[i] {
+				t.Errorf("%v: RPC %v received at %v, expected %v", testCase.mode, i, recvTime, testCase.expected[i])
+			}
+		}
+	}
+}
+
+func TestInvFaults(t *testing.T) {
+	name := "split"
+	lossProb := 1.5
+	start, end := 2*time.Second, time.Second
+	fraction := 0.5
+	delay := DelayMode
+	unknownMode := "reorder"
+	for _, testCase := range []struct {
+		cfg *FaultConfig
+		err error
+	}{
+		{&FaultConfig{LossProb: &lossProb}, InvLossProbErr},
+		{&FaultConfig{LinkFailures: []*LinkFailureConfig{{Link: []int64{0}, Down: &start}}}, InvLinkFailureErr},
+		{&FaultConfig{LinkFailures: []*LinkFailureConfig{{Link: []int64{0, 1}}}}, InvLinkFailureErr},
+		{&FaultConfig{LinkFailures: []*LinkFailureConfig{{Link: []int64{0, 1}, Down: &start, Up: &end}}}, InvLinkFailureErr},
+		{&FaultConfig{LinkFailures: []*LinkFailureConfig{{Link: []int64{0, 5}, Down: &start}}}, UnknownNodeErr},
+		{&FaultConfig{Partitions: []*PartitionConfig{{Nodes: []int64{0}, Start: &end}}}, InvPartitionErr},
+		{&FaultConfig{Partitions: []*PartitionConfig{{Name: &name, Start: &end}}}, InvPartitionErr},
+		{
+			&FaultConfig{Partitions: []*PartitionConfig{{Name: &name, Nodes: []int64{0}, Fraction: &fraction, Start: &end}}},
+			InvPartitionErr,
+		},
+		{&FaultConfig{Partitions: []*PartitionConfig{{Name: &name, Nodes: []int64{0}, Start: &start, End: &end}}}, InvPartitionErr},
+		{&FaultConfig{Partitions: []*PartitionConfig{{Name: &name, Nodes: []int64{0}, Start: &end, Mode: &delay}}}, InvPartitionErr},
+		{&FaultConfig{Partitions: []*PartitionConfig{{Name: &name, Nodes: []int64{7}, Start: &end}}}, UnknownNodeErr},
+		{
+			&FaultConfig{Partitions: []*PartitionConfig{{Name: &name, Nodes: []int64{0}, Start: &end, Mode: &unknownMode}}},
+			UnknownPartitionModeErr,
+		},
+	} {
+		sched, net := newTestNetwork(t, 10)
+		net.AddNode(0, &recvTimes{sched: sched})
+		net.AddNode(1, &recvTimes{sched: sched})
+		if err := net.ConfigureFaults(testCase.cfg); !errors.Is(err, testCase.err) {
+			t.Errorf("Got %v, expected %v", err, testCase.err)
+		}
+	}
+}
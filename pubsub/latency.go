@@ -0,0 +1,219 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/marlinprotocol/p2psim/core"
+	exprand "golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Latency of a message is drawn from one of the below distributions (all latencies in milliseconds)
+// - constant: every message takes `value`
+// - uniform: uniformly distributed between `min` and `max`
+// - normal: normally distributed with `mean` and `stddev`
+// - lognormal: log of the latency is normally distributed with `mu` and `sigma`
+// - pareto: heavy tailed with the minimum latency `scale` and the tail index `shape`
+// - spike: `base` latency and an additional `spike` latency with probability `spike_prob`
+// Negative samples (possible with the normal distribution) are treated as zero latency
+//
+// The latency distribution is either sampled independently for every message (DistLatency)
+//   or on top of the latency between the regions of the endpoints (see region.go)
+
+const (
+	// latency models
+	ConstantModel  = "constant"
+	UniformModel   = "uniform"
+	NormalModel    = "normal"
+	LogNormalModel = "lognormal"
+	ParetoModel    = "pareto"
+	SpikeModel     = "spike"
+)
+
+var (
+	UnspecLatencyModelErr = errors.New("Did not configure the latency model!")
+	UnknownLatencyErr     = errors.New("Could not recognize the requested latency model!")
+	UnspecLatencyParamErr = errors.New("Did not configure a parameter of the latency model!")
+	InvLatencyParamErr    = errors.New("Configured an invalid parameter for the latency model!")
+)
+
+type LatencyConfig struct {
+	// Distribution of the latency
+	Kind *string `toml:"kind,omitempty"`
+
+	// constant
+	Value *float64 `toml:"value,omitempty"`
+
+	// uniform
+	Min *float64 `toml:"min,omitempty"`
+	Max *float64 `toml:"max,omitempty"`
+
+	// normal
+	Mean   *float64 `toml:"mean,omitempty"`
+	StdDev *float64 `toml:"stddev,omitempty"`
+
+	// lognormal
+	Mu    *float64 `toml:"mu,omitempty"`
+	Sigma *float64 `toml:"sigma,omitempty"`
+
+	// pareto
+	Scale *float64 `toml:"scale,omitempty"`
+	Shape *float64 `toml:"shape,omitempty"`
+
+	// spike
+	Base      *float64 `toml:"base,omitempty"`
+	Spike     *float64 `toml:"spike,omitempty"`
+	SpikeProb *float64 `toml:"spike_prob,omitempty"`
+}
+
+// Latency of the messages sent over the network
+type LatencyModel interface {
+	// Called once for every node before it sends or receives any message
+	AddNode(nodeID int64)
+	// One way latency in milliseconds of the next message sent from srcID to dstID
+	Latency(srcID int64, dstID int64) float64
+}
+
+// Draws the latency of every message independently of its endpoints
+type DistLatency struct {
+	dist core.Dist
+}
+
+func GetDefaultLatencyConfig() *LatencyConfig {
+	kind := SpikeModel
+	base := BaseLatency
+	spike := SpikeLatency
+	spikeProb := SpikeProb
+	return &LatencyConfig{
+		Kind:      &kind,
+		Base:      &base,
+		Spike:     &spike,
+		SpikeProb: &spikeProb,
+	}
+}
+
+func NewDistLatency(cfg *LatencyConfig, rng exprand.Source) (*DistLatency, error) {
+	dist, err := NewLatencyDist(cfg, rng)
+	if err != nil {
+		return nil, err
+	}
+	return &DistLatency{
+		dist: dist,
+	}, nil
+}
+
+// Constructs the configured latency distribution drawing random numbers from rng
+func NewLatencyDist(cfg *LatencyConfig, rng exprand.Source) (core.Dist, error) {
+	if cfg.Kind == nil {
+		return nil, UnspecLatencyModelErr
+	}
+
+	switch *cfg.Kind {
+	case ConstantModel:
+		if err := checkParams([]string{"value"}, cfg.Value); err != nil {
+			return nil, err
+		}
+		if *cfg.Value < 0 {
+			return nil, fmt.Errorf("%w: value must not be negative", InvLatencyParamErr)
+		}
+		return &core.ConstantDist{
+			Value: *cfg.Value,
+		}, nil
+
+	case UniformModel:
+		if err := checkParams([]string{"min", "max"}, cfg.Min, cfg.Max); err != nil {
+			return nil, err
+		}
+		if *cfg.Min < 0 || *cfg.Max < *cfg.Min {
+			return nil, fmt.Errorf("%w: require 0 <= min <= max", InvLatencyParamErr)
+		}
+		return &distuv.Uniform{
+			Min: *cfg.Min,
+			Max: *cfg.Max,
+			Src: rng,
+		}, nil
+
+	case NormalModel:
+		if err := checkParams([]string{"mean", "stddev"}, cfg.Mean, cfg.StdDev); err != nil {
+			return nil, err
+		}
+		if *cfg.Mean < 0 || *cfg.StdDev < 0 {
+			return nil, fmt.Errorf("%w: mean and stddev must not be negative", InvLatencyParamErr)
+		}
+		return &distuv.Normal{
+			Mu:    *cfg.Mean,
+			Sigma: *cfg.StdDev,
+			Src:   rng,
+		}, nil
+
+	case LogNormalModel:
+		if err := checkParams([]string{"mu", "sigma"}, cfg.Mu, cfg.Sigma); err != nil {
+			return nil, err
+		}
+		if *cfg.Sigma < 0 {
+			return nil, fmt.Errorf("%w: sigma must not be negative", InvLatencyParamErr)
+		}
+		return &distuv.LogNormal{
+			Mu:    *cfg.Mu,
+			Sigma: *cfg.Sigma,
+			Src:   rng,
+		}, nil
+
+	case ParetoModel:
+		if err := checkParams([]string{"scale", "shape"}, cfg.Scale, cfg.Shape); err != nil {
+			return nil, err
+		}
+		if *cfg.Scale <= 0 || *cfg.Shape <= 0 {
+			return nil, fmt.Errorf("%w: scale and shape must be positive", InvLatencyParamErr)
+		}
+		return &distuv.Pareto{
+			Xm:    *cfg.Scale,
+			Alpha: *cfg.Shape,
+			Src:   rng,
+		}, nil
+
+	case SpikeModel:
+		if err := checkParams(
+			[]string{"base", "spike", "spike_prob"},
+			cfg.Base,
+			cfg.Spike,
+			cfg.SpikeProb,
+		); err != nil {
+			return nil, err
+		}
+		if *cfg.Base < 0 || *cfg.Spike < 0 || *cfg.SpikeProb < 0 || *cfg.SpikeProb > 1 {
+			return nil, fmt.Errorf(
+				"%w: base and spike must not be negative and spike_prob must lie between 0 and 1",
+				InvLatencyParamErr,
+			)
+		}
+		return &core.LatencyDist{
+			SpikeDist: &distuv.Bernoulli{
+				P:   *cfg.SpikeProb,
+				Src: rng,
+			},
+			BaseLatency:  *cfg.Base,
+			SpikeLatency: *cfg.Spike,
+		}, nil
+
+	default:
+		return nil, UnknownLatencyErr
+	}
+}
+
+func (model *DistLatency) AddNode(nodeID int64) {}
+
+func (model *DistLatency) Latency(srcID int64, dstID int64) float64 {
+	return model.dist.Rand()
+}
+
+// names are the config keys of the corresponding params
+func checkParams(names []string, params ...*float64) error {
+	for i, param := range params {
+		if param == nil {
+			return fmt.Errorf("%w: %v", UnspecLatencyParamErr, names[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package pubsub
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	exprand "golang.org/x/exp/rand"
+)
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func latencyModel(kind string) *string {
+	return &kind
+}
+
+func TestLatencyMeans(t *testing.T) {
+	for _, testCase := range []struct {
+		cfg  *LatencyConfig
+		mean float64
+	}{
+		{&LatencyConfig{Kind: latencyModel(ConstantModel), Value: float(40)}, 40},
+		{&LatencyConfig{Kind: latencyModel(UniformModel), Min: float(20), Max: float(60)}, 40},
+		{&LatencyConfig{Kind: latencyModel(NormalModel), Mean: float(80), StdDev: float(10)}, 80},
+		{&LatencyConfig{Kind: latencyModel(LogNormalModel), Mu: float(4), Sigma: float(0.5)}, math.Exp(4 + 0.5*0.5/2)},
+		{&LatencyConfig{Kind: latencyModel(ParetoModel), Scale: float(30), Shape: float(3)}, 30 * 3 / 2.0},
+		{GetDefaultLatencyConfig(), BaseLatency + SpikeProb*SpikeLatency},
+	} {
+		dist, err := NewLatencyDist(testCase.cfg, exprand.NewSource(55))
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", *testCase.cfg.Kind, err)
+		}
+		samples := 100_000
+		sum := 0.0
+		for i := 0; i < samples; i++ {
+			sum += dist.Rand()
+		}
+		// 2% tolerance
+		if mean := sum / float64(samples); math.Abs(mean-testCase.mean) > 0.02*testCase.mean {
+			t.Errorf("%v: got mean %v, expected %v", *testCase.cfg.Kind, mean, testCase.mean)
+		}
+	}
+}
+
+func TestInvLatency(t *testing.T) {
+	for _, testCase := range []struct {
+		cfg *LatencyConfig
+		err error
+	}{
+		{&LatencyConfig{}, UnspecLatencyModelErr},
+		{&LatencyConfig{Kind: latencyModel("gamma")}, UnknownLatencyErr},
+		{&LatencyConfig{Kind: latencyModel(UniformModel), Min: float(20)}, UnspecLatencyParamErr},
+		{&LatencyConfig{Kind: latencyModel(UniformModel), Min: float(20), Max: float(10)}, InvLatencyParamErr},
+		{&LatencyConfig{Kind: latencyModel(ConstantModel), Value: float(-1)}, InvLatencyParamErr},
+		{&LatencyConfig{Kind: latencyModel(ParetoModel), Scale: float(0), Shape: float(1)}, InvLatencyParamErr},
+		{
+			&LatencyConfig{Kind: latencyModel(SpikeModel), Base: float(1), Spike: float(1), SpikeProb: float(2)},
+			InvLatencyParamErr,
+		},
+	} {
+		if _, err := NewLatencyDist(testCase.cfg, exprand.NewSource(55)); !errors.Is(err, testCase.err) {
+			t.Errorf("Got %v, expected %v", err, testCase.err)
+		}
+	}
+}
@@ -2,18 +2,19 @@ package pubsub
 
 import (
 	"log"
+	"math"
 	"time"
 
 	"github.com/marlinprotocol/p2psim/core"
 	"go.uber.org/zap"
 	exprand "golang.org/x/exp/rand"
-	"gonum.org/v1/gonum/stat/distuv"
 )
 
-// Simulates network latency and acts as an intermediary for sending and receiving messages
+// Simulates network latency, bandwidth and faults and acts as an intermediary for sending and receiving messages
+// The latency model is configurable (see latency.go and region.go)
 
 const (
-	// latency measured in ms
+	// default latency model measured in ms
 	// latency on spike = base latency + spike latency
 	SpikeProb    = 0.1
 	BaseLatency  = 100.0
@@ -21,13 +22,22 @@ const (
 )
 
 type Network struct {
-	sched       *core.Scheduler
-	nodes       map[int64]RPCHandler
-	latencyDist core.Dist
-	collector   *StatCollector
-	logger      *zap.Logger
+	sched   *core.Scheduler
+	nodes   map[int64]RPCHandler
+	latency LatencyModel
+	// latencies (in ms) of specific links overriding the latency model
+	linkLatencies map[linkKey]float64
+	// transmission delays (see bandwidth.go)
+	bandwidth *bandwidthModel
+	// lost and dropped RPCs (see fault.go)
+	faults    *faultModel
+	collector *StatCollector
+	logger    *zap.Logger
 }
 
+// Identifies an undirected link by its endpoints in ascending order
+type linkKey [2]int64
+
 type MuxLink struct {
 	net     *Network
 	localID int64
@@ -40,28 +50,27 @@ type RPCEvent struct {
 	rpcMsg RPC
 }
 
-func NewNetwork(sched *core.Scheduler, seenTTL time.Duration, rng exprand.Source, logger *zap.Logger) (*Network, error) {
-	// latency distribution
-	latencyDist := &core.LatencyDist{
-		SpikeDist: &distuv.Bernoulli{
-			P:   SpikeProb,
-			Src: rng,
-		},
-		BaseLatency:  BaseLatency,
-		SpikeLatency: SpikeLatency,
-	}
-
+func NewNetwork(
+	sched *core.Scheduler,
+	seenTTL time.Duration,
+	latency LatencyModel,
+	rng exprand.Source,
+	logger *zap.Logger,
+) (*Network, error) {
 	collector, err := NewStatCollector(seenTTL)
 	if err != nil {
 		return nil, err
 	}
 
 	net := &Network{
-		sched:       sched,
-		nodes:       map[int64]RPCHandler{},
-		latencyDist: latencyDist,
-		collector:   collector,
-		logger:      logger,
+		sched:         sched,
+		nodes:         map[int64]RPCHandler{},
+		latency:       latency,
+		linkLatencies: map[linkKey]float64{},
+		bandwidth:     newBandwidthModel(),
+		faults:        newFaultModel(rng),
+		collector:     collector,
+		logger:        logger,
 	}
 
 	return net, nil
@@ -90,8 +99,19 @@ func (net *Network) GetFinalStats() core.Stats {
 
 func (net *Network) SendRPC(srcID int64, dstID int64, rpcMsg RPC) {
 	net.collector.CollectSendStats(srcID, rpcMsg, net.sched.CurTime)
-	latency := time.Duration(net.latencyDist.Rand()) * time.Millisecond
-	net.sched.Schedule(latency, &RPCEvent{
+	sendTime, ok := net.faults.getSendTime(srcID, dstID, net.sched.CurTime)
+	if !ok {
+		net.logDrop("Dropped RPC message", srcID, dstID)
+		return
+	}
+	// the RPC propagates to the receiver once it is transmitted
+	txEnd := net.bandwidth.transmit(srcID, dstID, GetWireSize(rpcMsg.GetSize()), sendTime)
+	if net.faults.isLost(srcID, dstID) {
+		net.logDrop("Lost RPC message", srcID, dstID)
+		return
+	}
+	latency := time.Duration(net.getLatency(srcID, dstID) * float64(time.Millisecond))
+	net.sched.Schedule(txEnd.Sub(net.sched.CurTime)+latency, &RPCEvent{
 		net:    net,
 		srcID:  srcID,
 		dstID:  dstID,
@@ -99,15 +119,65 @@ func (net *Network) SendRPC(srcID int64, dstID int64, rpcMsg RPC) {
 	})
 }
 
+// Fixes the latency (in ms) of the link in both directions overriding the latency model
+func (net *Network) SetLinkLatency(nodeID int64, otherID int64, latency float64) {
+	net.linkLatencies[newLinkKey(nodeID, otherID)] = latency
+}
+
+func (net *Network) getLatency(srcID int64, dstID int64) float64 {
+	if latency, exists := net.linkLatencies[newLinkKey(srcID, dstID)]; exists {
+		return latency
+	}
+	// latency cannot be negative
+	return math.Max(net.latency.Latency(srcID, dstID), 0)
+}
+
+func (net *Network) logDrop(reason string, srcID int64, dstID int64) {
+	net.logger.Debug(
+		reason,
+		zap.Time("CurTime", net.sched.CurTime),
+		zap.Int64("srcID", srcID),
+		zap.Int64("dstID", dstID),
+	)
+}
+
 func (net *Network) AddNode(nodeID int64, rpcHandler RPCHandler) *MuxLink {
 	net.nodes[nodeID] = rpcHandler
-	net.collector.AddNode(nodeID)
+	net.latency.AddNode(nodeID)
 	return &MuxLink{
 		net:     net,
 		localID: nodeID,
 	}
 }
 
+// RPCs in flight to the node are dropped on arrival
+func (net *Network) RemoveNode(nodeID int64) {
+	delete(net.nodes, nodeID)
+	net.collector.RemoveNode(nodeID)
+}
+
+// Connects two online nodes while the simulation is running, ex: on exchanging peers
+// Returns false if either node is offline (or not a pubsub node)
+func (net *Network) Connect(nodeID int64, otherID int64) bool {
+	node, online := net.nodes[nodeID].(*Node)
+	other, otherOnline := net.nodes[otherID].(*Node)
+	if nodeID == otherID || !online || !otherOnline {
+		return false
+	}
+	node.AddPeer(otherID)
+	other.AddPeer(nodeID)
+	return true
+}
+
+// Only the subscribers of a topic are expected to receive its messages
+func (net *Network) subscribe(nodeID int64, topic string) {
+	net.collector.Subscribe(nodeID, topic)
+}
+
+func (net *Network) unsubscribe(nodeID int64, topic string) {
+	net.collector.Unsubscribe(nodeID, topic)
+}
+
 func (link *MuxLink) SendRPC(remoteID int64, rpcMsg RPC) {
 	link.net.SendRPC(link.localID, remoteID, rpcMsg)
 }
@@ -115,3 +185,10 @@ func (link *MuxLink) SendRPC(remoteID int64, rpcMsg RPC) {
 func (rpcEvent *RPCEvent) Trigger() {
 	rpcEvent.net.HandleRPC(rpcEvent.srcID, rpcEvent.dstID, rpcEvent.rpcMsg)
 }
+
+func newLinkKey(nodeID int64, otherID int64) linkKey {
+	if otherID < nodeID {
+		return linkKey{otherID, nodeID}
+	}
+	return linkKey{nodeID, otherID}
+}
@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"go.uber.org/zap"
+	exprand "golang.org/x/exp/rand"
+)
+
+type recvTimes struct {
+	sched *core.Scheduler
+	times []time.Duration
+}
+
+func (handler *recvTimes) HandleRPC(srcID int64, rpcMsg RPC) {
+	handler.times = append(handler.times, handler.sched.CurTime.Sub(time.Time{}))
+}
+
+func newTestNetwork(t *testing.T, latency float64) (*core.Scheduler, *Network) {
+	sched, err := core.NewScheduler(time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	kind := ConstantModel
+	model, err := NewDistLatency(&LatencyConfig{Kind: &kind, Value: &latency}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	net, err := NewNetwork(sched, time.Minute, model, exprand.NewSource(55), zap.L())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return sched, net
+}
+
+// latencies are not rounded to whole ms
+func TestSubMsLatency(t *testing.T) {
+	sched, net := newTestNetwork(t, 0.5)
+	recv := &recvTimes{sched: sched}
+	net.AddNode(0, &recvTimes{sched: sched})
+	net.AddNode(1, recv)
+
+	net.SendRPC(0, 1, &CollectorRPC{size: 0, msg: &CollectorMsg{from: 0, seqno: 1}})
+	sched.Run()
+
+	if len(recv.times) != 1 || recv.times[0] != 500*time.Microsecond {
+		t.Errorf("RPC received at %v, expected at 500µs", recv.times)
+	}
+}
+
+// a 1460 byte RPC is 1524 bytes on the wire, i.e., 12192 bits
+func TestTransmissionDelay(t *testing.T) {
+	sched, net := newTestNetwork(t, 10)
+	recv := &recvTimes{sched: sched}
+	net.AddNode(0, &recvTimes{sched: sched})
+	net.AddNode(1, recv)
+	net.AddNode(2, recv)
+	// 12.192 Mbit/s transmits an RPC in 1ms
+	if err := net.SetBandwidth(0, 12.192, 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// RPCs to different peers share the uplink of the sender
+	net.SendRPC(0, 1, &CollectorRPC{size: MaxPayloadSize, msg: &CollectorMsg{from: 0, seqno: 1}})
+	net.SendRPC(0, 2, &CollectorRPC{size: MaxPayloadSize, msg: &CollectorMsg{from: 0, seqno: 1}})
+	net.SendRPC(0, 1, &CollectorRPC{size: MaxPayloadSize, msg: &CollectorMsg{from: 0, seqno: 2}})
+	sched.Run()
+
+	expected := []time.Duration{11 * time.Millisecond, 12 * time.Millisecond, 13 * time.Millisecond}
+	if len(recv.times) != len(expected) {
+		t.Fatalf("Received %v RPCs, expected %v", len(recv.times), len(expected))
+	}
+	for i, recvTime := range recv.times {
+		if recvTime != expected[i] {
+			t.Errorf("RPC %v received at %v, expected %v", i, recvTime, expected[i])
+		}
+	}
+}
+
+func TestBottleneck(t *testing.T) {
+	sched, net := newTestNetwork(t, 10)
+	recv := &recvTimes{sched: sched}
+	net.AddNode(0, &recvTimes{sched: sched})
+	net.AddNode(1, &recvTimes{sched: sched})
+	net.AddNode(2, recv)
+	if err := net.SetBandwidth(0, 12.192, 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := net.SetBandwidth(1, 0, 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the downlink of the receiver is shared by the senders and the link from 1 is slower still
+	if err := net.SetBandwidth(2, 0, 6.096); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := net.SetLinkBandwidth(1, 2, 3.048); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	net.SendRPC(0, 2, &CollectorRPC{size: MaxPayloadSize, msg: &CollectorMsg{from: 0, seqno: 1}})
+	net.SendRPC(1, 2, &CollectorRPC{size: MaxPayloadSize, msg: &CollectorMsg{from: 1, seqno: 1}})
+	sched.Run()
+
+	// 0 -> 2 is limited by the downlink (2ms) and 1 -> 2 by the link (4ms)
+	expected := []time.Duration{12 * time.Millisecond, 14 * time.Millisecond}
+	if len(recv.times) != len(expected) {
+		t.Fatalf("Received %v RPCs, expected %v", len(recv.times), len(expected))
+	}
+	for i, recvTime := range recv.times {
+		if recvTime != expected[i] {
+			t.Errorf("RPC %v received at %v, expected %v", i, recvTime, expected[i])
+		}
+	}
+
+	if err := net.SetBandwidth(0, -1, 0); err != NegBandwidthErr {
+		t.Errorf("Got %v, expected %v", err, NegBandwidthErr)
+	}
+}
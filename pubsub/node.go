@@ -11,6 +11,9 @@ import (
 const (
 	// Arbitrarily chosen
 	BlockSize = 48 * 1024
+
+	// Topic of the blocks when no topics are configured
+	DefaultTopic = "blocks"
 )
 
 // Generic Node interface
@@ -23,6 +26,13 @@ const (
 // Apart from handling messages, some nodes generate messages (blocks in this case)
 //   `HandleBlockGen` is triggered in intervals taken from a probability distribution (currently exponential)
 //   nodes are expected to send the message using the appropriate protocol
+// Nodes may join and leave the network during the run (churn)
+//   the routers are notified of connections and disconnections using `AddPeer` and `RemovePeer`
+// Messages are published on topics
+//   nodes announce their subscriptions to their peers using SUBSCRIBE RPCs (handled here and not by the routers)
+//   messages of topics the node is not subscribed to are neither delivered nor forwarded
+//   a node can publish on a topic without subscribing to it
+// Nodes may forward the messages with cut-through, chunk by chunk (see chunk.go)
 type Node struct {
 	Sched       *core.Scheduler
 	router      Router
@@ -31,23 +41,65 @@ type Node struct {
 	localID     int64
 	link        *MuxLink
 	nextSeqno   int64
+	// routers are notified of new peers and subscriptions only after starting
+	started bool
+
+	// set of topics the local node is subscribed to
+	Topics *core.Set
+	// peer ID -> set of topics the peer is known to be subscribed to
+	PeerTopics map[int64]*core.Set
+	// registered with the message generators until the node leaves the network
+	publishers []*TopicPublisher
+
+	// whether the messages are sent as chunks
+	cutThrough bool
+	// MsgID -> chunks of the message received and the peers the message is forwarded to
+	// entries retired along with the seen messages
+	chunkedMsgs map[MsgID]*chunkedMsg
+	// msg IDs of the chunked messages sorted in non-decreasing order of entry times
+	chunkEntries []SeenEntry
 }
 
 type Router interface {
 	Start(node *Node, logger *zap.Logger) error
 	PublishMsg(srcID int64, msg Message)
 	HandleRPC(srcID int64, rpcMsg RPC)
+	// Called after the peer connects to the local node
+	// Peers connected before starting are found in the neighbors of the node instead
+	AddPeer(remoteID int64)
+	// Called after the peer disconnects from the local node
+	RemovePeer(remoteID int64)
+	// Called when the local node leaves the network
+	Stop()
+	// Called after the local node subscribes to the topic
+	// Topics subscribed to before starting are joined right after starting
+	Join(topic string)
+	// Called after the local node unsubscribes from the topic
+	Leave(topic string)
+	// Called after the peer announces subscribing to or unsubscribing from the topic
+	HandleSubscription(remoteID int64, topic string, subscribe bool)
+	// RPCs from the peer are ignored altogether unless accepted (ex: graylisting misbehaving peers)
+	AcceptFrom(srcID int64) bool
 }
 
 type BlockMsg struct {
 	from  int64
 	seqno int64
+	topic string
+	size  int64
+}
+
+// Publishes messages on the topic whenever the message generator picks the node
+type TopicPublisher struct {
+	node    *Node
+	oracle  *core.OracleBlockGenerator
+	topic   string
+	msgSize int64
 }
 
 func SpawnNewNode(
 	sched *core.Scheduler,
 	net *Network,
-	oracle *core.OracleBlockGenerator,
 	seenTTL time.Duration,
 	router Router,
 	localID int64,
@@ -55,18 +107,22 @@ func SpawnNewNode(
 	logger *zap.Logger,
 ) (*Node, error) {
 	node := &Node{
-		Sched:       sched,
-		router:      router,
-		NeighborIDs: core.NewSet(),
-		SeenMsgs:    NewSeenCache(seenTTL),
-		localID:     localID,
-		link:        nil,
-		nextSeqno:   0,
+		Sched:        sched,
+		router:       router,
+		NeighborIDs:  core.NewSet(),
+		SeenMsgs:     NewSeenCache(seenTTL),
+		localID:      localID,
+		link:         nil,
+		nextSeqno:    0,
+		started:      false,
+		Topics:       core.NewSet(),
+		PeerTopics:   map[int64]*core.Set{},
+		publishers:   []*TopicPublisher{},
+		cutThrough:   false,
+		chunkedMsgs:  map[MsgID]*chunkedMsg{},
+		chunkEntries: []SeenEntry{},
 	}
 
-	// Register ourselves as miner/block publisher
-	oracle.AddPublisher(node)
-
 	// Add the local node to the network
 	node.link = net.AddNode(localID, node)
 
@@ -74,12 +130,36 @@ func SpawnNewNode(
 }
 
 func (node *Node) HandleRPC(srcID int64, rpcMsg RPC) {
+	if !node.router.AcceptFrom(srcID) {
+		return
+	}
+
+	if subRPC, ok := rpcMsg.(*SubscriptionRPC); ok {
+		node.handleSubscriptions(srcID, subRPC)
+		return
+	}
+	if chunk, ok := rpcMsg.(*ChunkRPC); ok {
+		node.handleChunk(srcID, chunk)
+		return
+	}
+	// the router handles the RPC on the arrival of the header and forwards the messages before they are complete
+	if header, ok := rpcMsg.(*HeaderRPC); ok {
+		node.handleHeader(srcID, header)
+		node.router.HandleRPC(srcID, header.rpc)
+		return
+	}
+
 	for _, msg := range rpcMsg.GetMessages() {
+		if !node.Topics.Exists(msg.Topic()) {
+			continue
+		}
 		msgID := MsgID{
 			From:  msg.From(),
 			Seqno: msg.Seqno(),
 		}
-		if node.SeenMsgs.MarkSeen(msgID, node.Sched.CurTime) {
+		// the router may have the message already from the header of its chunks
+		published := node.completeChunks(msg)
+		if node.SeenMsgs.MarkSeen(msgID, node.Sched.CurTime) && !published {
 			node.router.PublishMsg(srcID, msg)
 		}
 	}
@@ -88,24 +168,176 @@ func (node *Node) HandleRPC(srcID int64, rpcMsg RPC) {
 }
 
 func (node *Node) SendRPC(remoteID int64, rpcMsg RPC) {
+	if node.cutThrough && len(rpcMsg.GetMessages()) > 0 {
+		node.sendChunks(remoteID, rpcMsg)
+		return
+	}
 	node.link.SendRPC(remoteID, rpcMsg)
 }
 
-func (node *Node) PublishNewBlock() {
+func (node *Node) Publish(topic string, msgSize int64) {
 	node.nextSeqno++
-	// Since this message is generated locally, srcID has little meaning
-	node.router.PublishMsg(node.localID, &BlockMsg{
+	msg := &BlockMsg{
 		from:  node.localID,
 		seqno: node.nextSeqno,
+		topic: topic,
+		size:  msgSize,
+	}
+	// the message is not processed again when it comes back from the peers
+	node.SeenMsgs.MarkSeen(MsgID{
+		From:  msg.from,
+		Seqno: msg.seqno,
+	}, node.Sched.CurTime)
+	// Since this message is generated locally, srcID has little meaning
+	node.router.PublishMsg(node.localID, msg)
+}
+
+// Registers the node as a publisher of messages of the given size on the topic
+func (node *Node) AddPublisher(oracle *core.OracleBlockGenerator, topic string, msgSize int64) {
+	publisher := &TopicPublisher{
+		node:    node,
+		oracle:  oracle,
+		topic:   topic,
+		msgSize: msgSize,
+	}
+	oracle.AddPublisher(publisher)
+	node.publishers = append(node.publishers, publisher)
+}
+
+func (node *Node) Subscribe(topic string) {
+	if node.Topics.Exists(topic) {
+		return
+	}
+	node.Topics.Add(topic)
+	node.link.net.subscribe(node.localID, topic)
+	if node.started {
+		node.router.Join(topic)
+		node.announce(node.NeighborIDs, SubOpt{Topic: topic, Subscribe: true})
+	}
+}
+
+func (node *Node) Unsubscribe(topic string) {
+	if !node.Topics.Exists(topic) {
+		return
+	}
+	node.Topics.Remove(topic)
+	node.link.net.unsubscribe(node.localID, topic)
+	if node.started {
+		node.router.Leave(topic)
+		node.announce(node.NeighborIDs, SubOpt{Topic: topic, Subscribe: false})
+	}
+}
+
+// Whether the peer announced subscribing to the topic
+func (node *Node) PeerSubscribed(remoteID int64, topic string) bool {
+	topics, exists := node.PeerTopics[remoteID]
+	return exists && topics.Exists(topic)
+}
+
+// Records the subscriptions of the peer exchanged while connecting to the peer before starting
+// Otherwise the subscriptions are known only after they are announced
+//   and the routers would pick the peers whose announcements arrive first instead of random peers
+func (node *Node) SetPeerTopics(remoteID int64, topics *core.Set) {
+	node.PeerTopics[remoteID] = core.NewSet(topics.Flatten()...)
+}
+
+// Sends the current subscriptions of the local node to the peers
+func (node *Node) announceTopics(peerIDs *core.Set) {
+	subOpts := []SubOpt{}
+	node.Topics.Traverse(func(iTopic interface{}) {
+		subOpts = append(subOpts, SubOpt{
+			Topic:     iTopic.(string),
+			Subscribe: true,
+		})
+	})
+	node.announce(peerIDs, subOpts...)
+}
+
+func (node *Node) announce(peerIDs *core.Set, subOpts ...SubOpt) {
+	if len(subOpts) == 0 {
+		return
+	}
+	peerIDs.Traverse(func(iPeerID interface{}) {
+		node.SendRPC(iPeerID.(int64), NewSubscriptionRPC(subOpts))
 	})
 }
 
+func (node *Node) handleSubscriptions(srcID int64, subRPC *SubscriptionRPC) {
+	// announcements in flight from disconnected peers are stale
+	if !node.NeighborIDs.Exists(srcID) {
+		return
+	}
+	if _, exists := node.PeerTopics[srcID]; !exists {
+		node.PeerTopics[srcID] = core.NewSet()
+	}
+	for _, subOpt := range subRPC.Subscriptions {
+		// the subscriptions may already be known from the handshake
+		if node.PeerTopics[srcID].Exists(subOpt.Topic) == subOpt.Subscribe {
+			continue
+		}
+		if subOpt.Subscribe {
+			node.PeerTopics[srcID].Add(subOpt.Topic)
+		} else {
+			node.PeerTopics[srcID].Remove(subOpt.Topic)
+		}
+		if node.started {
+			node.router.HandleSubscription(srcID, subOpt.Topic, subOpt.Subscribe)
+		}
+	}
+}
+
 func (node *Node) AddPeer(remoteID int64) {
+	if node.NeighborIDs.Exists(remoteID) {
+		return
+	}
 	node.NeighborIDs.Add(remoteID)
+	if node.started {
+		node.announceTopics(core.NewSet(remoteID))
+		node.router.AddPeer(remoteID)
+	}
+}
+
+// Connects to the online node in both directions
+func (node *Node) Connect(remoteID int64) bool {
+	return node.link.net.Connect(node.localID, remoteID)
+}
+
+// Disconnects the peer, for instance when the peer leaves the network
+func (node *Node) RemovePeer(remoteID int64) {
+	if !node.NeighborIDs.Exists(remoteID) {
+		return
+	}
+	node.NeighborIDs.Remove(remoteID)
+	delete(node.PeerTopics, remoteID)
+	if node.started {
+		node.router.RemovePeer(remoteID)
+	}
 }
 
 func (node *Node) Start(logger *zap.Logger) error {
-	return node.router.Start(node, logger)
+	if err := node.router.Start(node, logger); err != nil {
+		return err
+	}
+	node.started = true
+	node.Topics.Traverse(func(iTopic interface{}) {
+		node.router.Join(iTopic.(string))
+	})
+	node.announceTopics(node.NeighborIDs)
+	return nil
+}
+
+// Leaves the network
+// The node stops publishing messages and RPCs in flight to the node are dropped
+func (node *Node) Stop() {
+	for _, publisher := range node.publishers {
+		publisher.oracle.RemovePublisher(publisher)
+	}
+	node.publishers = []*TopicPublisher{}
+	if node.started {
+		node.router.Stop()
+		node.started = false
+	}
+	node.link.net.RemoveNode(node.localID)
 }
 
 func (node *Node) ID() int64 {
@@ -113,7 +345,7 @@ func (node *Node) ID() int64 {
 }
 
 func (blockMsg *BlockMsg) GetSize() int64 {
-	return BlockSize
+	return blockMsg.size
 }
 
 func (blockMsg *BlockMsg) From() int64 {
@@ -123,3 +355,16 @@ func (blockMsg *BlockMsg) From() int64 {
 func (blockMsg *BlockMsg) Seqno() int64 {
 	return blockMsg.seqno
 }
+
+func (blockMsg *BlockMsg) Topic() string {
+	return blockMsg.topic
+}
+
+func (publisher *TopicPublisher) PublishNewBlock() {
+	publisher.node.Publish(publisher.topic, publisher.msgSize)
+}
+
+// Every node registers at most one publisher with a message generator
+func (publisher *TopicPublisher) ID() int64 {
+	return publisher.node.ID()
+}
@@ -0,0 +1,122 @@
+package pubsubtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"go.uber.org/zap"
+	exprand "golang.org/x/exp/rand"
+)
+
+// Fixtures shared by the tests of the routers
+// The nodes are connected over links with a constant latency unless the edges specify their own
+
+const (
+	// Latency of every link in ms
+	LinkLatency = 10.0
+
+	// Time for the subscriptions of the started nodes to propagate
+	SettleTime = 100 * time.Millisecond
+)
+
+// Router with an invalid config and the error expected on starting it
+type InvRouter struct {
+	Router pubsub.Router
+	Err    error
+}
+
+// Spawns nodes subscribed to the default topic and connected by the given edges
+// The routers of the nodes are created by newRouter and draw from the same source of randomness as the network
+// The nodes are started and settled if start is set, see StartNodes
+func SpawnNodes(
+	t testing.TB,
+	numNodes int,
+	newRouter func(rng exprand.Source) pubsub.Router,
+	edges [][2]int64,
+	start bool,
+) (*core.Scheduler, *pubsub.Network, []*pubsub.Node, []pubsub.Router) {
+	nullLogger := zap.L()
+	rng := exprand.NewSource(55)
+	sched, err := core.NewScheduler(time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	kind := pubsub.ConstantModel
+	latency := LinkLatency
+	latencyModel, err := pubsub.NewDistLatency(&pubsub.LatencyConfig{Kind: &kind, Value: &latency}, rng)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	net, err := pubsub.NewNetwork(sched, time.Minute, latencyModel, rng, nullLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	nodes := []*pubsub.Node{}
+	routers := []pubsub.Router{}
+	for nodeID := 0; nodeID < numNodes; nodeID++ {
+		router := newRouter(rng)
+		node, err := pubsub.SpawnNewNode(sched, net, time.Minute, router, int64(nodeID), rng, nullLogger)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		node.Subscribe(pubsub.DefaultTopic)
+		nodes = append(nodes, node)
+		routers = append(routers, router)
+	}
+	for _, edge := range edges {
+		nodes[edge[0]].AddPeer(edge[1])
+		nodes[edge[1]].AddPeer(edge[0])
+	}
+	if start {
+		StartNodes(t, sched, nodes)
+	}
+	return sched, net, nodes, routers
+}
+
+// Same as SpawnNodes with edges carrying the latency of the link in ms
+func SpawnNodesWithLatencies(
+	t testing.TB,
+	numNodes int,
+	newRouter func(rng exprand.Source) pubsub.Router,
+	edges [][3]int64,
+	start bool,
+) (*core.Scheduler, *pubsub.Network, []*pubsub.Node, []pubsub.Router) {
+	links := [][2]int64{}
+	for _, edge := range edges {
+		links = append(links, [2]int64{edge[0], edge[1]})
+	}
+	sched, net, nodes, routers := SpawnNodes(t, numNodes, newRouter, links, false)
+	for _, edge := range edges {
+		net.SetLinkLatency(edge[0], edge[1], float64(edge[2]))
+	}
+	if start {
+		StartNodes(t, sched, nodes)
+	}
+	return sched, net, nodes, routers
+}
+
+// Starts the nodes and runs the scheduler for SettleTime
+func StartNodes(t testing.TB, sched *core.Scheduler, nodes []*pubsub.Node) {
+	for _, node := range nodes {
+		if err := node.Start(zap.L()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	sched.RunFor(SettleTime)
+}
+
+// Checks that a node fails to start with each of the routers
+func CheckInvRouters(t testing.TB, invRouters []InvRouter) {
+	for i, invRouter := range invRouters {
+		_, _, nodes, _ := SpawnNodes(t, 1, func(rng exprand.Source) pubsub.Router {
+			return invRouter.Router
+		}, [][2]int64{}, false)
+		if err := nodes[0].Start(zap.L()); !errors.Is(err, invRouter.Err) {
+			t.Errorf("Config %v: expected %v, got %v", i, invRouter.Err, err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package pubsub
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/marlinprotocol/p2psim/core"
+	exprand "golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Every node is located in a geographic region
+// The latency of a message is the latency between the regions of its endpoints plus the jitter of its link
+//   drawn once for every link from the (optional) jitter distribution, so that the messages over a link
+//   see the same latency unless the (optional) variation adds a latency drawn for every message
+//
+// Region to region latencies (in ms) are loaded from a CSV file where the header names the regions
+//   and row i lists the latencies from the i-th region to every region in the order of the header
+//   region,us,eu,asia
+//   us,20,90,180
+//   eu,90,15,250
+//   asia,180,250,30
+// The first column of each row must name the regions in the same order as the header
+// The matrix need not be symmetric and lines starting with # are comments
+//
+// Nodes are assigned to regions at random in proportion to the configured weights
+//   regions without a weight are not assigned any nodes and all regions are equally likely if no weights are configured
+
+var (
+	UnspecMatrixFileErr = errors.New("Did not configure the region latency matrix!")
+	MalformedMatrixErr  = errors.New("Region latency matrix is malformed!")
+	UnknownRegionErr    = errors.New("Configured a weight for an unknown region!")
+	InvRegionWeightErr  = errors.New("Configured invalid region weights!")
+)
+
+type RegionConfig struct {
+	// CSV file containing the region to region latencies
+	MatrixFile *string `toml:"matrix_file,omitempty"`
+
+	// region -> relative number of nodes in the region
+	Weights map[string]float64 `toml:"weights,omitempty"`
+
+	// Distribution of the latency added to the region latency of every link
+	// Drawn once for every link, i.e., all the messages over a link take the same latency
+	// No jitter if unspecified
+	Jitter *LatencyConfig `toml:"jitter,omitempty"`
+
+	// Distribution of the latency added to every message on top of the jitter of its link
+	// No variation if unspecified
+	Variation *LatencyConfig `toml:"variation,omitempty"`
+}
+
+type RegionLatency struct {
+	// region names in the order of the matrix
+	regions []string
+	// regionLatencies[i][j] is the latency from region i to region j
+	regionLatencies [][]float64
+	// node ID -> index of its region
+	nodeRegions map[int64]int
+	regionDist  *distuv.Categorical
+	jitter      core.Dist
+	// link -> jitter drawn for the link on its first message
+	linkJitters map[linkKey]float64
+	variation   core.Dist
+}
+
+func NewRegionLatency(cfg *RegionConfig, rng exprand.Source) (*RegionLatency, error) {
+	if cfg.MatrixFile == nil {
+		return nil, UnspecMatrixFileErr
+	}
+	regions, regionLatencies, err := LoadLatencyMatrix(*cfg.MatrixFile)
+	if err != nil {
+		return nil, err
+	}
+
+	weights, err := getRegionWeights(regions, cfg.Weights)
+	if err != nil {
+		return nil, err
+	}
+	regionDist := distuv.NewCategorical(weights, rng)
+
+	jitter, err := newOptionalLatencyDist(cfg.Jitter, rng)
+	if err != nil {
+		return nil, err
+	}
+	variation, err := newOptionalLatencyDist(cfg.Variation, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegionLatency{
+		regions:         regions,
+		regionLatencies: regionLatencies,
+		nodeRegions:     map[int64]int{},
+		regionDist:      &regionDist,
+		jitter:          jitter,
+		linkJitters:     map[linkKey]float64{},
+		variation:       variation,
+	}, nil
+}
+
+// No latency is added if the distribution is unspecified
+func newOptionalLatencyDist(cfg *LatencyConfig, rng exprand.Source) (core.Dist, error) {
+	if cfg == nil {
+		return &core.ConstantDist{
+			Value: 0,
+		}, nil
+	}
+	return NewLatencyDist(cfg, rng)
+}
+
+// Returns the region names and the latencies between them
+func LoadLatencyMatrix(path string) ([]string, [][]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return readLatencyMatrix(file)
+}
+
+func readLatencyMatrix(reader io.Reader) ([]string, [][]float64, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comment = '#'
+	csvReader.TrimLeadingSpace = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", MalformedMatrixErr, err)
+	}
+	if len(records) < 2 {
+		return nil, nil, fmt.Errorf("%w: expected a header and at least one region", MalformedMatrixErr)
+	}
+
+	regions := records[0][1:]
+	regionSet := core.NewSet()
+	for _, region := range regions {
+		if regionSet.Exists(region) {
+			return nil, nil, fmt.Errorf("%w: duplicate region %v", MalformedMatrixErr, region)
+		}
+		regionSet.Add(region)
+	}
+	if len(records)-1 != len(regions) {
+		return nil, nil, fmt.Errorf(
+			"%w: expected %v rows, got %v",
+			MalformedMatrixErr,
+			len(regions),
+			len(records)-1,
+		)
+	}
+
+	regionLatencies := make([][]float64, len(regions))
+	// the csv reader ensures that every row has as many fields as the header
+	for i, record := range records[1:] {
+		if strings.TrimSpace(record[0]) != regions[i] {
+			return nil, nil, fmt.Errorf("%w: expected row %v, got %v", MalformedMatrixErr, regions[i], record[0])
+		}
+		regionLatencies[i] = make([]float64, len(regions))
+		for j, field := range record[1:] {
+			latency, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil || latency < 0 {
+				return nil, nil, fmt.Errorf(
+					"%w: invalid latency %v from %v to %v",
+					MalformedMatrixErr,
+					field,
+					regions[i],
+					regions[j],
+				)
+			}
+			regionLatencies[i][j] = latency
+		}
+	}
+	return regions, regionLatencies, nil
+}
+
+// Weights in the order of the regions
+func getRegionWeights(regions []string, weightCfg map[string]float64) ([]float64, error) {
+	weights := make([]float64, len(regions))
+	if len(weightCfg) == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		return weights, nil
+	}
+
+	indices := map[string]int{}
+	for i, region := range regions {
+		indices[region] = i
+	}
+	totalWeight := 0.0
+	for region, weight := range weightCfg {
+		index, exists := indices[region]
+		if !exists {
+			return nil, fmt.Errorf("%w: %v", UnknownRegionErr, region)
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("%w: %v has a negative weight", InvRegionWeightErr, region)
+		}
+		weights[index] = weight
+		totalWeight += weight
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("%w: at least one weight must be positive", InvRegionWeightErr)
+	}
+	return weights, nil
+}
+
+func (model *RegionLatency) AddNode(nodeID int64) {
+	if _, exists := model.nodeRegions[nodeID]; exists {
+		return
+	}
+	model.nodeRegions[nodeID] = int(model.regionDist.Rand())
+}
+
+func (model *RegionLatency) Latency(srcID int64, dstID int64) float64 {
+	regionLatency := model.regionLatencies[model.nodeRegions[srcID]][model.nodeRegions[dstID]]
+	key := newLinkKey(srcID, dstID)
+	jitter, exists := model.linkJitters[key]
+	if !exists {
+		jitter = model.jitter.Rand()
+		model.linkJitters[key] = jitter
+	}
+	return regionLatency + jitter + model.variation.Rand()
+}
+
+// Name of the region the node is located in
+func (model *RegionLatency) Region(nodeID int64) string {
+	return model.regions[model.nodeRegions[nodeID]]
+}
@@ -0,0 +1,157 @@
+package pubsub
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marlinprotocol/p2psim/core"
+	exprand "golang.org/x/exp/rand"
+)
+
+const matrix = `region,us,eu,asia
+# one way latencies in ms
+us,20,90,180
+eu,95,15,250
+asia,180,250,30
+`
+
+func writeMatrix(t *testing.T, contents string) *string {
+	path := filepath.Join(t.TempDir(), "regions.csv")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return &path
+}
+
+func TestRegionLatency(t *testing.T) {
+	cfg := &RegionConfig{
+		MatrixFile: writeMatrix(t, matrix),
+		Weights: map[string]float64{
+			"us": 1,
+			"eu": 1,
+		},
+	}
+	model, err := NewRegionLatency(cfg, exprand.NewSource(55))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	numNodes := int64(1000)
+	counts := map[string]int{}
+	for nodeID := int64(0); nodeID < numNodes; nodeID++ {
+		model.AddNode(nodeID)
+		counts[model.Region(nodeID)]++
+	}
+	if counts["asia"] != 0 {
+		t.Errorf("Assigned %v nodes to a region without weight", counts["asia"])
+	}
+	// 10% tolerance
+	if counts["us"] < 450 || counts["eu"] < 450 {
+		t.Errorf("Regions are not assigned in proportion to the weights: %v", counts)
+	}
+
+	expected := map[[2]string]float64{
+		{"us", "us"}: 20,
+		{"us", "eu"}: 90,
+		{"eu", "us"}: 95,
+		{"eu", "eu"}: 15,
+	}
+	for srcID := int64(0); srcID < 10; srcID++ {
+		for dstID := int64(0); dstID < 10; dstID++ {
+			regions := [2]string{model.Region(srcID), model.Region(dstID)}
+			if latency := model.Latency(srcID, dstID); latency != expected[regions] {
+				t.Errorf("Latency from %v to %v: got %v, expected %v", regions[0], regions[1], latency, expected[regions])
+			}
+		}
+	}
+}
+
+func TestRegionJitter(t *testing.T) {
+	kind := UniformModel
+	minJitter, maxJitter := 5.0, 10.0
+	cfg := &RegionConfig{
+		MatrixFile: writeMatrix(t, matrix),
+		Jitter: &LatencyConfig{
+			Kind: &kind,
+			Min:  &minJitter,
+			Max:  &maxJitter,
+		},
+	}
+	model, err := NewRegionLatency(cfg, exprand.NewSource(55))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for nodeID := int64(0); nodeID < 3; nodeID++ {
+		model.AddNode(nodeID)
+	}
+
+	_, regionLatencies, _ := readLatencyMatrix(strings.NewReader(matrix))
+	jitter := func(srcID int64, dstID int64) float64 {
+		return model.Latency(srcID, dstID) - regionLatencies[model.nodeRegions[srcID]][model.nodeRegions[dstID]]
+	}
+	// the messages over a link see the same jitter in both directions
+	linkJitter := jitter(0, 1)
+	if linkJitter < minJitter || linkJitter > maxJitter {
+		t.Errorf("Jitter %v is not within [%v, %v]", linkJitter, minJitter, maxJitter)
+	}
+	for i := 0; i < 100; i++ {
+		if jitter(0, 1) != linkJitter || jitter(1, 0) != linkJitter {
+			t.Fatalf("Jitter of the link changed from %v", linkJitter)
+		}
+	}
+	if jitter(0, 2) == linkJitter || jitter(1, 2) == linkJitter {
+		t.Errorf("Links share the jitter %v", linkJitter)
+	}
+
+	// the variation is drawn for every message
+	cfg.Variation = cfg.Jitter
+	model, err = NewRegionLatency(cfg, exprand.NewSource(55))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	model.AddNode(0)
+	model.AddNode(1)
+	latencies := core.NewSet()
+	for i := 0; i < 100; i++ {
+		latency := jitter(0, 1)
+		if latency < 2*minJitter || latency > 2*maxJitter {
+			t.Errorf("Jitter and variation %v are not within [%v, %v]", latency, 2*minJitter, 2*maxJitter)
+		}
+		latencies.Add(latency)
+	}
+	if latencies.Len() != 100 {
+		t.Errorf("Messages over the link took %v distinct latencies, expected 100", latencies.Len())
+	}
+}
+
+func TestInvRegions(t *testing.T) {
+	for _, testCase := range []struct {
+		contents string
+		weights  map[string]float64
+		err      error
+	}{
+		{"region,us,eu\nus,1,2\n", nil, MalformedMatrixErr},
+		{"region,us,eu\nus,1,2\neu,3\n", nil, MalformedMatrixErr},
+		{"region,us,eu\nus,1,2\nasia,3,4\n", nil, MalformedMatrixErr},
+		{"region,us,us\nus,1,2\nus,3,4\n", nil, MalformedMatrixErr},
+		{"region,us,eu\nus,1,2\neu,-3,4\n", nil, MalformedMatrixErr},
+		{matrix, map[string]float64{"africa": 1}, UnknownRegionErr},
+		{matrix, map[string]float64{"us": -1, "eu": 2}, InvRegionWeightErr},
+		{matrix, map[string]float64{"us": 0}, InvRegionWeightErr},
+	} {
+		cfg := &RegionConfig{
+			MatrixFile: writeMatrix(t, testCase.contents),
+			Weights:    testCase.weights,
+		}
+		if _, err := NewRegionLatency(cfg, exprand.NewSource(55)); !errors.Is(err, testCase.err) {
+			t.Errorf("Got %v, expected %v", err, testCase.err)
+		}
+	}
+
+	if _, err := NewRegionLatency(&RegionConfig{}, exprand.NewSource(55)); !errors.Is(err, UnspecMatrixFileErr) {
+		t.Errorf("Got %v, expected %v", err, UnspecMatrixFileErr)
+	}
+}
@@ -14,6 +14,18 @@ type Message interface {
 	GetSize() int64
 	From() int64
 	Seqno() int64
+	Topic() string
+}
+
+// Closest parallel is the subscriptions field of the RPC protocol buffer
+// Sent on connecting to a peer and on subscribing or unsubscribing from a topic
+type SubscriptionRPC struct {
+	Subscriptions []SubOpt
+}
+
+type SubOpt struct {
+	Topic     string
+	Subscribe bool
 }
 
 // Assume default message ID function
@@ -26,3 +38,22 @@ type MsgID struct {
 type RPCHandler interface {
 	HandleRPC(srcID int64, rpcMsg RPC)
 }
+
+func NewSubscriptionRPC(subOpts []SubOpt) *SubscriptionRPC {
+	return &SubscriptionRPC{
+		Subscriptions: subOpts,
+	}
+}
+
+// The topic and a byte for the subscribe flag along with a byte of framing
+func (subRPC *SubscriptionRPC) GetSize() int64 {
+	size := int64(0)
+	for _, subOpt := range subRPC.Subscriptions {
+		size += int64(len(subOpt.Topic)) + 2
+	}
+	return size
+}
+
+func (subRPC *SubscriptionRPC) GetMessages() []Message {
+	return []Message{}
+}
@@ -0,0 +1,318 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"go.uber.org/zap"
+	exprand "golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Peers join and leave the network during the run
+// - every node, including the initial nodes, stays online for a session length drawn from the session distribution
+// - new nodes arrive as a poisson process with the configured arrival rate
+// On average, arrival_rate * mean session length nodes are online in the steady state
+//
+// A leaving node stops routing and publishing, and its neighbors are notified of the disconnect
+// A new node joins through a bootstrap procedure
+//   it discovers bootstrap_peers nodes chosen uniformly at random among the online nodes (say, using a DHT)
+//   and connects to them
+// Connecting to the neighborhood of a single bootstrap node instead partitions the network into clusters over time
+// Since new nodes connect to random online nodes, the degree of a node stays around bootstrap_peers in the steady state
+//
+// Session length distributions
+// - exponential: memoryless with the given `mean`
+// - weibull: with the given `scale` and `shape`, shapes below 1 model many short and few long sessions
+// - pareto: heavy tailed with the minimum session length `scale` and the tail index `shape`
+
+const (
+	// session length distributions
+	ExponentialSession = "exponential"
+	WeibullSession     = "weibull"
+	ParetoSession      = "pareto"
+)
+
+var (
+	UnspecArrivalRateErr = errors.New("Did not configure the arrival rate of the nodes!")
+	InvArrivalRateErr    = errors.New("Cannot specify a negative arrival rate!")
+	UnspecSessionErr     = errors.New("Did not configure the session length distribution!")
+	UnknownSessionErr    = errors.New("Could not recognize the session length distribution!")
+	InvSessionErr        = errors.New("Configured an invalid session length distribution!")
+	InvBootstrapErr      = errors.New("Number of bootstrap peers must be positive!")
+)
+
+var (
+	// Default config params
+	BootstrapPeers = core.AvgDeg
+)
+
+type ChurnConfig struct {
+	// Mean number of nodes joining the network per minute
+	ArrivalRate *float64 `toml:"arrival_rate"`
+
+	// Distribution of the time a node stays online
+	Session *SessionConfig `toml:"session"`
+
+	// Number of peers a new node connects to
+	BootstrapPeers *int `toml:"bootstrap_peers,omitempty"`
+}
+
+type SessionConfig struct {
+	Kind *string `toml:"kind"`
+
+	// exponential
+	Mean *time.Duration `toml:"mean,omitempty"`
+
+	// weibull and pareto
+	Scale *time.Duration `toml:"scale,omitempty"`
+	Shape *float64       `toml:"shape,omitempty"`
+}
+
+// Tracks the online nodes and schedules their arrivals and departures
+type Churn struct {
+	sched      *core.Scheduler
+	net        *pubsub.Network
+	generators []*topicGenerator
+	cfg        *Config
+
+	// in milliseconds
+	arrivalDist core.Dist
+	sessionDist core.Dist
+	// picks the peers of new nodes
+	rng exprand.Source
+
+	// node ID -> node
+	nodes      map[int64]*pubsub.Node
+	nextNodeID int64
+
+	// error spawning a new node, the scheduler is paused on the error
+	err error
+
+	logger *zap.Logger
+}
+
+type arrivalEvent struct {
+	churn *Churn
+}
+
+type departureEvent struct {
+	churn *Churn
+	node  *pubsub.Node
+}
+
+// Schedules the departures of the initial nodes and the arrival of new nodes
+func NewChurn(
+	sched *core.Scheduler,
+	net *pubsub.Network,
+	generators []*topicGenerator,
+	nodes []*pubsub.Node,
+	cfg *Config,
+	rng exprand.Source,
+	logger *zap.Logger,
+) (*Churn, error) {
+	churnCfg := cfg.Churn
+	if churnCfg.ArrivalRate == nil {
+		return nil, UnspecArrivalRateErr
+	}
+	if *churnCfg.ArrivalRate < 0 {
+		return nil, InvArrivalRateErr
+	}
+	if churnCfg.BootstrapPeers != nil && *churnCfg.BootstrapPeers <= 0 {
+		return nil, InvBootstrapErr
+	}
+	sessionDist, err := newSessionDist(churnCfg.Session, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	churn := &Churn{
+		sched:      sched,
+		net:        net,
+		generators: generators,
+		cfg:        cfg,
+		arrivalDist: &distuv.Exponential{
+			// per minute -> per millisecond
+			Rate: *churnCfg.ArrivalRate / float64(time.Minute.Milliseconds()),
+			Src:  rng,
+		},
+		sessionDist: sessionDist,
+		rng:         rng,
+		nodes:       map[int64]*pubsub.Node{},
+		nextNodeID:  0,
+		logger:      logger,
+	}
+
+	for _, node := range nodes {
+		churn.nodes[node.ID()] = node
+		if node.ID() >= churn.nextNodeID {
+			churn.nextNodeID = node.ID() + 1
+		}
+		churn.scheduleDeparture(node)
+	}
+	if *churnCfg.ArrivalRate > 0 {
+		churn.scheduleArrival()
+	}
+	return churn, nil
+}
+
+func newSessionDist(cfg *SessionConfig, rng exprand.Source) (core.Dist, error) {
+	if cfg == nil || cfg.Kind == nil {
+		return nil, UnspecSessionErr
+	}
+
+	switch *cfg.Kind {
+	case ExponentialSession:
+		if cfg.Mean == nil || *cfg.Mean <= 0 {
+			return nil, fmt.Errorf("%w: mean must be positive", InvSessionErr)
+		}
+		return &distuv.Exponential{
+			Rate: 1.0 / float64(cfg.Mean.Milliseconds()),
+			Src:  rng,
+		}, nil
+
+	case WeibullSession, ParetoSession:
+		if cfg.Scale == nil || cfg.Shape == nil || *cfg.Scale <= 0 || *cfg.Shape <= 0 {
+			return nil, fmt.Errorf("%w: scale and shape must be positive", InvSessionErr)
+		}
+		if *cfg.Kind == WeibullSession {
+			return &distuv.Weibull{
+				K:      *cfg.Shape,
+				Lambda: float64(cfg.Scale.Milliseconds()),
+				Src:    rng,
+			}, nil
+		}
+		return &distuv.Pareto{
+			Xm:    float64(cfg.Scale.Milliseconds()),
+			Alpha: *cfg.Shape,
+			Src:   rng,
+		}, nil
+
+	default:
+		return nil, UnknownSessionErr
+	}
+}
+
+// Error that stopped the run, nil if the nodes arrived as scheduled
+func (churn *Churn) Err() error {
+	return churn.err
+}
+
+// Nodes currently online sorted by their IDs
+func (churn *Churn) OnlineNodes() []*pubsub.Node {
+	nodes := make([]*pubsub.Node, 0, len(churn.nodes))
+	for _, node := range churn.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID() < nodes[j].ID()
+	})
+	return nodes
+}
+
+func (churn *Churn) scheduleArrival() {
+	churn.sched.Schedule(getDuration(churn.arrivalDist), &arrivalEvent{
+		churn: churn,
+	})
+}
+
+func (churn *Churn) scheduleDeparture(node *pubsub.Node) {
+	churn.sched.Schedule(getDuration(churn.sessionDist), &departureEvent{
+		churn: churn,
+		node:  node,
+	})
+}
+
+func (churn *Churn) arrive() error {
+	nodeID := churn.nextNodeID
+	churn.nextNodeID++
+	node, err := spawnNewNode(churn.sched, churn.net, churn.cfg, nodeID, churn.rng, churn.logger)
+	if err != nil {
+		return err
+	}
+	joinTopics(node, churn.generators, churn.rng)
+	if err := setBandwidth(nodeID, churn.net, churn.cfg.Bandwidth); err != nil {
+		return err
+	}
+
+	// connect in both directions before starting the node so that the router finds its peers
+	// the online peers learn the subscriptions of the new node from its announcements on starting
+	for _, peerID := range churn.bootstrap() {
+		node.AddPeer(peerID)
+		node.SetPeerTopics(peerID, churn.nodes[peerID].Topics)
+		churn.nodes[peerID].AddPeer(nodeID)
+	}
+	churn.nodes[nodeID] = node
+
+	churn.logger.Debug(
+		"Node joined the network",
+		zap.Time("CurTime", churn.sched.CurTime),
+		zap.Int64("nodeID", nodeID),
+		zap.Int("numPeers", node.NeighborIDs.Len()),
+	)
+	if err := node.Start(churn.logger); err != nil {
+		return err
+	}
+	churn.scheduleDeparture(node)
+	return nil
+}
+
+// Returns the peers of a new node
+func (churn *Churn) bootstrap() []int64 {
+	onlineNodes := churn.OnlineNodes()
+	numPeers := BootstrapPeers
+	if churn.cfg.Churn.BootstrapPeers != nil {
+		numPeers = *churn.cfg.Churn.BootstrapPeers
+	}
+	if numPeers > len(onlineNodes) {
+		numPeers = len(onlineNodes)
+	}
+
+	peerIDs := []int64{}
+	for _, index := range exprand.New(churn.rng).Perm(len(onlineNodes))[:numPeers] {
+		peerIDs = append(peerIDs, onlineNodes[index].ID())
+	}
+	return peerIDs
+}
+
+func (churn *Churn) depart(node *pubsub.Node) {
+	nodeID := node.ID()
+	node.Stop()
+	delete(churn.nodes, nodeID)
+	node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
+		if neighbor, online := churn.nodes[iNeighborID.(int64)]; online {
+			neighbor.RemovePeer(nodeID)
+		}
+	})
+
+	churn.logger.Debug(
+		"Node left the network",
+		zap.Time("CurTime", churn.sched.CurTime),
+		zap.Int64("nodeID", nodeID),
+	)
+}
+
+// samples are in milliseconds
+func getDuration(dist core.Dist) time.Duration {
+	return time.Duration(math.Round(dist.Rand())) * time.Millisecond
+}
+
+// Schedules the arrival of the next node as well
+// Stops the run if the node cannot be spawned, see Err
+func (event *arrivalEvent) Trigger() {
+	if err := event.churn.arrive(); err != nil {
+		event.churn.err = err
+		event.churn.sched.Pause()
+		return
+	}
+	event.churn.scheduleArrival()
+}
+
+func (event *departureEvent) Trigger() {
+	event.churn.depart(event.node)
+}
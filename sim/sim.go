@@ -5,26 +5,40 @@ import (
 	"log"
 	"time"
 
+	"github.com/marlinprotocol/p2psim/coded"
 	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/devp2p"
+	"github.com/marlinprotocol/p2psim/episub"
 	"github.com/marlinprotocol/p2psim/floodsub"
 	"github.com/marlinprotocol/p2psim/gossipsub"
+	"github.com/marlinprotocol/p2psim/invsub"
+	"github.com/marlinprotocol/p2psim/plumtree"
 	"github.com/marlinprotocol/p2psim/pubsub"
 	"go.uber.org/zap"
 	exprand "golang.org/x/exp/rand"
 	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/topo"
 )
 
 var (
-	UnknownRouterErr  = errors.New("Could not recognize the requested router type!")
-	UnspecDurErr      = errors.New("Did not configure a run duration!")
-	UnspecNumPeerErr  = errors.New("Did not configure the total number of peers!")
-	UnspecBlockDurErr = errors.New("Did not configure the block interval!")
-	UnspecRouterErr   = errors.New("Did not configure the router type!")
+	UnknownRouterErr     = errors.New("Could not recognize the requested router type!")
+	UnspecSeedErr        = errors.New("Did not configure the random seed!")
+	UnspecDurErr         = errors.New("Did not configure a run duration!")
+	UnspecNumPeerErr     = errors.New("Did not configure the total number of peers!")
+	UnspecBlockDurErr    = errors.New("Did not configure the block interval!")
+	UnspecRouterErr      = errors.New("Did not configure the router type!")
+	UnspecBandwidthErr   = errors.New("Did not configure the bandwidth of the nodes!")
+	PeerCountMismatchErr = errors.New("Configured number of peers differs from the nodes in the topology file!")
 )
 
 const (
 	FloodSub  = "floodsub"
 	GossipSub = "gossipsub"
+	EpiSub    = "episub"
+	Plumtree  = "plumtree"
+	InvSub    = "invsub"
+	DevP2P    = "devp2p"
+	Coded     = "coded"
 )
 
 var (
@@ -42,37 +56,138 @@ type Config struct {
 	RunDuration *time.Duration `toml:"run_duration"`
 
 	// Total number of nodes in the network
+	// Optional if the topology is loaded from a file
 	TotalPeers *int `toml:"total_peers"`
 
+	// Random graph model connecting the nodes
+	Topology *core.TopologyConfig `toml:"topology,omitempty"`
+
+	// Distribution of the network latency
+	// Ignored if the regions are configured
+	Latency *pubsub.LatencyConfig `toml:"latency,omitempty"`
+
+	// Latencies between the geographic regions of the nodes
+	// Optional, the latency of every message is drawn from the latency distribution otherwise
+	Regions *pubsub.RegionConfig `toml:"regions,omitempty"`
+
+	// Upload and download bandwidth of the nodes
+	// Optional, the bandwidth is unlimited otherwise
+	Bandwidth *pubsub.BandwidthConfig `toml:"bandwidth,omitempty"`
+
+	// Packet loss, link failures and partitions
+	// Optional, the network is reliable otherwise
+	Faults *pubsub.FaultConfig `toml:"faults,omitempty"`
+
+	// Peers joining and leaving during the run
+	// Optional, the nodes stay online throughout otherwise
+	Churn *ChurnConfig `toml:"churn,omitempty"`
+
 	// Duration for which messages are marked as seen
 	SeenTTL *time.Duration `toml:"seen_ttl,omitempty"`
 
 	// Expected time to generate the next block
+	// Ignored if the topics are configured
 	BlockInterval *time.Duration `toml:"block_interval"`
 
+	// Whether the nodes forward the messages chunk by chunk as the chunks arrive (see pubsub/chunk.go)
+	// Optional, the nodes forward the messages once they are complete otherwise
+	CutThrough *bool `toml:"cut_through,omitempty"`
+
+	// Topics the messages are published on (see topic.go)
+	// Optional, blocks are published on a single topic otherwise
+	Topics []*TopicConfig `toml:"topics,omitempty"`
+
 	// The type of router to consider
 	Router *string `toml:"router"`
 
 	// Configuration options for the gossip router
 	// Options enabled iff the router is specified as `gossipsub`
 	GossipSub *gossipsub.Config `toml:"gossipsub,omitempty"`
+
+	// Configuration options for the episub router
+	// Options enabled iff the router is specified as `episub`
+	EpiSub *episub.Config `toml:"episub,omitempty"`
+
+	// Configuration options for the plumtree router
+	// Options enabled iff the router is specified as `plumtree`
+	Plumtree *plumtree.Config `toml:"plumtree,omitempty"`
+
+	// Configuration options for the inv/getdata router
+	// Options enabled iff the router is specified as `invsub`
+	InvSub *invsub.Config `toml:"invsub,omitempty"`
+
+	// Configuration options for the ethereum block propagation router
+	// Options enabled iff the router is specified as `devp2p`
+	DevP2P *devp2p.Config `toml:"devp2p,omitempty"`
+
+	// Configuration options for the erasure coded broadcast router
+	// Options enabled iff the router is specified as `coded`
+	Coded *coded.Config `toml:"coded,omitempty"`
 }
 
 func GetDefaultConfig() *Config {
 	return &Config{
 		Seed:      &Seed,
 		SeenTTL:   &SeenTTL,
+		Topology:  core.GetDefaultTopologyConfig(),
+		Latency:   pubsub.GetDefaultLatencyConfig(),
+		Bandwidth: pubsub.GetDefaultBandwidthConfig(),
+		Faults:    pubsub.GetDefaultFaultConfig(),
 		GossipSub: gossipsub.GetDefaultConfig(),
+		EpiSub:    episub.GetDefaultConfig(),
+		Plumtree:  plumtree.GetDefaultConfig(),
+		InvSub:    invsub.GetDefaultConfig(),
+		DevP2P:    devp2p.GetDefaultConfig(),
+		Coded:     coded.GetDefaultConfig(),
 	}
 }
 
+// A simulation constructed from the config that is yet to be run
+// The scheduler can be advanced incrementally to inspect the nodes in between
+type Simulation struct {
+	Sched   *core.Scheduler
+	Net     *pubsub.Network
+	Latency pubsub.LatencyModel
+	// nodes spawned at the start, see Churn for the nodes currently online
+	Nodes []*pubsub.Node
+	// nil without churn
+	Churn *Churn
+}
+
+// Runs the simulation to completion and returns the final stats
 func Simulate(cfg *Config, logger *zap.Logger) (*core.Stats, error) {
+	simulation, err := NewSimulation(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := simulation.Run(); err != nil {
+		return nil, err
+	}
+	stats := simulation.Net.GetFinalStats()
+	return &stats, nil
+}
+
+// Runs the scheduler to completion
+// Returns the error that stopped the run early, if any
+func (simulation *Simulation) Run() error {
+	simulation.Sched.Run()
+	if simulation.Churn != nil {
+		return simulation.Churn.Err()
+	}
+	return nil
+}
+
+func NewSimulation(cfg *Config, logger *zap.Logger) (*Simulation, error) {
 	var err error
 
 	// Fix seed for reproducible runs
 	// multiple simulations can run in parallel
 	// however, a single simulation cannot be parallelized if reproducibility is desired
-	rng := exprand.NewSource(42)
+	if cfg.Seed == nil {
+		return nil, UnspecSeedErr
+	}
+	rng := exprand.NewSource(*cfg.Seed)
 
 	// triggers events in chronological order
 	if cfg.RunDuration == nil {
@@ -85,68 +200,194 @@ func Simulate(cfg *Config, logger *zap.Logger) (*core.Stats, error) {
 	}
 
 	// construct the static network topology
-	if cfg.TotalPeers == nil {
-		return nil, UnspecNumPeerErr
-	}
-	topology, err := core.NewGraph(*cfg.TotalPeers, rng)
+	topology, err := newTopology(cfg, rng)
 	if err != nil {
 		return nil, err
 	}
+	numNodes := topology.Nodes().Len()
+	if components := topo.ConnectedComponents(topology); len(components) > 1 {
+		log.Printf("WARNING: The topology has %v disconnected components\n", len(components))
+	}
 
 	// latency simulator
-	net, err := pubsub.NewNetwork(sched, *cfg.SeenTTL, rng, logger)
+	latency, err := newLatencyModel(cfg, rng)
 	if err != nil {
 		return nil, err
 	}
-
-	if cfg.BlockInterval == nil {
-		return nil, UnspecBlockDurErr
+	net, err := pubsub.NewNetwork(sched, *cfg.SeenTTL, latency, rng, logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := setBandwidths(topology, net, cfg.Bandwidth); err != nil {
+		return nil, err
 	}
-	oracle, err := core.NewBlockGenerator(sched, *cfg.BlockInterval, rng, logger)
+	if err := setLinkParams(topology, net); err != nil {
+		return nil, err
+	}
+
+	generators, err := newTopicGenerators(sched, cfg, rng, logger)
 	if err != nil {
 		return nil, err
 	}
 
 	// spawn and connect the nodes to their neighbors
-	log.Printf("Spawning %v new nodes in the network\n", *cfg.TotalPeers)
-	err = spawnNewNodes(sched, topology, net, oracle, cfg, rng, logger)
+	log.Printf("Spawning %v new nodes in the network\n", numNodes)
+	nodes, err := spawnNewNodes(sched, topology, net, generators, cfg, rng, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	sched.Run()
-	stats := net.GetFinalStats()
-	return &stats, nil
+	// faults refer to the nodes in the network
+	if cfg.Faults != nil {
+		if err := net.ConfigureFaults(cfg.Faults); err != nil {
+			return nil, err
+		}
+	}
+
+	var churn *Churn
+	if cfg.Churn != nil {
+		churn, err = NewChurn(sched, net, generators, nodes, cfg, rng, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Simulation{
+		Sched:   sched,
+		Net:     net,
+		Latency: latency,
+		Nodes:   nodes,
+		Churn:   churn,
+	}, nil
+}
+
+// The topology is either generated for the configured number of peers or loaded from a file
+// The default topology is generated if unspecified
+func newTopology(cfg *Config, rng exprand.Source) (graph.Undirected, error) {
+	topologyCfg := cfg.Topology
+	if topologyCfg == nil {
+		topologyCfg = core.GetDefaultTopologyConfig()
+	}
+
+	if topologyCfg.Kind != nil && *topologyCfg.Kind == core.FromFile {
+		topology, err := core.LoadGraph(topologyCfg)
+		if err != nil {
+			return nil, err
+		}
+		// the number of peers need not be configured when loading from a file
+		if cfg.TotalPeers != nil && *cfg.TotalPeers != topology.Nodes().Len() {
+			return nil, PeerCountMismatchErr
+		}
+		return topology, nil
+	}
+
+	if cfg.TotalPeers == nil {
+		return nil, UnspecNumPeerErr
+	}
+	return core.NewGraph(*cfg.TotalPeers, topologyCfg, rng)
+}
+
+func newLatencyModel(cfg *Config, rng exprand.Source) (pubsub.LatencyModel, error) {
+	// avoids returning a nil pointer wrapped in a non-nil interface on error
+	if cfg.Regions != nil {
+		latency, err := pubsub.NewRegionLatency(cfg.Regions, rng)
+		if err != nil {
+			return nil, err
+		}
+		return latency, nil
+	}
+
+	// the default latency model if unspecified
+	latencyCfg := cfg.Latency
+	if latencyCfg == nil {
+		latencyCfg = pubsub.GetDefaultLatencyConfig()
+	}
+	latency, err := pubsub.NewDistLatency(latencyCfg, rng)
+	if err != nil {
+		return nil, err
+	}
+	return latency, nil
+}
+
+func setBandwidths(topology graph.Undirected, net *pubsub.Network, cfg *pubsub.BandwidthConfig) error {
+	for _, node := range core.GetNodeSlice(topology.Nodes()) {
+		if err := setBandwidth(node.ID(), net, cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setBandwidth(nodeID int64, net *pubsub.Network, cfg *pubsub.BandwidthConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Upload == nil || cfg.Download == nil {
+		return UnspecBandwidthErr
+	}
+	return net.SetBandwidth(nodeID, *cfg.Upload, *cfg.Download)
+}
+
+// Links loaded from a file may specify their own latencies, bandwidths and loss probabilities
+func setLinkParams(topology graph.Undirected, net *pubsub.Network) error {
+	for _, node := range core.GetNodeSlice(topology.Nodes()) {
+		for _, neighbor := range core.GetNodeSlice(topology.From(node.ID())) {
+			if neighbor.ID() < node.ID() {
+				// visit every link once
+				continue
+			}
+			edge, ok := topology.EdgeBetween(node.ID(), neighbor.ID()).(*core.LinkEdge)
+			if !ok {
+				continue
+			}
+			if edge.Latency > 0 {
+				net.SetLinkLatency(node.ID(), neighbor.ID(), edge.Latency)
+			}
+			if err := net.SetLinkBandwidth(node.ID(), neighbor.ID(), edge.Bandwidth); err != nil {
+				return err
+			}
+			if edge.Loss >= 0 {
+				if err := net.SetLinkLoss(node.ID(), neighbor.ID(), edge.Loss); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
 }
 
 func spawnNewNodes(
 	sched *core.Scheduler,
 	topology graph.Undirected,
 	net *pubsub.Network,
-	oracle *core.OracleBlockGenerator,
+	generators []*topicGenerator,
 	cfg *Config,
 	rng exprand.Source,
 	logger *zap.Logger,
-) error {
+) ([]*pubsub.Node, error) {
 	pubSubNodes := []*pubsub.Node{}
-	nodeIt := topology.Nodes()
-	for nodeIt.Next() {
-		nodeID := nodeIt.Node().ID()
-		pubSubNode, err := spawnNewNode(sched, net, oracle, cfg, nodeID, rng, logger)
+	// nodes and neighbors are visited in the order of their IDs for reproducibility
+	for _, node := range core.GetNodeSlice(topology.Nodes()) {
+		nodeID := node.ID()
+		pubSubNode, err := spawnNewNode(sched, net, cfg, nodeID, rng, logger)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		joinTopics(pubSubNode, generators, rng)
 		pubSubNodes = append(pubSubNodes, pubSubNode)
 	}
 
+	nodesByID := map[int64]*pubsub.Node{}
+	for _, pubSubNode := range pubSubNodes {
+		nodesByID[pubSubNode.ID()] = pubSubNode
+	}
 	for _, pubSubNode := range pubSubNodes {
 		// Connect with its peers
 		// The connections are made in only one direction (send paths)
 		//   the reverse direction is handled by its neighbor
-		neighborIt := topology.From(int64(pubSubNode.ID()))
-		for neighborIt.Next() {
-			neighborID := neighborIt.Node().ID()
-			pubSubNode.AddPeer(neighborID)
+		for _, neighbor := range core.GetNodeSlice(topology.From(pubSubNode.ID())) {
+			pubSubNode.AddPeer(neighbor.ID())
+			pubSubNode.SetPeerTopics(neighbor.ID(), nodesByID[neighbor.ID()].Topics)
 		}
 	}
 
@@ -154,32 +395,54 @@ func spawnNewNodes(
 	for _, pubSubNode := range pubSubNodes {
 		err := pubSubNode.Start(logger)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return pubSubNodes, nil
 }
 
 func spawnNewNode(
 	sched *core.Scheduler,
 	net *pubsub.Network,
-	oracle *core.OracleBlockGenerator,
 	cfg *Config,
 	nodeID int64,
 	rng exprand.Source,
 	logger *zap.Logger,
 ) (*pubsub.Node, error) {
+	router, err := newRouter(cfg, rng)
+	if err != nil {
+		return nil, err
+	}
+	node, err := pubsub.SpawnNewNode(sched, net, *cfg.SeenTTL, router, nodeID, rng, logger)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CutThrough != nil && *cfg.CutThrough {
+		node.EnableCutThrough()
+	}
+	return node, nil
+}
+
+func newRouter(cfg *Config, rng exprand.Source) (pubsub.Router, error) {
 	if cfg.Router == nil {
 		return nil, UnspecRouterErr
 	}
 	switch *cfg.Router {
 	case FloodSub:
-		router := floodsub.NewRouter()
-		return pubsub.SpawnNewNode(sched, net, oracle, *cfg.SeenTTL, router, nodeID, rng, logger)
+		return floodsub.NewRouter(), nil
 	case GossipSub:
-		router := gossipsub.NewRouter(cfg.GossipSub, rng)
-		return pubsub.SpawnNewNode(sched, net, oracle, *cfg.SeenTTL, router, nodeID, rng, logger)
+		return gossipsub.NewRouter(cfg.GossipSub, rng), nil
+	case EpiSub:
+		return episub.NewRouter(cfg.EpiSub, rng), nil
+	case Plumtree:
+		return plumtree.NewRouter(cfg.Plumtree), nil
+	case InvSub:
+		return invsub.NewRouter(cfg.InvSub), nil
+	case DevP2P:
+		return devp2p.NewRouter(cfg.DevP2P, rng), nil
+	case Coded:
+		return coded.NewRouter(cfg.Coded, rng), nil
 	default:
 		return nil, UnknownRouterErr
 	}
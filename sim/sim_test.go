@@ -1,14 +1,25 @@
 package sim
 
 import (
+	"errors"
+	"fmt"
 	"math"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/marlinprotocol/p2psim/coded"
 	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/devp2p"
+	"github.com/marlinprotocol/p2psim/episub"
 	"github.com/marlinprotocol/p2psim/gossipsub"
+	"github.com/marlinprotocol/p2psim/invsub"
+	"github.com/marlinprotocol/p2psim/plumtree"
 	"github.com/marlinprotocol/p2psim/pubsub"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestFloodSub(t *testing.T) {
@@ -152,3 +163,953 @@ func TestGossipSubWithHeartbeats(t *testing.T) {
 		t.Error("Traffic from gossipsub cannot be higher than that of floodsub!")
 	}
 }
+
+// identical configs must produce identical event logs and stats
+func TestReproducibleRuns(t *testing.T) {
+	router := GossipSub
+	heartbeatInterval := 1 * time.Second
+	routerConfig := gossipsub.GetDefaultConfig()
+	routerConfig.HeartbeatInterval = &heartbeatInterval
+
+	runOnce := func(seed uint64) (*core.Stats, []observer.LoggedEntry) {
+		dur := 5 * time.Minute
+		numPeers := 128
+		seenTTL := 2 * time.Minute
+		blockInterval := 15 * time.Second
+		cfg := &Config{
+			Seed:          &seed,
+			RunDuration:   &dur,
+			TotalPeers:    &numPeers,
+			Topology:      core.GetDefaultTopologyConfig(),
+			Latency:       pubsub.GetDefaultLatencyConfig(),
+			SeenTTL:       &seenTTL,
+			BlockInterval: &blockInterval,
+			Router:        &router,
+			GossipSub:     routerConfig,
+		}
+		obsCore, logs := observer.New(zap.DebugLevel)
+		stats, err := Simulate(cfg, zap.New(obsCore))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return stats, logs.AllUntimed()
+	}
+
+	firstStats, firstLogs := runOnce(7)
+	secondStats, secondLogs := runOnce(7)
+	if !reflect.DeepEqual(*firstStats, *secondStats) {
+		t.Errorf("Stats differ across runs: %v, %v", *firstStats, *secondStats)
+	}
+	if len(firstLogs) != len(secondLogs) {
+		t.Fatalf("Number of events differ across runs: %v, %v", len(firstLogs), len(secondLogs))
+	}
+	for i := range firstLogs {
+		if !reflect.DeepEqual(firstLogs[i], secondLogs[i]) {
+			t.Fatalf("Event %v differs across runs: %v, %v", i, firstLogs[i], secondLogs[i])
+		}
+	}
+
+	// the seed must be honored
+	otherStats, _ := runOnce(8)
+	if reflect.DeepEqual(*firstStats, *otherStats) {
+		t.Error("Different seeds produced identical stats!")
+	}
+}
+
+// advancing the simulation incrementally must not affect the outcome
+func TestIncrementalRun(t *testing.T) {
+	seed := uint64(42)
+	dur := 5 * time.Minute
+	numPeers := 128
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	router := FloodSub
+	cfg := &Config{
+		Seed:          &seed,
+		RunDuration:   &dur,
+		TotalPeers:    &numPeers,
+		Topology:      core.GetDefaultTopologyConfig(),
+		Latency:       pubsub.GetDefaultLatencyConfig(),
+		SeenTTL:       &seenTTL,
+		BlockInterval: &blockInterval,
+		Router:        &router,
+	}
+	nullLogger := zap.L()
+	stats, err := Simulate(cfg, nullLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	simulation, err := NewSimulation(cfg, nullLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(simulation.Nodes) != numPeers {
+		t.Errorf("Spawned %v nodes, expected %v", len(simulation.Nodes), numPeers)
+	}
+	simulation.Sched.RunFor(time.Minute)
+	simulation.Sched.RunWhile(func() bool {
+		return simulation.Sched.NumTriggered < 10_000
+	})
+	simulation.Sched.Run()
+	incrementalStats := simulation.Net.GetFinalStats()
+	if !reflect.DeepEqual(*stats, incrementalStats) {
+		t.Errorf("Stats differ on running incrementally: %v, %v", *stats, incrementalStats)
+	}
+}
+
+// every link of a complete graph loaded from a file has the same fixed latency
+func TestTopologyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.txt")
+	contents := ""
+	numPeers := 5
+	for u := 0; u < numPeers; u++ {
+		for v := u + 1; v < numPeers; v++ {
+			contents += fmt.Sprintf("peer%v peer%v latency=7\n", u, v)
+		}
+	}
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	seed := uint64(42)
+	dur := 10 * time.Minute
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	router := FloodSub
+	kind := core.FromFile
+	cfg := &Config{
+		Seed:        &seed,
+		RunDuration: &dur,
+		Topology: &core.TopologyConfig{
+			Kind: &kind,
+			File: &path,
+		},
+		Latency:       pubsub.GetDefaultLatencyConfig(),
+		SeenTTL:       &seenTTL,
+		BlockInterval: &blockInterval,
+		Router:        &router,
+	}
+	nullLogger := zap.L()
+	stats, err := Simulate(cfg, nullLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.DelayMsPerMsg.Value != 7 {
+		t.Errorf("Simulated mean delay: %v", stats.DelayMsPerMsg.Value)
+	}
+	if stats.DeliveredPart.Value != 100 {
+		t.Errorf("Simulated mean delivery percent: %v", stats.DeliveredPart.Value)
+	}
+
+	otherNumPeers := numPeers + 1
+	cfg.TotalPeers = &otherNumPeers
+	if _, err := Simulate(cfg, nullLogger); !errors.Is(err, PeerCountMismatchErr) {
+		t.Errorf("Got %v, expected %v", err, PeerCountMismatchErr)
+	}
+}
+
+func TestInvLatency(t *testing.T) {
+	seed := uint64(42)
+	dur := time.Minute
+	numPeers := 16
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	router := FloodSub
+	latencyCfg := pubsub.GetDefaultLatencyConfig()
+	spikeProb := 1.5
+	latencyCfg.SpikeProb = &spikeProb
+	cfg := &Config{
+		Seed:          &seed,
+		RunDuration:   &dur,
+		TotalPeers:    &numPeers,
+		Topology:      core.GetDefaultTopologyConfig(),
+		Latency:       latencyCfg,
+		SeenTTL:       &seenTTL,
+		BlockInterval: &blockInterval,
+		Router:        &router,
+	}
+	if _, err := Simulate(cfg, zap.L()); !errors.Is(err, pubsub.InvLatencyParamErr) {
+		t.Errorf("Got %v, expected %v", err, pubsub.InvLatencyParamErr)
+	}
+}
+
+// every node of a complete graph is located in the same region
+func TestRegions(t *testing.T) {
+	dir := t.TempDir()
+	topologyPath := filepath.Join(dir, "peers.txt")
+	contents := ""
+	numPeers := 5
+	for u := 0; u < numPeers; u++ {
+		for v := u + 1; v < numPeers; v++ {
+			contents += fmt.Sprintf("peer%v peer%v\n", u, v)
+		}
+	}
+	if err := os.WriteFile(topologyPath, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	matrixPath := filepath.Join(dir, "regions.csv")
+	if err := os.WriteFile(matrixPath, []byte("region,us,eu\nus,20,90\neu,90,15\n"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	seed := uint64(42)
+	dur := 10 * time.Minute
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	router := FloodSub
+	kind := core.FromFile
+	jitterKind := pubsub.ConstantModel
+	jitter := 5.0
+	cfg := &Config{
+		Seed:        &seed,
+		RunDuration: &dur,
+		Topology: &core.TopologyConfig{
+			Kind: &kind,
+			File: &topologyPath,
+		},
+		Latency: pubsub.GetDefaultLatencyConfig(),
+		Regions: &pubsub.RegionConfig{
+			MatrixFile: &matrixPath,
+			Weights: map[string]float64{
+				"eu": 1,
+			},
+			Jitter: &pubsub.LatencyConfig{
+				Kind:  &jitterKind,
+				Value: &jitter,
+			},
+		},
+		SeenTTL:       &seenTTL,
+		BlockInterval: &blockInterval,
+		Router:        &router,
+	}
+	simulation, err := NewSimulation(cfg, zap.L())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, node := range simulation.Nodes {
+		if region := simulation.Latency.(*pubsub.RegionLatency).Region(node.ID()); region != "eu" {
+			t.Errorf("Node %v is located in %v", node.ID(), region)
+		}
+	}
+
+	simulation.Sched.Run()
+	stats := simulation.Net.GetFinalStats()
+	if stats.DelayMsPerMsg.Value != 15+jitter {
+		t.Errorf("Simulated mean delay: %v", stats.DelayMsPerMsg.Value)
+	}
+}
+
+// flooding full blocks to every peer saturates the uplinks
+func TestBandwidth(t *testing.T) {
+	seed := uint64(42)
+	dur := 10 * time.Minute
+	numPeers := 128
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	router := FloodSub
+	cfg := &Config{
+		Seed:          &seed,
+		RunDuration:   &dur,
+		TotalPeers:    &numPeers,
+		Topology:      core.GetDefaultTopologyConfig(),
+		Latency:       pubsub.GetDefaultLatencyConfig(),
+		Bandwidth:     pubsub.GetDefaultBandwidthConfig(),
+		SeenTTL:       &seenTTL,
+		BlockInterval: &blockInterval,
+		Router:        &router,
+	}
+	nullLogger := zap.L()
+	unlimitedStats, err := Simulate(cfg, nullLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	upload, download := 10.0, 100.0
+	cfg.Bandwidth = &pubsub.BandwidthConfig{
+		Upload:   &upload,
+		Download: &download,
+	}
+	stats, err := Simulate(cfg, nullLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// messages in flight at the end of the run are counted partially
+	// 1% tolerance
+	if math.Abs(stats.TrafficPerMsg.Value-unlimitedStats.TrafficPerMsg.Value) > 0.01*unlimitedStats.TrafficPerMsg.Value {
+		t.Errorf("Traffic differs with limited bandwidth: %v, %v", stats.TrafficPerMsg, unlimitedStats.TrafficPerMsg)
+	}
+	// every node uploads the block to about 16 peers at 10 Mbit/s
+	//   each copy takes about 40ms and the last copy is sent after about 640ms
+	if stats.DelayMsPerMsg.Value < unlimitedStats.DelayMsPerMsg.Value+300 {
+		t.Errorf("Simulated mean delay: %v, unlimited: %v", stats.DelayMsPerMsg.Value, unlimitedStats.DelayMsPerMsg.Value)
+	}
+}
+
+// lazy gossip recovers the messages lost by the mesh
+func TestGossipSubLoss(t *testing.T) {
+	seed := uint64(42)
+	dur := 15 * time.Minute
+	numPeers := 128
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	router := GossipSub
+	lossProb := 0.7
+	cfg := &Config{
+		Seed:          &seed,
+		RunDuration:   &dur,
+		TotalPeers:    &numPeers,
+		Topology:      core.GetDefaultTopologyConfig(),
+		Latency:       pubsub.GetDefaultLatencyConfig(),
+		Faults:        &pubsub.FaultConfig{LossProb: &lossProb},
+		SeenTTL:       &seenTTL,
+		BlockInterval: &blockInterval,
+		Router:        &router,
+		GossipSub:     gossipsub.GetDefaultConfig(),
+	}
+	nullLogger := zap.L()
+	stats, err := Simulate(cfg, nullLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// without gossip
+	heartbeatInterval := time.Hour
+	cfg.GossipSub.HeartbeatInterval = &heartbeatInterval
+	noGossipStats, err := Simulate(cfg, nullLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.DeliveredPart.Value <= noGossipStats.DeliveredPart.Value {
+		t.Errorf(
+			"Simulated mean delivery percent: %v, without gossip: %v",
+			stats.DeliveredPart.Value,
+			noGossipStats.DeliveredPart.Value,
+		)
+	}
+}
+
+// the network size stays around arrival_rate * mean session length
+func TestChurn(t *testing.T) {
+	seed := uint64(42)
+	dur := time.Hour
+	numPeers := 128
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	sessionKind := ExponentialSession
+	meanSession := 10 * time.Minute
+	arrivalRate := 12.8
+	for _, router := range []string{FloodSub, GossipSub} {
+		cfg := &Config{
+			Seed:          &seed,
+			RunDuration:   &dur,
+			TotalPeers:    &numPeers,
+			Topology:      core.GetDefaultTopologyConfig(),
+			Latency:       pubsub.GetDefaultLatencyConfig(),
+			SeenTTL:       &seenTTL,
+			BlockInterval: &blockInterval,
+			Router:        &router,
+			GossipSub:     gossipsub.GetDefaultConfig(),
+			Churn: &ChurnConfig{
+				ArrivalRate: &arrivalRate,
+				Session: &SessionConfig{
+					Kind: &sessionKind,
+					Mean: &meanSession,
+				},
+			},
+		}
+		simulation, err := NewSimulation(cfg, zap.L())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		simulation.Sched.Run()
+		stats := simulation.Net.GetFinalStats()
+
+		// 50% tolerance since the size of the network fluctuates
+		onlineNodes := simulation.Churn.OnlineNodes()
+		if math.Abs(float64(len(onlineNodes)-numPeers)) > 0.5*float64(numPeers) {
+			t.Errorf("%v: %v nodes online", router, len(onlineNodes))
+		}
+		online := map[int64]bool{}
+		for _, node := range onlineNodes {
+			online[node.ID()] = true
+		}
+		for _, node := range onlineNodes {
+			node.NeighborIDs.Traverse(func(iNeighborID interface{}) {
+				if !online[iNeighborID.(int64)] {
+					t.Errorf("%v: node %v is connected to a departed node %v", router, node.ID(), iNeighborID)
+				}
+			})
+		}
+
+		// nodes offline at the time of publishing are not expected to receive the message
+		if stats.DeliveredPart.Value < 99 {
+			t.Errorf("%v: simulated mean delivery percent: %v", router, stats.DeliveredPart.Value)
+		}
+	}
+}
+
+// a node that cannot be spawned stops the run with the error
+func TestChurnErr(t *testing.T) {
+	seed := uint64(42)
+	dur := time.Hour
+	numPeers := 16
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	router := FloodSub
+	sessionKind := ExponentialSession
+	meanSession := 10 * time.Minute
+	arrivalRate := 1.6
+	cfg := &Config{
+		Seed:          &seed,
+		RunDuration:   &dur,
+		TotalPeers:    &numPeers,
+		SeenTTL:       &seenTTL,
+		BlockInterval: &blockInterval,
+		Router:        &router,
+		Churn: &ChurnConfig{
+			ArrivalRate: &arrivalRate,
+			Session: &SessionConfig{
+				Kind: &sessionKind,
+				Mean: &meanSession,
+			},
+		},
+	}
+	simulation, err := NewSimulation(cfg, zap.L())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the bandwidths of the new nodes are unspecified
+	cfg.Bandwidth = &pubsub.BandwidthConfig{}
+	if err := simulation.Run(); !errors.Is(err, UnspecBandwidthErr) {
+		t.Errorf("Got %v, expected %v", err, UnspecBandwidthErr)
+	}
+	if simulation.Sched.IsStopped() {
+		t.Errorf("Run went on after the error")
+	}
+}
+
+// messages of a topic reach its subscribers and not the other nodes
+func TestTopics(t *testing.T) {
+	seed := uint64(42)
+	dur := 10 * time.Minute
+	numPeers := 128
+	seenTTL := 2 * time.Minute
+	blockTopic, txTopic := "blocks", "txs"
+	blockInterval, txInterval := 15*time.Second, 2*time.Second
+	txSize := int64(512)
+	txFraction := 0.5
+	for _, router := range []string{FloodSub, GossipSub} {
+		cfg := &Config{
+			Seed:        &seed,
+			RunDuration: &dur,
+			TotalPeers:  &numPeers,
+			Topology:    core.GetDefaultTopologyConfig(),
+			Latency:     pubsub.GetDefaultLatencyConfig(),
+			SeenTTL:     &seenTTL,
+			Router:      &router,
+			GossipSub:   gossipsub.GetDefaultConfig(),
+			Topics: []*TopicConfig{
+				{
+					Name:        &blockTopic,
+					MsgInterval: &blockInterval,
+				},
+				{
+					Name:              &txTopic,
+					MsgInterval:       &txInterval,
+					MsgSize:           &txSize,
+					SubscribeFraction: &txFraction,
+				},
+			},
+		}
+		simulation, err := NewSimulation(cfg, zap.L())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		numSubscribers := 0
+		for _, node := range simulation.Nodes {
+			if node.Topics.Exists(txTopic) {
+				numSubscribers++
+			}
+		}
+		if math.Abs(float64(numSubscribers)-txFraction*float64(numPeers)) > 0.2*float64(numPeers) {
+			t.Errorf("%v: %v nodes subscribed to the transactions", router, numSubscribers)
+		}
+		simulation.Sched.Run()
+		stats := simulation.Net.GetFinalStats()
+
+		blockStats, txStats := stats.Topics[blockTopic], stats.Topics[txTopic]
+		if blockStats.DeliveredPart.Value < 99 {
+			t.Errorf("%v: simulated mean delivery percent of the blocks: %v", router, blockStats.DeliveredPart.Value)
+		}
+		// the subscribers of the transactions may not be connected to each other
+		if txStats.DeliveredPart.Value < 80 {
+			t.Errorf("%v: simulated mean delivery percent of the transactions: %v", router, txStats.DeliveredPart.Value)
+		}
+		if txStats.PacketCountPerMsg.Value > float64(numPeers*core.AvgDeg) {
+			t.Errorf("%v: transactions sent to the nodes not subscribed: %v packets", router, txStats.PacketCountPerMsg.Value)
+		}
+		if blockStats.DelayMsPerMsg.Count+txStats.DelayMsPerMsg.Count != stats.DelayMsPerMsg.Count {
+			t.Errorf("%v: messages of the topics do not add up", router)
+		}
+	}
+}
+
+func TestInvTopics(t *testing.T) {
+	seed := uint64(42)
+	dur := time.Minute
+	numPeers := 16
+	seenTTL := 2 * time.Minute
+	router := FloodSub
+	topic := "blocks"
+	interval := 15 * time.Second
+	negSize := int64(-1)
+	fraction := 1.5
+	for _, test := range []struct {
+		topics []*TopicConfig
+		err    error
+	}{
+		{[]*TopicConfig{{MsgInterval: &interval}}, UnspecTopicNameErr},
+		{[]*TopicConfig{{Name: &topic}}, UnspecMsgIntervalErr},
+		{[]*TopicConfig{{Name: &topic, MsgInterval: &interval, MsgSize: &negSize}}, InvMsgSizeErr},
+		{[]*TopicConfig{{Name: &topic, MsgInterval: &interval, SubscribeFraction: &fraction}}, InvSubscribeFracErr},
+		{[]*TopicConfig{{Name: &topic, MsgInterval: &interval, PublishFraction: &fraction}}, InvPublishFracErr},
+		{[]*TopicConfig{{Name: &topic, MsgInterval: &interval}, {Name: &topic, MsgInterval: &interval}}, DuplicateTopicErr},
+		{[]*TopicConfig{}, UnspecBlockDurErr},
+	} {
+		cfg := &Config{
+			Seed:        &seed,
+			RunDuration: &dur,
+			TotalPeers:  &numPeers,
+			Topology:    core.GetDefaultTopologyConfig(),
+			Latency:     pubsub.GetDefaultLatencyConfig(),
+			SeenTTL:     &seenTTL,
+			Router:      &router,
+			Topics:      test.topics,
+		}
+		if _, err := NewSimulation(cfg, zap.L()); !errors.Is(err, test.err) {
+			t.Errorf("Expected %v, got %v", test.err, err)
+		}
+	}
+}
+
+// nodes publishing without subscribing reach the subscribers through their fanout peers
+func TestFanout(t *testing.T) {
+	seed := uint64(42)
+	dur := 10 * time.Minute
+	numPeers := 128
+	seenTTL := 2 * time.Minute
+	router := GossipSub
+	txTopic := "txs"
+	txInterval := 2 * time.Second
+	txSize := int64(512)
+	subscribeFraction, publishFraction := 0.5, 0.3
+	cfg := &Config{
+		Seed:        &seed,
+		RunDuration: &dur,
+		TotalPeers:  &numPeers,
+		Topology:    core.GetDefaultTopologyConfig(),
+		Latency:     pubsub.GetDefaultLatencyConfig(),
+		SeenTTL:     &seenTTL,
+		Router:      &router,
+		GossipSub:   gossipsub.GetDefaultConfig(),
+		Topics: []*TopicConfig{{
+			Name:              &txTopic,
+			MsgInterval:       &txInterval,
+			MsgSize:           &txSize,
+			SubscribeFraction: &subscribeFraction,
+			PublishFraction:   &publishFraction,
+		}},
+	}
+	simulation, err := NewSimulation(cfg, zap.L())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	simulation.Sched.Run()
+	stats := simulation.Net.GetFinalStats()
+
+	// the messages are published irrespective of the number of publishers
+	if math.Abs(float64(stats.PacketCountPerMsg.Count)-dur.Seconds()/txInterval.Seconds()) > 30 {
+		t.Errorf("Published %v messages", stats.PacketCountPerMsg.Count)
+	}
+	if stats.DeliveredPart.Value < 99 {
+		t.Errorf("Simulated mean delivery percent: %v", stats.DeliveredPart.Value)
+	}
+}
+
+// flood publishing trades traffic for latency
+func TestFloodPublish(t *testing.T) {
+	stats := []*core.Stats{}
+	for _, floodPublish := range []bool{false, true} {
+		seed := uint64(42)
+		dur := 10 * time.Minute
+		numPeers := 256
+		seenTTL := 2 * time.Minute
+		blockInterval := 15 * time.Second
+		router := GossipSub
+		routerConfig := gossipsub.GetDefaultConfig()
+		routerConfig.FloodPublish = &floodPublish
+		cfg := &Config{
+			Seed:          &seed,
+			RunDuration:   &dur,
+			TotalPeers:    &numPeers,
+			Topology:      core.GetDefaultTopologyConfig(),
+			Latency:       pubsub.GetDefaultLatencyConfig(),
+			SeenTTL:       &seenTTL,
+			BlockInterval: &blockInterval,
+			Router:        &router,
+			GossipSub:     routerConfig,
+		}
+		runStats, err := Simulate(cfg, zap.L())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		stats = append(stats, runStats)
+	}
+
+	if stats[1].TrafficPerMsg.Value <= stats[0].TrafficPerMsg.Value {
+		t.Errorf("Traffic %v with flood publishing, %v without", stats[1].TrafficPerMsg.Value, stats[0].TrafficPerMsg.Value)
+	}
+	if stats[1].DelayMsPerMsg.Value >= stats[0].DelayMsPerMsg.Value {
+		t.Errorf("Delay %v with flood publishing, %v without", stats[1].DelayMsPerMsg.Value, stats[0].DelayMsPerMsg.Value)
+	}
+}
+
+// IDONTWANT reduces the duplicates of large messages on the same seed
+// With limited bandwidth, the IDONTWANT reaches a peer well before it receives the large message and forwards it back
+func TestIDontWant(t *testing.T) {
+	stats := []*core.Stats{}
+	for _, idontwant := range []bool{false, true} {
+		seed := uint64(42)
+		dur := 10 * time.Minute
+		numPeers := 256
+		seenTTL := 2 * time.Minute
+		blockInterval := 15 * time.Second
+		router := GossipSub
+		routerConfig := gossipsub.GetDefaultConfig()
+		routerConfig.DoIDontWant = &idontwant
+		upload, download := 10.0, 100.0
+		cfg := &Config{
+			Seed:          &seed,
+			RunDuration:   &dur,
+			TotalPeers:    &numPeers,
+			Topology:      core.GetDefaultTopologyConfig(),
+			Latency:       pubsub.GetDefaultLatencyConfig(),
+			Bandwidth:     &pubsub.BandwidthConfig{Upload: &upload, Download: &download},
+			SeenTTL:       &seenTTL,
+			BlockInterval: &blockInterval,
+			Router:        &router,
+			GossipSub:     routerConfig,
+		}
+		runStats, err := Simulate(cfg, zap.L())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		stats = append(stats, runStats)
+	}
+
+	if stats[1].DuplicateTrafficPerMsg.Value >= stats[0].DuplicateTrafficPerMsg.Value {
+		t.Errorf(
+			"Duplicate traffic %v with IDONTWANT, %v without",
+			stats[1].DuplicateTrafficPerMsg.Value,
+			stats[0].DuplicateTrafficPerMsg.Value,
+		)
+	}
+}
+
+// episub pushes the messages along the active views and lazily to the choked peers
+func TestEpiSub(t *testing.T) {
+	seed := uint64(42)
+	dur := 10 * time.Minute
+	numPeers := 256
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	router := EpiSub
+	cfg := &Config{
+		Seed:          &seed,
+		RunDuration:   &dur,
+		TotalPeers:    &numPeers,
+		Topology:      core.GetDefaultTopologyConfig(),
+		Latency:       pubsub.GetDefaultLatencyConfig(),
+		SeenTTL:       &seenTTL,
+		BlockInterval: &blockInterval,
+		Router:        &router,
+		EpiSub:        episub.GetDefaultConfig(),
+	}
+	stats, err := Simulate(cfg, zap.L())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	floodsubTraffic := pubsub.BlockSize * core.AvgDeg * float64(numPeers)
+	if stats.TrafficPerMsg.Value > floodsubTraffic {
+		t.Errorf("Simulated mean traffic %v above that of floodsub %v", stats.TrafficPerMsg.Value, floodsubTraffic)
+	}
+	// require more than 99% delivery guarantee
+	if stats.DeliveredPart.Value < 99 {
+		t.Errorf("Simulated mean delivery percent: %v", stats.DeliveredPart.Value)
+	}
+}
+
+// the eager links of plumtree converge to a spanning tree with fewer duplicates than the gossipsub mesh
+func TestPlumtree(t *testing.T) {
+	stats := []*core.Stats{}
+	for _, router := range []string{GossipSub, Plumtree} {
+		seed := uint64(42)
+		dur := 10 * time.Minute
+		numPeers := 256
+		seenTTL := 2 * time.Minute
+		blockInterval := 15 * time.Second
+		router := router
+		cfg := &Config{
+			Seed:          &seed,
+			RunDuration:   &dur,
+			TotalPeers:    &numPeers,
+			Topology:      core.GetDefaultTopologyConfig(),
+			Latency:       pubsub.GetDefaultLatencyConfig(),
+			SeenTTL:       &seenTTL,
+			BlockInterval: &blockInterval,
+			Router:        &router,
+			GossipSub:     gossipsub.GetDefaultConfig(),
+			Plumtree:      plumtree.GetDefaultConfig(),
+		}
+		runStats, err := Simulate(cfg, zap.L())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		stats = append(stats, runStats)
+	}
+
+	if stats[1].DuplicateTrafficPerMsg.Value >= stats[0].DuplicateTrafficPerMsg.Value {
+		t.Errorf(
+			"Duplicate traffic %v with plumtree, %v with gossipsub",
+			stats[1].DuplicateTrafficPerMsg.Value,
+			stats[0].DuplicateTrafficPerMsg.Value,
+		)
+	}
+	// require more than 99% delivery guarantee
+	if stats[1].DeliveredPart.Value < 99 {
+		t.Errorf("Simulated mean delivery percent: %v", stats[1].DeliveredPart.Value)
+	}
+}
+
+// floodsub, invsub and invsub with high bandwidth peers
+func TestInvSub(t *testing.T) {
+	stats := []*core.Stats{}
+	for _, hbPeers := range []int{-1, 0, 3} {
+		seed := uint64(42)
+		// a block published in the last second of a 10 minute run cannot reach the nodes within the round trips of invsub
+		dur := 9 * time.Minute
+		numPeers := 256
+		seenTTL := 2 * time.Minute
+		blockInterval := 15 * time.Second
+		router := InvSub
+		if hbPeers < 0 {
+			router = FloodSub
+		}
+		invCfg := invsub.GetDefaultConfig()
+		hbPeers := hbPeers
+		invCfg.HighBandwidthPeers = &hbPeers
+		cfg := &Config{
+			Seed:          &seed,
+			RunDuration:   &dur,
+			TotalPeers:    &numPeers,
+			Topology:      core.GetDefaultTopologyConfig(),
+			Latency:       pubsub.GetDefaultLatencyConfig(),
+			SeenTTL:       &seenTTL,
+			BlockInterval: &blockInterval,
+			Router:        &router,
+			InvSub:        invCfg,
+		}
+		runStats, err := Simulate(cfg, zap.L())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		stats = append(stats, runStats)
+	}
+
+	if stats[1].TrafficPerMsg.Value >= stats[0].TrafficPerMsg.Value {
+		t.Errorf("Traffic %v with invsub, %v with floodsub", stats[1].TrafficPerMsg.Value, stats[0].TrafficPerMsg.Value)
+	}
+	// high bandwidth peers save a round trip per hop
+	if stats[2].DelayMsPerMsg.Value >= stats[1].DelayMsPerMsg.Value {
+		t.Errorf("Delay %v with high bandwidth peers, %v without", stats[2].DelayMsPerMsg.Value, stats[1].DelayMsPerMsg.Value)
+	}
+	// require more than 99% delivery guarantee
+	for _, runStats := range stats[1:] {
+		if runStats.DeliveredPart.Value < 99 {
+			t.Errorf("Simulated mean delivery percent: %v", runStats.DeliveredPart.Value)
+		}
+	}
+}
+
+// floodsub, devp2p and invsub
+func TestDevP2P(t *testing.T) {
+	stats := []*core.Stats{}
+	for _, router := range []string{FloodSub, DevP2P, InvSub} {
+		seed := uint64(42)
+		// a block published in the last second of a 10 minute run cannot reach the nodes within the round trips of invsub
+		dur := 9 * time.Minute
+		numPeers := 256
+		seenTTL := 2 * time.Minute
+		blockInterval := 15 * time.Second
+		router := router
+		cfg := &Config{
+			Seed:          &seed,
+			RunDuration:   &dur,
+			TotalPeers:    &numPeers,
+			Topology:      core.GetDefaultTopologyConfig(),
+			Latency:       pubsub.GetDefaultLatencyConfig(),
+			SeenTTL:       &seenTTL,
+			BlockInterval: &blockInterval,
+			Router:        &router,
+			InvSub:        invsub.GetDefaultConfig(),
+			DevP2P:        devp2p.GetDefaultConfig(),
+		}
+		runStats, err := Simulate(cfg, zap.L())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		stats = append(stats, runStats)
+	}
+
+	// pushing to the square root of the peers trades the traffic of floodsub for the round trips of the announcements
+	if stats[1].TrafficPerMsg.Value >= stats[0].TrafficPerMsg.Value {
+		t.Errorf("Traffic %v with devp2p, %v with floodsub", stats[1].TrafficPerMsg.Value, stats[0].TrafficPerMsg.Value)
+	}
+	if stats[1].DelayMsPerMsg.Value >= stats[2].DelayMsPerMsg.Value {
+		t.Errorf("Delay %v with devp2p, %v with invsub", stats[1].DelayMsPerMsg.Value, stats[2].DelayMsPerMsg.Value)
+	}
+	// require more than 99% delivery guarantee
+	if stats[1].DeliveredPart.Value < 99 {
+		t.Errorf("Simulated mean delivery percent: %v", stats[1].DeliveredPart.Value)
+	}
+}
+
+// floodsub with limited bandwidth forwarding the messages once complete and with cut-through
+func TestCutThrough(t *testing.T) {
+	numPeers := 256
+	stats := []*core.Stats{}
+	for _, cutThrough := range []bool{false, true} {
+		seed := uint64(42)
+		dur := 10 * time.Minute
+		seenTTL := 2 * time.Minute
+		blockInterval := 15 * time.Second
+		router := FloodSub
+		// the chunks are sent to every peer in turn and cut-through pays off with few peers sharing the uplink
+		kind := core.RandomRegular
+		degree := 3
+		upload, download := 10.0, 100.0
+		cutThrough := cutThrough
+		cfg := &Config{
+			Seed:          &seed,
+			RunDuration:   &dur,
+			TotalPeers:    &numPeers,
+			Topology:      &core.TopologyConfig{Kind: &kind, AvgDegree: &degree},
+			Latency:       pubsub.GetDefaultLatencyConfig(),
+			Bandwidth:     &pubsub.BandwidthConfig{Upload: &upload, Download: &download},
+			SeenTTL:       &seenTTL,
+			BlockInterval: &blockInterval,
+			CutThrough:    &cutThrough,
+			Router:        &router,
+		}
+		runStats, err := Simulate(cfg, zap.L())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		stats = append(stats, runStats)
+	}
+
+	if stats[1].DelayMsPerMsg.Value >= stats[0].DelayMsPerMsg.Value {
+		t.Errorf("Delay %v with cut-through, %v without", stats[1].DelayMsPerMsg.Value, stats[0].DelayMsPerMsg.Value)
+	}
+	// every node receives all the chunks of a message at least once
+	numFragments := float64((pubsub.BlockSize + pubsub.MaxPayloadSize - 1) / pubsub.MaxPayloadSize)
+	if stats[1].ChunkCountPerMsg.Value < numFragments*float64(numPeers-1) {
+		t.Errorf("Simulated mean chunk count: %v", stats[1].ChunkCountPerMsg.Value)
+	}
+	// require more than 99% delivery guarantee
+	if stats[1].DeliveredPart.Value < 99 {
+		t.Errorf("Simulated mean delivery percent: %v", stats[1].DeliveredPart.Value)
+	}
+}
+
+// gossipsub and the erasure coded broadcast on the same bandwidth
+func TestCoded(t *testing.T) {
+	stats := []*core.Stats{}
+	for _, router := range []string{GossipSub, Coded} {
+		seed := uint64(42)
+		dur := 10 * time.Minute
+		numPeers := 256
+		seenTTL := 2 * time.Minute
+		blockInterval := 15 * time.Second
+		upload, download := 10.0, 100.0
+		router := router
+		cfg := &Config{
+			Seed:          &seed,
+			RunDuration:   &dur,
+			TotalPeers:    &numPeers,
+			Topology:      core.GetDefaultTopologyConfig(),
+			Latency:       pubsub.GetDefaultLatencyConfig(),
+			Bandwidth:     &pubsub.BandwidthConfig{Upload: &upload, Download: &download},
+			SeenTTL:       &seenTTL,
+			BlockInterval: &blockInterval,
+			Router:        &router,
+			GossipSub:     gossipsub.GetDefaultConfig(),
+			Coded:         coded.GetDefaultConfig(),
+		}
+		runStats, err := Simulate(cfg, zap.L())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		stats = append(stats, runStats)
+	}
+
+	// the publisher uploads the message about once instead of once per mesh peer
+	//   and the nodes relay the chunks as they arrive
+	if stats[1].DelayMsPerMsg.Value >= stats[0].DelayMsPerMsg.Value {
+		t.Errorf("Delay %v with coding, %v with gossipsub", stats[1].DelayMsPerMsg.Value, stats[0].DelayMsPerMsg.Value)
+	}
+	// the default fanout spends about as much traffic as gossipsub, parity chunks included
+	if stats[1].TrafficPerMsg.Value > 1.1*stats[0].TrafficPerMsg.Value {
+		t.Errorf("Traffic %v with coding, %v with gossipsub", stats[1].TrafficPerMsg.Value, stats[0].TrafficPerMsg.Value)
+	}
+	// require more than 99% delivery guarantee
+	if stats[1].DeliveredPart.Value < 99 {
+		t.Errorf("Simulated mean delivery percent: %v", stats[1].DeliveredPart.Value)
+	}
+}
+
+// lazy gossip recovers the messages missing chunks lost with cut-through
+// every chunk is lost independently and most messages lose a chunk on some link
+func TestCutThroughLoss(t *testing.T) {
+	seed := uint64(42)
+	dur := 10 * time.Minute
+	numPeers := 128
+	seenTTL := 2 * time.Minute
+	blockInterval := 15 * time.Second
+	router := GossipSub
+	lossProb := 0.2
+	cutThrough := true
+	cfg := &Config{
+		Seed:          &seed,
+		RunDuration:   &dur,
+		TotalPeers:    &numPeers,
+		Topology:      core.GetDefaultTopologyConfig(),
+		Latency:       pubsub.GetDefaultLatencyConfig(),
+		Faults:        &pubsub.FaultConfig{LossProb: &lossProb},
+		SeenTTL:       &seenTTL,
+		BlockInterval: &blockInterval,
+		CutThrough:    &cutThrough,
+		Router:        &router,
+		GossipSub:     gossipsub.GetDefaultConfig(),
+	}
+	stats, err := Simulate(cfg, zap.L())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// require more than 99% delivery guarantee
+	if stats.DeliveredPart.Value < 99 {
+		t.Errorf("Simulated mean delivery percent: %v", stats.DeliveredPart.Value)
+	}
+}
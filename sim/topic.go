@@ -0,0 +1,162 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/marlinprotocol/p2psim/core"
+	"github.com/marlinprotocol/p2psim/pubsub"
+	"go.uber.org/zap"
+	exprand "golang.org/x/exp/rand"
+)
+
+// Messages are published on topics, ex: blocks, attestations and transactions
+// Every topic has its own message generator picking the publisher of the next message among the publishers
+// - messages of `msg_size` bytes are published at exponentially distributed intervals with the mean `msg_interval`
+// - every node subscribes to the topic with the probability `subscribe_fraction` on joining the network
+//   and publishes on the topic without subscribing with the probability `publish_fraction`, ex: light clients
+// The subscribers publish on the topic as well
+// Without any configured topics, blocks are published on a single topic every block_interval on average
+
+var (
+	UnspecTopicNameErr   = errors.New("Did not configure the name of the topic!")
+	DuplicateTopicErr    = errors.New("Configured the same topic more than once!")
+	UnspecMsgIntervalErr = errors.New("Did not configure the message interval of the topic!")
+	InvMsgSizeErr        = errors.New("Message size of the topic must be positive!")
+	InvSubscribeFracErr  = errors.New("Subscribe fraction of the topic must lie between 0 and 1!")
+	InvPublishFracErr    = errors.New("Publish fraction of the topic must not be negative or exceed 1 - subscribe fraction!")
+)
+
+var (
+	// Default config params
+	SubscribeFraction = 1.0
+	PublishFraction   = 0.0
+)
+
+type TopicConfig struct {
+	Name *string `toml:"name"`
+
+	// Expected time to publish the next message on the topic
+	MsgInterval *time.Duration `toml:"msg_interval"`
+
+	// Size of the messages in bytes, defaults to the block size
+	MsgSize *int64 `toml:"msg_size,omitempty"`
+
+	// Fraction of the nodes subscribing to the topic
+	SubscribeFraction *float64 `toml:"subscribe_fraction,omitempty"`
+
+	// Fraction of the nodes publishing on the topic without subscribing to it
+	PublishFraction *float64 `toml:"publish_fraction,omitempty"`
+}
+
+// Generates the messages of a topic
+type topicGenerator struct {
+	name              string
+	msgSize           int64
+	subscribeFraction float64
+	publishFraction   float64
+	oracle            *core.OracleBlockGenerator
+}
+
+func newTopicGenerators(
+	sched *core.Scheduler,
+	cfg *Config,
+	rng exprand.Source,
+	logger *zap.Logger,
+) ([]*topicGenerator, error) {
+	topicCfgs := cfg.Topics
+	if len(topicCfgs) == 0 {
+		if cfg.BlockInterval == nil {
+			return nil, UnspecBlockDurErr
+		}
+		name := pubsub.DefaultTopic
+		topicCfgs = []*TopicConfig{{
+			Name:        &name,
+			MsgInterval: cfg.BlockInterval,
+		}}
+	}
+
+	generators := []*topicGenerator{}
+	names := core.NewSet()
+	for _, topicCfg := range topicCfgs {
+		generator, err := newTopicGenerator(sched, topicCfg, rng, logger)
+		if err != nil {
+			return nil, err
+		}
+		if names.Exists(generator.name) {
+			return nil, fmt.Errorf("%w: %v", DuplicateTopicErr, generator.name)
+		}
+		names.Add(generator.name)
+		generators = append(generators, generator)
+	}
+	return generators, nil
+}
+
+func newTopicGenerator(
+	sched *core.Scheduler,
+	cfg *TopicConfig,
+	rng exprand.Source,
+	logger *zap.Logger,
+) (*topicGenerator, error) {
+	if cfg.Name == nil {
+		return nil, UnspecTopicNameErr
+	}
+	if cfg.MsgInterval == nil {
+		return nil, fmt.Errorf("%w: %v", UnspecMsgIntervalErr, *cfg.Name)
+	}
+	msgSize := int64(pubsub.BlockSize)
+	if cfg.MsgSize != nil {
+		msgSize = *cfg.MsgSize
+	}
+	if msgSize <= 0 {
+		return nil, fmt.Errorf("%w: %v", InvMsgSizeErr, *cfg.Name)
+	}
+	subscribeFraction := SubscribeFraction
+	if cfg.SubscribeFraction != nil {
+		subscribeFraction = *cfg.SubscribeFraction
+	}
+	if subscribeFraction < 0 || subscribeFraction > 1 {
+		return nil, fmt.Errorf("%w: %v", InvSubscribeFracErr, *cfg.Name)
+	}
+	publishFraction := PublishFraction
+	if cfg.PublishFraction != nil {
+		publishFraction = *cfg.PublishFraction
+	}
+	if publishFraction < 0 || subscribeFraction+publishFraction > 1 {
+		return nil, fmt.Errorf("%w: %v", InvPublishFracErr, *cfg.Name)
+	}
+
+	oracle, err := core.NewBlockGenerator(sched, *cfg.MsgInterval, rng, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &topicGenerator{
+		name:              *cfg.Name,
+		msgSize:           msgSize,
+		subscribeFraction: subscribeFraction,
+		publishFraction:   publishFraction,
+		oracle:            oracle,
+	}, nil
+}
+
+// Subscribes the node to the topics and registers it as a publisher on the subscribed topics
+//   and the topics it publishes on without subscribing
+// Called before starting the node so that the subscriptions are announced on starting
+func joinTopics(node *pubsub.Node, generators []*topicGenerator, rng exprand.Source) {
+	for _, generator := range generators {
+		// no random numbers are drawn if every node subscribes to the topic
+		if generator.subscribeFraction < 1 {
+			sample := exprand.New(rng).Float64()
+			if sample >= generator.subscribeFraction+generator.publishFraction {
+				continue
+			}
+			if sample >= generator.subscribeFraction {
+				node.AddPublisher(generator.oracle, generator.name, generator.msgSize)
+				continue
+			}
+		}
+		node.Subscribe(generator.name)
+		node.AddPublisher(generator.oracle, generator.name, generator.msgSize)
+	}
+}